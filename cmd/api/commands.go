@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/messkan/PromptCache/internal/cache"
+	"github.com/messkan/PromptCache/internal/httpclient"
+	"github.com/messkan/PromptCache/internal/semantic"
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+const commandUsage = `Usage: prompt-cache [command]
+
+Without a command the server starts. Commands run against the storage
+configured by the environment; stop the server first, as Badger locks its
+data directory. Use the /admin API to back up a running server.
+
+Commands:
+  backup [-since N] FILE   Write a backup, incremental from version N
+  restore [-force] FILE    Load a backup into an empty store
+  export [-namespace NS] [-model M] [-max-age D] [-min-age D] FILE
+                           Write entries as JSONL; FILE - writes to stdout
+  import FILE              Load a JSONL export, embedding prompts again for
+                           the configured provider; FILE - reads stdin
+  train-dict [-size BYTES] [-samples N] FILE
+                           Train a zstd dictionary on cached responses for
+                           CACHE_COMPRESSION_DICT
+`
+
+// runCommand runs a maintenance command and returns the exit code
+func runCommand(args []string) int {
+	var err error
+	switch args[0] {
+	case "backup":
+		err = backupCommand(args[1:])
+	case "restore":
+		err = restoreCommand(args[1:])
+	case "export":
+		err = exportCommand(args[1:])
+	case "import":
+		err = importCommand(args[1:])
+	case "train-dict":
+		err = trainDictCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(commandUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", args[0], commandUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// openBackuper opens the configured storage, which must support backups
+func openBackuper() (storage.Storage, storage.Backuper, error) {
+	config := storage.LoadConfig()
+	store, err := storage.Open(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	backuper, ok := storage.Base(store).(storage.Backuper)
+	if !ok {
+		store.Close()
+		return nil, nil, fmt.Errorf("%s storage does not support backups", config.Backend)
+	}
+	return store, backuper, nil
+}
+
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	since := flags.Uint64("since", 0, "only back up records written after this version")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one backup file")
+	}
+
+	store, backuper, err := openBackuper()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	f, err := os.Create(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	next, err := backuper.Backup(f, *since)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup written to %s; next incremental backup: -since %d\n", flags.Arg(0), next)
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	force := flags.Bool("force", false, "restore into a store that already holds data")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one backup file")
+	}
+
+	store, backuper, err := openBackuper()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// Incremental backups are restored on top of the full one with -force
+	items, _, err := store.Scan(context.Background(), "", "", 1)
+	if err != nil {
+		return err
+	}
+	if len(items) > 0 && !*force {
+		return fmt.Errorf("storage is not empty; use -force to restore over existing data")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	marker, err := cache.RestoreBackup(context.Background(), store, backuper, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup %s restored\n", flags.Arg(0))
+	if marker.MigratingFrom != 0 {
+		fmt.Printf("The backup holds entries from schema %d; they are migrated on the next start\n", marker.MigratingFrom)
+	}
+	return nil
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	var filter cache.ExportFilter
+	flags.StringVar(&filter.Namespace, "namespace", "", "only export vectors of this embedding namespace")
+	flags.StringVar(&filter.Model, "model", "", "only export entries for this model")
+	flags.DurationVar(&filter.MaxAge, "max-age", 0, "only export entries at most this old")
+	flags.DurationVar(&filter.MinAge, "min-age", 0, "only export entries at least this old")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one export file")
+	}
+
+	store, err := storage.Open(storage.LoadConfig())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	c, err := newCache(store)
+	if err != nil {
+		return err
+	}
+	if _, err := cache.CheckSchema(context.Background(), store); err != nil {
+		return err
+	}
+	var count int
+	if flags.Arg(0) == "-" {
+		count, err = c.Export(context.Background(), os.Stdout, filter)
+	} else {
+		f, createErr := os.Create(flags.Arg(0))
+		if createErr != nil {
+			return createErr
+		}
+		count, err = c.Export(context.Background(), f, filter)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d entries exported\n", count)
+	return nil
+}
+
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one export file")
+	}
+
+	store, err := storage.Open(storage.LoadConfig())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	embedder, err := newEmbedder(store)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	c, err := newCache(store)
+	if err != nil {
+		return err
+	}
+	if _, err := cache.CheckSchema(context.Background(), store); err != nil {
+		return err
+	}
+	stats, err := c.Import(context.Background(), r, embedder)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d entries imported, %d embedded again, %d skipped\n", stats.Imported, stats.Reembedded, stats.Skipped)
+	return nil
+}
+
+// newCache creates a cache over store with the configured compression, so
+// commands can read what the server wrote
+func newCache(store storage.Storage) (*cache.Cache, error) {
+	config, err := cache.LoadCodecConfig()
+	if err != nil {
+		return nil, err
+	}
+	codec, err := cache.NewCodec(config)
+	if err != nil {
+		return nil, err
+	}
+	c := cache.NewCache(store)
+	c.SetCodec(codec)
+	return c, nil
+}
+
+// newEmbedder builds the embedding failover chain the server would use, so
+// imported entries are searchable once it starts
+func newEmbedder(store storage.Storage) (*semantic.SemanticEngine, error) {
+	httpClient, err := httpclient.New(httpclient.LoadConfig())
+	if err != nil {
+		return nil, err
+	}
+	httpclient.SetDefault(httpClient)
+
+	if err := semantic.LoadInstances(); err != nil {
+		return nil, err
+	}
+	provider, err := semantic.NewProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	config := semantic.LoadConfig()
+	var fallbacks []semantic.NamedEmbedder
+	for _, name := range config.FallbackProviders {
+		fallback, err := semantic.NewProviderByName(name)
+		if err != nil {
+			return nil, err
+		}
+		fallbacks = append(fallbacks, semantic.NamedEmbedder{Name: name, Provider: fallback})
+	}
+
+	engine := semantic.NewSemanticEngine(provider, store, nil, config)
+	engine.SetFallbacks(fallbacks)
+	return engine, nil
+}
+
+func trainDictCommand(args []string) error {
+	flags := flag.NewFlagSet("train-dict", flag.ContinueOnError)
+	size := flags.Int("size", cache.DefaultDictSize, "dictionary size in bytes")
+	maxSamples := flags.Int("samples", 5000, "maximum number of cached responses to train on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one dictionary file")
+	}
+
+	store, err := storage.Open(storage.LoadConfig())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	c, err := newCache(store)
+	if err != nil {
+		return err
+	}
+	samples, err := c.Responses(context.Background(), *maxSamples)
+	if err != nil {
+		return err
+	}
+
+	dict, err := cache.TrainDictionary(samples, *size)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(flags.Arg(0), dict, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Dictionary of %d bytes trained on %d responses written to %s\n", len(dict), len(samples), flags.Arg(0))
+	return nil
+}
@@ -2,23 +2,43 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/messkan/PromptCache/internal/cache"
+	"github.com/messkan/PromptCache/internal/httpclient"
+	"github.com/messkan/PromptCache/internal/savings"
 	"github.com/messkan/PromptCache/internal/semantic"
+	"github.com/messkan/PromptCache/internal/shadow"
 	"github.com/messkan/PromptCache/internal/storage"
 )
 
+// upstreamURL is the chat completions endpoint requests are forwarded to
+const upstreamURL = "https://api.openai.com/v1/chat/completions"
+
+// tagsHeader lists tags for the cached entry, comma-separated, like the
+// cache_tags body field
+const tagsHeader = "X-Cache-Tags"
+
 type ChatCompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model     string          `json:"model"`
+	Messages  []Message       `json:"messages"`
+	CacheTags json.RawMessage `json:"cache_tags"` // Tags, stripped before the request is forwarded
 }
 
 type Message struct {
@@ -27,37 +47,192 @@ type Message struct {
 }
 
 func main() {
-	// Initialize Storage
-	store, err := storage.NewBadgerStore("./badger_data")
+	// Maintenance commands, e.g. "backup" and "restore", run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Initialize Storage; the backend is selected by STORAGE_BACKEND
+	storageConfig := storage.LoadConfig()
+	store, err := storage.Open(storageConfig)
 	if err != nil {
-		log.Fatalf("Failed to initialize BadgerDB: %v", err)
+		log.Fatalf("Failed to initialize %s storage: %v", storageConfig.Backend, err)
 	}
 	defer store.Close()
+	log.Printf("Storage backend: %s", storageConfig.Backend)
+
+	// Background jobs stop before storage is closed
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	var jobs sync.WaitGroup
+	startJob := func(job func()) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job()
+		}()
+	}
+
+	// Records under older keys, or written before encryption was enabled, are
+	// re-encrypted with the active key in the background
+	encrypted, _ := storage.Layer[*storage.EncryptedStore](store)
+	if encrypted != nil {
+		log.Printf("Encryption at rest enabled: active key %d", encrypted.Status().ActiveVersion)
+		startJob(func() { encrypted.RunRotation(jobsCtx, storageConfig.Encryption.RotationInterval) })
+	}
+
+	// Hot responses, prompts and every vector are served from memory
+	tiered, _ := storage.Layer[*storage.TieredStore](store)
+	if tiered != nil {
+		log.Printf("L1 cache enabled: %d bytes", storageConfig.L1.MaxBytes)
+	}
+
+	// Shared outbound HTTP client; providers pick it up when they are created
+	httpConfig := httpclient.LoadConfig()
+	httpClient, err := httpclient.New(httpConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialize HTTP client: %v", err)
+	}
+	httpclient.SetDefault(httpClient)
+	// Upstream calls are bounded by HTTP_UPSTREAM_TIMEOUT alone, so long and
+	// streamed completions are not cut off by HTTP_TIMEOUT
+	upstreamClient := httpclient.WithoutTimeout(httpClient)
+
+	// Register named provider instances before any provider is created
+	if err := semantic.LoadInstances(); err != nil {
+		log.Fatalf("Failed to load provider instances: %v", err)
+	}
 
 	// Initialize Semantic Engine with provider from environment
 	provider, err := semantic.NewProvider()
 	if err != nil {
 		log.Fatalf("Failed to initialize embedding provider: %v", err)
 	}
-	
+
 	// Load configuration from environment variables
 	config := semantic.LoadConfig()
 	log.Printf("Cache Configuration: HighThreshold=%.2f, LowThreshold=%.2f, GrayZoneVerifier=%v",
 		config.HighThreshold, config.LowThreshold, config.EnableGrayZoneVerifier)
-	
-	semanticEngine := semantic.NewSemanticEngine(provider, store, provider, config)
 
-	// Initialize Cache
-	c := cache.NewCache(store)
+	// Providers tried in order when the primary embedding provider fails
+	var fallbacks []semantic.NamedEmbedder
+	for _, name := range config.FallbackProviders {
+		fallback, err := semantic.NewProviderByName(name)
+		if err != nil {
+			log.Fatalf("Failed to initialize fallback embedding provider: %v", err)
+		}
+		fallbacks = append(fallbacks, semantic.NamedEmbedder{Name: name, Provider: fallback})
+	}
+
+	// The verifier follows the embedding provider unless VERIFIER_PROVIDER is set
+	verifier, err := semantic.NewVerifierProvider()
+	if err != nil {
+		log.Fatalf("Failed to initialize verifier provider: %v", err)
+	}
+
+	// Rate limits and daily budgets on embedding and verification calls
+	budgetConfig, err := semantic.LoadBudgetConfig()
+	if err != nil {
+		log.Fatalf("Failed to load provider budgets: %v", err)
+	}
+
+	semanticEngine := semantic.NewSemanticEngine(provider, store, verifier, config)
+	semanticEngine.SetFallbacks(fallbacks)
+	semanticEngine.SetBudgets(semantic.NewBudgets(budgetConfig))
+	if len(fallbacks) > 0 {
+		log.Printf("Embedding failover chain: %s -> %s", semanticEngine.GetCurrentProvider(), strings.Join(config.FallbackProviders, " -> "))
+		startJob(func() { runBackfill(jobsCtx, semanticEngine, backfillInterval()) })
+	}
+
+	verifierStatus := semanticEngine.GetCurrentVerifier()
+	log.Printf("Verifier Configuration: Provider=%s, Model=%s", verifierStatus.Provider, verifierStatus.Model)
+
+	// Initialize Cache; responses are compressed as configured
+	c, err := newCache(store)
+	if err != nil {
+		log.Fatalf("Failed to initialize cache: %v", err)
+	}
+	c.SetNamespaces(semanticEngine.Namespaces())
+
+	// Entries written in an older schema are migrated in the background
+	schema, err := cache.CheckSchema(jobsCtx, store)
+	if err != nil {
+		log.Fatalf("Failed to check the storage schema: %v", err)
+	}
+	migrator := cache.NewMigrator(c, store, schema, semanticEngine.Namespaces())
+	startJob(func() { migrator.Run(jobsCtx) })
+
+	// Thresholds set through the API outlive a restart
+	thresholdStore := cache.NewThresholdStore(store, semanticEngine)
+	if loaded, err := thresholdStore.Load(jobsCtx); err != nil {
+		log.Fatalf("Failed to load the cache thresholds: %v", err)
+	} else if loaded {
+		thresholds := semanticEngine.Thresholds()
+		log.Printf("Cache thresholds restored from storage: HighThreshold=%.2f, LowThreshold=%.2f, GrayZoneVerifier=%v",
+			thresholds.High, thresholds.Low, thresholds.GrayZoneVerifier)
+	}
+	ttl := cacheTTL()
+
+	// Expired and orphaned entries are deleted in the background
+	sweeper := cache.NewSweeper(c, store)
+	startJob(func() { sweeper.Run(jobsCtx, sweepInterval()) })
+
+	// Savings are accounted per model, namespace and day
+	prices, err := savings.LoadPrices()
+	if err != nil {
+		log.Fatalf("Failed to load model prices: %v", err)
+	}
+	tracker := savings.NewTracker(store, prices)
+
+	// Entries beyond the capacity limits are evicted by policy
+	evictionConfig, err := cache.LoadEvictionConfig()
+	if err != nil {
+		log.Fatalf("Failed to load eviction config: %v", err)
+	}
+	var evictor *cache.Evictor
+	if evictionConfig.Enabled() {
+		evictor = cache.NewEvictor(c, evictionConfig, func(response []byte) float64 {
+			model, usage, err := savings.ParseResponse(response)
+			if err != nil {
+				return 0
+			}
+			return prices.Cost(model, usage)
+		})
+		if err := evictor.Load(jobsCtx); err != nil {
+			log.Fatalf("Failed to load cache entries for eviction: %v", err)
+		}
+		log.Printf("Cache capacity: max_entries=%d, max_bytes=%d, policy=%s", evictionConfig.MaxEntries, evictionConfig.MaxBytes, evictionConfig.Policy)
+		startJob(func() { evictor.Run(jobsCtx) })
+	}
+
+	// Shadow mode computes lookups but always forwards upstream
+	shadowConfig := shadow.LoadConfig()
+	var evaluator *shadow.Evaluator
+	if shadowConfig.Enabled {
+		evaluator = shadow.NewEvaluator(semanticEngine, shadowConfig)
+		log.Printf("Shadow mode enabled: cached responses are evaluated but never served (judge=%v)", shadowConfig.EnableJudge)
+	}
 
 	r := gin.Default()
 
+	// Endpoints that change or expose cached data require the admin token
+	requireAdmin := adminAuth(os.Getenv("ADMIN_TOKEN"))
+
 	// Provider management endpoints
 	r.GET("/v1/config/provider", func(cGin *gin.Context) {
 		currentProvider := semanticEngine.GetCurrentProvider()
 		cGin.JSON(http.StatusOK, gin.H{
-			"provider": currentProvider,
-			"available_providers": []string{"openai", "mistral", "claude"},
+			"provider":            currentProvider,
+			"verifier":            semanticEngine.GetCurrentVerifier(),
+			"health":              semanticEngine.ProviderHealth(),
+			"available_providers": semantic.AvailableProviders(),
+		})
+	})
+
+	r.GET("/v1/providers", func(cGin *gin.Context) {
+		cGin.JSON(http.StatusOK, gin.H{
+			"types":     semantic.ProviderSpecs(),
+			"instances": semantic.ProviderInstances(),
 		})
 	})
 
@@ -78,11 +253,81 @@ func main() {
 
 		log.Printf("Provider switched to: %s", req.Provider)
 		cGin.JSON(http.StatusOK, gin.H{
-			"message": "Provider updated successfully",
+			"message":  "Provider updated successfully",
 			"provider": req.Provider,
 		})
 	})
 
+	r.GET("/v1/config/verifier", func(cGin *gin.Context) {
+		cGin.JSON(http.StatusOK, semanticEngine.GetCurrentVerifier())
+	})
+
+	r.POST("/v1/config/verifier", requireAdmin, func(cGin *gin.Context) {
+		var req struct {
+			Provider string `json:"provider" binding:"required"`
+			semantic.VerifierOptions
+		}
+
+		if err := cGin.ShouldBindJSON(&req); err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "provider field is required"})
+			return
+		}
+
+		if err := semanticEngine.SetVerifier(req.Provider, req.VerifierOptions); err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		status := semanticEngine.GetCurrentVerifier()
+		log.Printf("Verifier switched to: %s (model %s)", status.Provider, status.Model)
+		cGin.JSON(http.StatusOK, gin.H{
+			"message":  "Verifier updated successfully",
+			"verifier": status,
+		})
+	})
+
+	r.GET("/v1/config/cache", func(cGin *gin.Context) {
+		record, err := thresholdStore.Record(cGin.Request.Context())
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cGin.JSON(http.StatusOK, record)
+	})
+
+	r.PUT("/v1/config/cache", requireAdmin, func(cGin *gin.Context) {
+		var req struct {
+			cache.ThresholdPatch
+			Reason string `json:"reason"`
+		}
+
+		if err := cGin.ShouldBindJSON(&req); err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if req.ThresholdPatch.IsZero() {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "high_threshold, low_threshold or gray_zone_verifier is required"})
+			return
+		}
+
+		// Fields left out keep their current value; the merge happens under
+		// the store's lock so concurrent updates do not undo each other
+		record, err := thresholdStore.Update(cGin.Request.Context(), req.ThresholdPatch, req.Reason)
+		if errors.Is(err, cache.ErrInvalidThresholds) {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the thresholds: " + err.Error()})
+			return
+		}
+
+		thresholds := record.Thresholds
+		log.Printf("Cache thresholds updated: HighThreshold=%.2f, LowThreshold=%.2f, GrayZoneVerifier=%v",
+			thresholds.High, thresholds.Low, thresholds.GrayZoneVerifier)
+		cGin.JSON(http.StatusOK, record)
+	})
+
 	r.POST("/v1/chat/completions", func(cGin *gin.Context) {
 		var req ChatCompletionRequest
 		// We need to read the body but also keep it for forwarding
@@ -99,14 +344,23 @@ func main() {
 			return
 		}
 
-		// Extract prompt (last user message)
-		prompt := ""
-		for i := len(req.Messages) - 1; i >= 0; i-- {
-			if req.Messages[i].Role == "user" {
-				prompt = req.Messages[i].Content
-				break
+		// Tags are ours; the upstream API would reject the unknown field
+		tags, err := cache.ParseTags(cGin.GetHeader(tagsHeader))
+		if err == nil && req.CacheTags != nil {
+			var bodyTags []string
+			if json.Unmarshal(req.CacheTags, &bodyTags) != nil {
+				err = errors.New("cache_tags must be an array of strings")
+			} else if tags, err = cache.NormalizeTags(append(tags, bodyTags...)); err == nil {
+				bodyBytes, err = stripField(bodyBytes, "cache_tags")
 			}
 		}
+		if err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Extract prompt (last user message)
+		prompt := lastUserPrompt(req.Messages)
 
 		if prompt == "" {
 			cGin.JSON(http.StatusBadRequest, gin.H{"error": "No user prompt found"})
@@ -116,32 +370,77 @@ func main() {
 		ctx := cGin.Request.Context()
 
 		// 1. Check Semantic Cache
-		similarKey, score, err := semanticEngine.FindSimilar(ctx, prompt)
+		decision, err := semanticEngine.Lookup(ctx, prompt)
 		if err != nil {
 			log.Printf("Semantic search error: %v", err)
 		}
 
-		if similarKey != "" {
-			log.Printf("🔥 Cache HIT! Score: %f, Key: %s", score, similarKey)
-			// The key in semantic storage has "emb:" prefix, but cache storage does not.
-			actualKey := strings.TrimPrefix(similarKey, "emb:")
+		// An exhausted provider budget degrades the lookup to exact matching,
+		// or bypasses the cache entirely under the pass_through policy
+		var budgetErr *semantic.BudgetError
+		exhausted := errors.As(err, &budgetErr)
+		passThrough := exhausted && budgetErr.Policy == semantic.PolicyPassThrough
+
+		if evaluator == nil && exhausted && !passThrough {
+			cachedResp, found, err := c.Get(ctx, cache.GenerateKey(prompt))
+			if err == nil && found {
+				log.Printf("🔥 Exact cache HIT while %s", budgetErr.Reason)
+				recordSavings(ctx, tracker, savings.Event{Model: req.Model, Namespace: "exact", Hit: true, Response: cachedResp})
+				cGin.Data(http.StatusOK, "application/json", cachedResp)
+				return
+			}
+		}
+
+		// Spend on embedding and verification calls made for this request
+		var overhead float64
+		namespace := "none"
+		if decision != nil {
+			overhead = decision.Cost
+			namespace = decision.Namespace
+		}
+
+		if evaluator == nil && decision != nil && decision.Hit() {
+			log.Printf("🔥 Cache HIT! Score: %f, Key: %s", decision.Score, decision.Key)
+			// The key in semantic storage has an "emb:<namespace>:" prefix, but cache storage does not.
+			actualKey := semantic.HashFromKey(decision.Key)
 			cachedResp, found, err := c.Get(ctx, actualKey)
 			if err == nil && found {
+				if err := store.RecordHit(ctx, actualKey); err != nil {
+					log.Printf("Failed to record hit: %v", err)
+				}
+				if evictor != nil {
+					evictor.Touch(actualKey)
+				}
+				recordSavings(ctx, tracker, savings.Event{Model: req.Model, Namespace: namespace, Hit: true, Response: cachedResp, Overhead: overhead})
 				cGin.Data(http.StatusOK, "application/json", cachedResp)
 				return
 			}
+
+			// The response is gone; drop its records so the stale vector
+			// no longer shadows live candidates
+			if err == nil {
+				if err := c.DeleteEntry(ctx, actualKey, decision.Key); err != nil {
+					log.Printf("Failed to delete stale entry: %v", err)
+				}
+			}
 		}
 
-		log.Println("💨 Cache MISS. Forwarding to OpenAI...")
+		if evaluator != nil {
+			log.Println("👻 Shadow mode. Forwarding to OpenAI...")
+		} else {
+			log.Println("💨 Cache MISS. Forwarding to OpenAI...")
+		}
 
 		// 2. Forward to OpenAI
+		upstreamCtx, cancel := httpclient.WithTimeout(ctx, httpConfig.UpstreamTimeout)
+		defer cancel()
+
 		apiKey := os.Getenv("OPENAI_API_KEY")
-		openAIReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(bodyBytes))
+		openAIReq, _ := http.NewRequestWithContext(upstreamCtx, "POST", upstreamURL, bytes.NewBuffer(bodyBytes))
 		openAIReq.Header.Set("Content-Type", "application/json")
 		openAIReq.Header.Set("Authorization", "Bearer "+apiKey)
 
-		client := &http.Client{}
-		resp, err := client.Do(openAIReq)
+		resp, err := upstreamClient.Do(openAIReq)
 		if err != nil {
 			log.Printf("Failed to call OpenAI: %v", err)
 			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to call OpenAI: " + err.Error()})
@@ -156,35 +455,763 @@ func main() {
 			return
 		}
 
+		// Compare the fresh response with the candidate we would have served
+		if evaluator != nil && resp.StatusCode == http.StatusOK {
+			shadowEvaluate(jobsCtx, evaluator, c, decision, respBody)
+		}
+
 		// 3. Cache Response & Embedding
-		if resp.StatusCode == http.StatusOK {
+		if resp.StatusCode == http.StatusOK && !passThrough {
 			key := cache.GenerateKey(prompt)
 
-			// Save Response
-			if err := c.Set(ctx, key, respBody, 24*time.Hour); err != nil {
-				log.Printf("Failed to cache response: %v", err)
+			// Embed in the namespace of whichever provider is healthy
+			embKey, vec, err := semanticEngine.EmbedEntry(ctx, key, prompt)
+			if err != nil {
+				log.Printf("Failed to embed prompt: %v", err)
+			} else {
+				overhead += semanticEngine.Budgets().Cost(namespace, semantic.CallEmbed, semantic.EstimateTokens(prompt))
 			}
 
-			// Save Prompt for Verification
-			if err := store.Set(ctx, "prompt:"+key, []byte(prompt)); err != nil {
-				log.Printf("Failed to save prompt: %v", err)
+			// Response, prompt, embedding and metadata are written together
+			entry := &storage.Entry{
+				Key:    key,
+				Value:  respBody,
+				Prompt: []byte(prompt),
+				Metadata: storage.EntryMetadata{
+					Model:             req.Model,
+					Upstream:          upstreamURL,
+					ParamsFingerprint: cache.ParamsFingerprint(bodyBytes),
+					Tags:              tags,
+					TTL:               ttl,
+				},
+			}
+			if embKey != "" {
+				embNamespace, _ := semantic.ParseEmbeddingKey(embKey)
+				entry.Embeddings = map[string][]byte{embKey: vec}
+				entry.Metadata.EmbeddingProvider = embNamespace
+				entry.Metadata.EmbeddingModel = semanticEngine.EmbeddingModel(embNamespace)
 			}
 
-			// Save Embedding
-			embedding, err := semanticEngine.GetProvider().Embed(ctx, prompt)
-			if err == nil {
-				embBytes := semantic.Float32ToBytes(embedding)
-				if err := store.Set(ctx, "emb:"+key, embBytes); err != nil {
-					log.Printf("Failed to save embedding: %v", err)
-				}
-			} else {
-				log.Printf("Failed to generate embedding: %v", err)
+			if err := c.SetEntry(ctx, entry); err != nil {
+				log.Printf("Failed to cache response: %v", err)
+			} else if evictor != nil {
+				evictor.Track(key, respBody, prompt, ttl, slices.Collect(maps.Keys(entry.Embeddings))...)
 			}
 		}
 
+		if !passThrough {
+			recordSavings(ctx, tracker, savings.Event{Model: req.Model, Namespace: namespace, Overhead: overhead})
+		}
+
 		cGin.Data(resp.StatusCode, "application/json", respBody)
 	})
 
-	log.Println("🚀 PromptCache Server running on :8080")
-	r.Run(":8080")
+	// Explanations include the stored prompts of other clients' entries
+	r.POST("/v1/cache/explain", requireAdmin, func(cGin *gin.Context) {
+		var req struct {
+			Prompt   string    `json:"prompt"`
+			Messages []Message `json:"messages"`
+			TopK     int       `json:"top_k"`
+			Verify   *bool     `json:"verify"`
+		}
+
+		if err := cGin.ShouldBindJSON(&req); err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+
+		prompt := req.Prompt
+		if prompt == "" {
+			prompt = lastUserPrompt(req.Messages)
+		}
+		if prompt == "" {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "No user prompt found"})
+			return
+		}
+
+		topK := req.TopK
+		if topK <= 0 {
+			topK = 5
+		}
+		verify := req.Verify == nil || *req.Verify
+
+		ctx := cGin.Request.Context()
+		explanation, err := semanticEngine.Explain(ctx, prompt, topK, verify)
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Semantic search error: " + err.Error()})
+			return
+		}
+
+		cGin.JSON(http.StatusOK, explainResponse(ctx, c, explanation))
+	})
+
+	// Entry management exposes prompts and responses, so it requires the
+	// admin token as well
+	entries := r.Group("/v1/cache/entries", requireAdmin)
+
+	entries.GET("", func(cGin *gin.Context) {
+		filter, err := entryFilter(cGin)
+		if err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit := 100
+		if val := cGin.Query("limit"); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 || n > 1000 {
+				cGin.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+				return
+			}
+			limit = n
+		}
+
+		page, err := c.ListEntries(cGin.Request.Context(), filter, cGin.Query("cursor"), limit)
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list entries: " + err.Error()})
+			return
+		}
+		cGin.JSON(http.StatusOK, page)
+	})
+
+	entries.GET("/:key", func(cGin *gin.Context) {
+		entry, err := c.Entry(cGin.Request.Context(), cGin.Param("key"))
+		if errors.Is(err, storage.ErrNotFound) {
+			cGin.JSON(http.StatusNotFound, gin.H{"error": "entry not found"})
+			return
+		}
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read entry: " + err.Error()})
+			return
+		}
+		cGin.JSON(http.StatusOK, entry)
+	})
+
+	entries.DELETE("/:key", func(cGin *gin.Context) {
+		key := cGin.Param("key")
+		deleted, err := c.DeleteEntries(cGin.Request.Context(), []string{key})
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete entry: " + err.Error()})
+			return
+		}
+		if deleted == 0 {
+			cGin.JSON(http.StatusNotFound, gin.H{"error": "entry not found"})
+			return
+		}
+		log.Printf("Deleted cache entry %s", key)
+		cGin.JSON(http.StatusOK, gin.H{"deleted": deleted})
+	})
+
+	entries.DELETE("", func(cGin *gin.Context) {
+		filter, err := entryFilter(cGin)
+		if err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Emptying the whole cache must be asked for explicitly
+		if filter.IsZero() && cGin.Query("all") != "true" {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "set a filter, or all=true to delete every entry"})
+			return
+		}
+
+		deleted, err := c.Purge(cGin.Request.Context(), filter)
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge entries: " + err.Error(), "deleted": deleted})
+			return
+		}
+		log.Printf("Purged %d cache entries", deleted)
+		cGin.JSON(http.StatusOK, gin.H{"deleted": deleted})
+	})
+
+	r.DELETE("/v1/cache/tags/:tag", requireAdmin, func(cGin *gin.Context) {
+		tag := cGin.Param("tag")
+		if _, err := cache.NormalizeTags([]string{tag}); err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		deleted, err := c.InvalidateTag(cGin.Request.Context(), tag)
+		if errors.Is(err, cache.ErrTagTooLarge) {
+			cGin.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invalidate tag: " + err.Error()})
+			return
+		}
+		log.Printf("Invalidated %d entries tagged %s", deleted, tag)
+		cGin.JSON(http.StatusOK, gin.H{"tag": tag, "deleted": deleted})
+	})
+
+	// Drops every entry close to a prompt, e.g. once the facts it asks about change
+	r.POST("/v1/cache/invalidate", requireAdmin, func(cGin *gin.Context) {
+		var req struct {
+			Prompt    string    `json:"prompt"`
+			Messages  []Message `json:"messages"`
+			Embedding []float32 `json:"embedding"`
+			Namespace string    `json:"namespace"`
+			Threshold *float32  `json:"threshold"`
+			DryRun    bool      `json:"dry_run"`
+		}
+
+		if err := cGin.ShouldBindJSON(&req); err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+
+		prompt := req.Prompt
+		if prompt == "" {
+			prompt = lastUserPrompt(req.Messages)
+		}
+		if prompt == "" && len(req.Embedding) == 0 {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "prompt, messages or embedding is required"})
+			return
+		}
+		if req.Namespace != "" && len(req.Embedding) == 0 {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "namespace only applies to an embedding"})
+			return
+		}
+
+		threshold := semanticEngine.Thresholds().High
+		if req.Threshold != nil {
+			threshold = *req.Threshold
+		}
+		if threshold <= 0 || threshold > 1 {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be greater than 0 and at most 1"})
+			return
+		}
+
+		ctx := cGin.Request.Context()
+		var queryEmb []float32
+		if len(req.Embedding) > 0 {
+			queryEmb = req.Embedding
+		}
+		candidates, namespace, err := semanticEngine.Neighborhood(ctx, prompt, queryEmb, req.Namespace, threshold)
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Semantic search error: " + err.Error()})
+			return
+		}
+
+		// An entry can match through its vector with and without a namespace
+		matched := []gin.H{}
+		var keys []string
+		seen := make(map[string]bool)
+		for _, candidate := range candidates {
+			key := semantic.HashFromKey(candidate.Key)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keys = append(keys, key)
+			entryPrompt, _ := store.GetPrompt(ctx, key)
+			matched = append(matched, gin.H{"key": key, "prompt": entryPrompt, "score": candidate.Score})
+		}
+
+		response := gin.H{
+			"namespace": namespace,
+			"threshold": threshold,
+			"dry_run":   req.DryRun,
+			"matched":   len(matched),
+			"entries":   matched,
+		}
+		if req.DryRun {
+			cGin.JSON(http.StatusOK, response)
+			return
+		}
+
+		deleted, err := c.DeleteEntries(ctx, keys)
+		response["deleted"] = deleted
+		if err != nil {
+			response["error"] = "Failed to delete entries: " + err.Error()
+			cGin.JSON(http.StatusInternalServerError, response)
+			return
+		}
+		log.Printf("Invalidated %d entries within %.2f of the query in namespace %s", deleted, threshold, namespace)
+		cGin.JSON(http.StatusOK, response)
+	})
+
+	r.GET("/v1/stats/budgets", func(cGin *gin.Context) {
+		budgets := semanticEngine.Budgets()
+		cGin.JSON(http.StatusOK, gin.H{
+			"policy": budgets.Policy(),
+			"usage":  budgets.Usage(),
+		})
+	})
+
+	r.GET("/v1/stats/storage", func(cGin *gin.Context) {
+		stats := gin.H{"backend": storageConfig.Backend, "schema": migrator.Status()}
+		if tiered != nil {
+			stats["l1"] = tiered.Stats()
+		}
+		switch s := storage.Base(store).(type) {
+		case *storage.BadgerStore:
+			stats["badger"] = s.Stats()
+		case *storage.MemoryStore:
+			stats["entries"], stats["bytes"] = s.Len()
+		}
+		cGin.JSON(http.StatusOK, stats)
+	})
+
+	r.GET("/v1/stats/encryption", func(cGin *gin.Context) {
+		if encrypted == nil {
+			cGin.JSON(http.StatusOK, storage.EncryptionStatus{})
+			return
+		}
+		cGin.JSON(http.StatusOK, encrypted.Status())
+	})
+
+	r.GET("/v1/stats/eviction", func(cGin *gin.Context) {
+		if evictor == nil {
+			cGin.JSON(http.StatusNotFound, gin.H{"error": "cache capacity limits are not configured"})
+			return
+		}
+		cGin.JSON(http.StatusOK, evictor.Stats())
+	})
+
+	r.GET("/v1/stats/savings", func(cGin *gin.Context) {
+		// The last 30 days, up to today or the day set by to
+		to := time.Now().UTC().Truncate(24 * time.Hour)
+		if val := cGin.Query("to"); val != "" {
+			day, err := time.Parse("2006-01-02", val)
+			if err != nil {
+				cGin.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date (YYYY-MM-DD)"})
+				return
+			}
+			to = day
+		}
+		from := to.AddDate(0, 0, -29)
+		if val := cGin.Query("from"); val != "" {
+			day, err := time.Parse("2006-01-02", val)
+			if err != nil {
+				cGin.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date (YYYY-MM-DD)"})
+				return
+			}
+			from = day
+		}
+		if from.After(to) || to.Sub(from) > 366*24*time.Hour {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to and at most a year apart"})
+			return
+		}
+
+		report, err := tracker.Report(cGin.Request.Context(), from, to)
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read savings: " + err.Error()})
+			return
+		}
+		cGin.JSON(http.StatusOK, report)
+	})
+
+	r.GET("/v1/shadow/stats", func(cGin *gin.Context) {
+		if evaluator == nil {
+			cGin.JSON(http.StatusNotFound, gin.H{"error": "shadow mode is not enabled"})
+			return
+		}
+		cGin.JSON(http.StatusOK, evaluator.Report())
+	})
+
+	// Admin endpoints expose the whole cache and require ADMIN_TOKEN
+	admin := r.Group("/admin", requireAdmin)
+
+	admin.POST("/backup", func(cGin *gin.Context) {
+		backuper, ok := storage.Base(store).(storage.Backuper)
+		if !ok {
+			cGin.JSON(http.StatusNotImplemented, gin.H{"error": storageConfig.Backend + " storage does not support backups"})
+			return
+		}
+
+		var since uint64
+		if val := cGin.Query("since"); val != "" {
+			n, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				cGin.JSON(http.StatusBadRequest, gin.H{"error": "since must be a version number"})
+				return
+			}
+			since = n
+		}
+
+		// The next since is only known once the stream ends, so it is sent as
+		// a trailer; a backup without it was cut short
+		cGin.Header("Content-Type", "application/octet-stream")
+		cGin.Header("Content-Disposition", `attachment; filename="promptcache.backup"`)
+		cGin.Header("Trailer", "X-Backup-Since")
+		cGin.Status(http.StatusOK)
+
+		next, err := backuper.Backup(cGin.Writer, since)
+		if err != nil {
+			log.Printf("Backup failed: %v", err)
+			return
+		}
+		cGin.Writer.Header().Set("X-Backup-Since", strconv.FormatUint(next, 10))
+		log.Printf("Backup since version %d written; next since %d", since, next)
+	})
+
+	admin.POST("/restore", func(cGin *gin.Context) {
+		backuper, ok := storage.Base(store).(storage.Backuper)
+		if !ok {
+			cGin.JSON(http.StatusNotImplemented, gin.H{"error": storageConfig.Backend + " storage does not support backups"})
+			return
+		}
+
+		// The backup is read twice: once for its schema, then to load it
+		spool, err := os.CreateTemp("", "promptcache-restore-*")
+		if err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to buffer backup: " + err.Error()})
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		if _, err := io.Copy(spool, cGin.Request.Body); err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read backup: " + err.Error()})
+			return
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to buffer backup: " + err.Error()})
+			return
+		}
+
+		// Migration continues in the background, after the request is done
+		marker, err := migrator.Restore(jobsCtx, backuper, spool)
+		if errors.Is(err, cache.ErrNewerSchema) {
+			cGin.JSON(http.StatusConflict, gin.H{"error": "Incompatible backup: " + err.Error()})
+			return
+		}
+		if err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "Failed to restore backup: " + err.Error()})
+			return
+		}
+
+		// Rebuild in-memory state from the restored entries
+		if tiered != nil {
+			tiered.Purge()
+		}
+		if evictor != nil {
+			if err := evictor.Load(cGin.Request.Context()); err != nil {
+				cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Backup restored but the eviction index failed to reload: " + err.Error()})
+				return
+			}
+		}
+		if _, err := thresholdStore.Load(cGin.Request.Context()); err != nil {
+			cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Backup restored but the cache thresholds failed to reload: " + err.Error()})
+			return
+		}
+
+		log.Printf("Backup restored (schema %d)", marker.Version)
+		cGin.JSON(http.StatusOK, gin.H{"message": "Backup restored successfully", "schema": migrator.Status()})
+	})
+
+	admin.GET("/export", func(cGin *gin.Context) {
+		maxAge, minAge, err := ageFilter(cGin)
+		if err != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter := cache.ExportFilter{
+			Namespace: cGin.Query("namespace"),
+			Model:     cGin.Query("model"),
+			MaxAge:    maxAge,
+			MinAge:    minAge,
+		}
+
+		// As with backups, the count trailer is missing if the export was cut short
+		cGin.Header("Content-Type", "application/x-ndjson")
+		cGin.Header("Content-Disposition", `attachment; filename="promptcache.jsonl"`)
+		cGin.Header("Trailer", "X-Export-Count")
+		cGin.Status(http.StatusOK)
+
+		count, err := c.Export(cGin.Request.Context(), cGin.Writer, filter)
+		if err != nil {
+			log.Printf("Export failed: %v", err)
+			return
+		}
+		cGin.Writer.Header().Set("X-Export-Count", strconv.Itoa(count))
+		log.Printf("Exported %d entries", count)
+	})
+
+	admin.POST("/import", func(cGin *gin.Context) {
+		stats, importErr := c.Import(cGin.Request.Context(), cGin.Request.Body, semanticEngine)
+
+		// Entries imported before a failure are kept, so reload either way
+		if evictor != nil && stats.Imported > 0 {
+			if err := evictor.Load(cGin.Request.Context()); err != nil {
+				cGin.JSON(http.StatusInternalServerError, gin.H{"error": "Entries imported but the eviction index failed to reload: " + err.Error()})
+				return
+			}
+		}
+		if importErr != nil {
+			cGin.JSON(http.StatusBadRequest, gin.H{"error": "Failed to import entries: " + importErr.Error(), "imported": stats.Imported})
+			return
+		}
+
+		log.Printf("Imported %d entries (%d embedded again, %d skipped)", stats.Imported, stats.Reembedded, stats.Skipped)
+		cGin.JSON(http.StatusOK, stats)
+	})
+
+	// Shut down gracefully on SIGINT/SIGTERM so storage is closed cleanly,
+	// which also writes the memory backend's snapshot
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		log.Println("🚀 PromptCache Server running on :8080")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
+	}
+
+	// Requests are done, so no job or evaluation starts anymore; wait for
+	// the running ones before storage is closed
+	stopJobs()
+	jobs.Wait()
+	migrator.Wait()
+	if evaluator != nil {
+		evaluator.Wait()
+	}
+}
+
+// adminAuth rejects requests without the admin token. Without a token the
+// admin API is disabled, since it exposes every cached response.
+func adminAuth(token string) gin.HandlerFunc {
+	return func(cGin *gin.Context) {
+		if token == "" {
+			cGin.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin API is disabled; set ADMIN_TOKEN to enable it"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(cGin.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			cGin.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		cGin.Next()
+	}
+}
+
+// stripField removes a top-level field from a JSON object
+func stripField(body []byte, field string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, field)
+	return json.Marshal(fields)
+}
+
+// ageFilter parses the max_age and min_age query parameters shared by the
+// entry management API and exports
+func ageFilter(cGin *gin.Context) (maxAge, minAge time.Duration, err error) {
+	for param, age := range map[string]*time.Duration{"max_age": &maxAge, "min_age": &minAge} {
+		if val := cGin.Query(param); val != "" {
+			d, err := time.ParseDuration(val)
+			if err != nil || d < 0 {
+				return 0, 0, errors.New(param + " must be a duration such as 24h")
+			}
+			*age = d
+		}
+	}
+	return maxAge, minAge, nil
+}
+
+// entryFilter parses the filter query parameters of the entry management API
+func entryFilter(cGin *gin.Context) (cache.EntryFilter, error) {
+	filter := cache.EntryFilter{
+		Model:     cGin.Query("model"),
+		Namespace: cGin.Query("namespace"),
+	}
+	var err error
+	if filter.MaxAge, filter.MinAge, err = ageFilter(cGin); err != nil {
+		return filter, err
+	}
+	if val := cGin.Query("min_hits"); val != "" {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n < 0 {
+			return filter, errors.New("min_hits must be a non-negative number")
+		}
+		filter.MinHits = n
+	}
+	if val := cGin.Query("max_hits"); val != "" {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n < 0 {
+			return filter, errors.New("max_hits must be a non-negative number")
+		}
+		filter.MaxHits = &n
+	}
+	return filter, nil
+}
+
+// shadowEvaluate compares the candidate selected by the lookup with the fresh
+// upstream response in the background, so shadow mode adds no latency. The
+// comparison is dropped when too many are running already, and cancelled
+// once jobsCtx is done.
+func shadowEvaluate(jobsCtx context.Context, evaluator *shadow.Evaluator, c *cache.Cache, decision *semantic.Decision, fresh []byte) {
+	if decision == nil || decision.BestKey == "" {
+		evaluator.RecordNoCandidate()
+		return
+	}
+
+	evaluator.Go(func() {
+		ctx, cancel := context.WithTimeout(jobsCtx, 30*time.Second)
+		defer cancel()
+
+		cached, found, err := c.Get(ctx, semantic.HashFromKey(decision.BestKey))
+		if err != nil || !found {
+			evaluator.RecordNoCandidate()
+			return
+		}
+
+		obs, err := evaluator.Compare(ctx, decision, cached, fresh)
+		if jobsCtx.Err() != nil {
+			// Interrupted by shutdown
+			return
+		}
+		if err != nil {
+			evaluator.RecordBudgetExhausted()
+			return
+		}
+		evaluator.Record(obs)
+		log.Printf("👻 Shadow: key=%s zone=%s score=%.4f would_hit=%v equivalent=%v exact=%v",
+			decision.BestKey, obs.Zone, obs.Score, obs.WouldHit, obs.Equivalent, obs.ExactMatch)
+	})
+}
+
+// recordSavings adds a request to the savings statistics; failures are only
+// logged so accounting never fails a request
+func recordSavings(ctx context.Context, tracker *savings.Tracker, event savings.Event) {
+	if err := tracker.Record(ctx, event); err != nil {
+		log.Printf("Failed to record savings: %v", err)
+	}
+}
+
+// lastUserPrompt returns the content of the last user message
+func lastUserPrompt(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+type explainCandidate struct {
+	semantic.Candidate
+	Outcome   string     `json:"outcome"`
+	Cached    bool       `json:"cached"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+}
+
+type explainDecision struct {
+	Hit    bool   `json:"hit"`
+	Key    string `json:"key,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// explainResponse adds the cached response status of each candidate and the
+// final serving decision to an explanation, mirroring the chat handler
+func explainResponse(ctx context.Context, c *cache.Cache, explanation *semantic.Explanation) gin.H {
+	candidates := make([]explainCandidate, 0, len(explanation.Candidates))
+	for i, candidate := range explanation.Candidates {
+		ec := explainCandidate{Candidate: candidate, Outcome: "not_best_candidate"}
+		if i == 0 {
+			ec.Outcome = explanation.Decision.Reason
+		}
+
+		item, found, err := c.Inspect(ctx, semantic.HashFromKey(candidate.Key))
+		if err == nil && found {
+			ec.Cached = true
+			ec.CreatedAt = &item.CreatedAt
+			if expiresAt := item.ExpiresAt(); !expiresAt.IsZero() {
+				ec.ExpiresAt = &expiresAt
+			}
+			ec.Expired = item.Expired()
+		}
+		candidates = append(candidates, ec)
+	}
+
+	final := explainDecision{Reason: explanation.Decision.Reason}
+	if explanation.Decision.Hit() {
+		// A semantic hit is only served if its response is still cached
+		switch {
+		case len(candidates) == 0 || !candidates[0].Cached:
+			final.Reason = "response_missing"
+		case candidates[0].Expired:
+			final.Reason = "response_expired"
+		default:
+			final.Hit = true
+			final.Key = explanation.Decision.Key
+		}
+	}
+
+	return gin.H{
+		"candidates": candidates,
+		"lookup":     explanation.Decision,
+		"decision":   final,
+	}
+}
+
+// cacheTTL returns how long cached responses, and the prompts and embeddings
+// stored with them, are kept
+func cacheTTL() time.Duration {
+	if val := os.Getenv("CACHE_TTL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return 24 * time.Hour
+}
+
+// sweepInterval returns how often expired and orphaned entries are deleted
+func sweepInterval() time.Duration {
+	if val := os.Getenv("CACHE_SWEEP_INTERVAL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 10 * time.Minute
+}
+
+// backfillInterval returns how often fallback-embedded entries are re-embedded
+// with the primary provider
+func backfillInterval() time.Duration {
+	if val := os.Getenv("EMBEDDING_BACKFILL_INTERVAL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			return d
+		}
+	}
+	return time.Minute
+}
+
+// runBackfill periodically embeds entries stored by a fallback provider with
+// the primary provider, so they stay searchable once it recovers. It stops
+// when ctx is done.
+func runBackfill(ctx context.Context, engine *semantic.SemanticEngine, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		passCtx, cancel := context.WithTimeout(ctx, interval)
+		n, err := engine.Backfill(passCtx, 100)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Embedding backfill error: %v", err)
+		}
+		if n > 0 {
+			log.Printf("Backfilled %d embeddings for primary provider %s", n, engine.GetCurrentProvider())
+		}
+	}
 }
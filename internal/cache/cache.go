@@ -5,13 +5,24 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/messkan/PromptCache/internal/semantic"
 	"github.com/messkan/PromptCache/internal/storage"
 )
 
 type Cache struct {
-	store storage.Storage
+	store      storage.Storage
+	codec      *Codec
+	namespaces []string // Embedding namespaces probed for the vectors of an entry
+
+	mu         sync.RWMutex
+	listeners  []func(key string) // Called after an entry is deleted
+	migrations chan string        // Keys of entries read in an older schema
 }
 
 type CacheItem struct {
@@ -20,8 +31,23 @@ type CacheItem struct {
 	TTL       time.Duration `json:"ttl"`
 }
 
+// NewCache creates a cache that compresses responses with zstd; use
+// SetCodec to configure the encoding
 func NewCache(store storage.Storage) *Cache {
-	return &Cache{store: store}
+	return &Cache{store: store, codec: defaultCodec()}
+}
+
+// SetCodec replaces the codec new items are encoded with. Items written
+// with any earlier codec remain readable as long as codec has their
+// dictionary.
+func (c *Cache) SetCodec(codec *Codec) {
+	c.codec = codec
+}
+
+// SetNamespaces sets the embedding namespaces, primary first, in which
+// deletes look for vectors the metadata of an entry does not list
+func (c *Cache) SetNamespaces(namespaces []string) {
+	c.namespaces = namespaces
 }
 
 func GenerateKey(input string) string {
@@ -29,22 +55,198 @@ func GenerateKey(input string) string {
 	return hex.EncodeToString(h[:])
 }
 
+// ParamsFingerprint hashes the parameters of a chat completion request other
+// than its messages, e.g. model and temperature, so entries record which
+// settings produced them. Key order and whitespace do not matter.
+func ParamsFingerprint(body []byte) string {
+	var params map[string]any
+	if err := json.Unmarshal(body, &params); err != nil {
+		return ""
+	}
+	delete(params, "messages")
+
+	// Maps marshal with sorted keys
+	data, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	return GenerateKey(string(data))
+}
+
 func (c *Cache) Set(ctx context.Context, key string, response []byte, ttl time.Duration) error {
-	item := CacheItem{
+	data, err := c.codec.Encode(&CacheItem{
 		Response:  response,
 		CreatedAt: time.Now(),
 		TTL:       ttl,
+	})
+	if err != nil {
+		return err
 	}
 
-	data, err := json.Marshal(item)
+	// Let the backend drop the item once it expires
+	return c.store.SetWithTTL(ctx, key, data, ttl)
+}
+
+// SetEntry atomically stores a response together with its prompt, embeddings
+// and metadata. entry.Value is the response; it is stored as an encoded CacheItem.
+// CreatedAt defaults to now, and the records expire after entry.Metadata.TTL.
+func (c *Cache) SetEntry(ctx context.Context, entry *storage.Entry) error {
+	stored := *entry
+	if stored.Metadata.CreatedAt.IsZero() {
+		stored.Metadata.CreatedAt = time.Now()
+	}
+
+	data, err := c.codec.Encode(&CacheItem{
+		Response:  entry.Value,
+		CreatedAt: stored.Metadata.CreatedAt,
+		TTL:       stored.Metadata.TTL,
+	})
 	if err != nil {
 		return err
 	}
+	stored.Value = data
+
+	return c.store.PutEntry(ctx, &stored)
+}
+
+// GetEntry returns every record of the entry stored under key, with Value
+// set to the response. Expired entries are returned as well.
+func (c *Cache) GetEntry(ctx context.Context, key string) (*storage.Entry, error) {
+	entry, err := c.store.GetEntry(ctx, key)
+	if err != nil {
+		return nil, err
+	}
 
-	return c.store.Set(ctx, key, data)
+	item, err := c.codec.Decode(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !currentItem(entry.Value) || entry.Metadata.CreatedAt.IsZero() {
+		c.queueMigration(key)
+	}
+	entry.Value = item.Response
+
+	// Entries written before metadata was stored only know their item
+	if entry.Metadata.CreatedAt.IsZero() {
+		entry.Metadata.CreatedAt = item.CreatedAt
+		entry.Metadata.TTL = item.TTL
+	}
+	return entry, nil
 }
 
 func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	item, found, err := c.Inspect(ctx, key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	if item.Expired() {
+		return nil, false, nil
+	}
+
+	return item.Response, true, nil
+}
+
+// DeleteEntry atomically removes the response, prompt, metadata, tag index
+// records and the given embedding keys of the entry stored under key, then
+// notifies the OnDelete listeners
+func (c *Cache) DeleteEntry(ctx context.Context, key string, embeddingKeys ...string) error {
+	keys, err := c.recordKeys(ctx, key, embeddingKeys)
+	if err != nil {
+		return err
+	}
+	if err := c.store.DeleteBatch(ctx, keys); err != nil {
+		return err
+	}
+	c.notifyDelete(key)
+	return nil
+}
+
+// recordKeys returns the keys of every record of the entry under key,
+// followed by embeddingKeys. Tags and vectors are read from the metadata;
+// vectors are also looked for in every namespace, so entries written
+// without metadata lose them too.
+func (c *Cache) recordKeys(ctx context.Context, key string, embeddingKeys []string) ([]string, error) {
+	keys := []string{key, storage.PromptPrefix + key, storage.MetaPrefix + key}
+	data, err := c.store.Get(ctx, storage.MetaPrefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		var meta storage.EntryMetadata
+		// Unreadable metadata must not keep the entry from being deleted
+		if json.Unmarshal(data, &meta) == nil {
+			for _, tag := range meta.Tags {
+				keys = append(keys, storage.TagKey(tag, key))
+			}
+			keys = append(keys, meta.EmbeddingKeys...)
+		}
+	}
+	// Deleting a key that does not exist is free, so nothing is read
+	keys = append(keys, semantic.EmbeddingKey("", key))
+	for _, namespace := range c.namespaces {
+		keys = append(keys, semantic.EmbeddingKey(namespace, key))
+	}
+	keys = append(keys, embeddingKeys...)
+	return slices.Compact(slices.Sorted(slices.Values(keys))), nil
+}
+
+func (c *Cache) notifyDelete(key string) {
+	c.mu.RLock()
+	listeners := c.listeners
+	c.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(key)
+	}
+}
+
+// walkBatch is the number of keys read per storage scan while walking entries
+const walkBatch = 500
+
+// errStopWalk stops walk early without an error
+var errStopWalk = errors.New("stop walk")
+
+// walk calls fn with the key of every stored entry; prompts, metadata and
+// vectors are read with their entry
+func (c *Cache) walk(ctx context.Context, fn func(key string) error) error {
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		items, next, err := c.store.Scan(ctx, "", cursor, walkBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if strings.Contains(item.Key, ":") {
+				continue
+			}
+			if err := fn(item.Key); err == errStopWalk {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// OnDelete registers fn to be called with the key of every deleted entry, so
+// in-memory indexes can drop it too
+func (c *Cache) OnDelete(fn func(key string)) {
+	c.mu.Lock()
+	c.listeners = append(c.listeners, fn)
+	c.mu.Unlock()
+}
+
+// Inspect returns the stored item for key without treating expiry as a miss
+func (c *Cache) Inspect(ctx context.Context, key string) (*CacheItem, bool, error) {
 	data, err := c.store.Get(ctx, key)
 	if err != nil {
 		return nil, false, err
@@ -53,14 +255,42 @@ func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
 		return nil, false, nil
 	}
 
-	var item CacheItem
-	if err := json.Unmarshal(data, &item); err != nil {
+	item, err := c.codec.Decode(data)
+	if err != nil {
 		return nil, false, err
 	}
+	if !currentItem(data) {
+		c.queueMigration(key)
+	}
 
-	if item.TTL != 0 && time.Since(item.CreatedAt) > item.TTL {
-		return nil, false, nil
+	return item, true, nil
+}
+
+// queueMigration has the migrator, if any, rewrite the entry under key in the
+// current schema. Reads never wait for it; a full queue is left to the next
+// migration pass.
+func (c *Cache) queueMigration(key string) {
+	c.mu.RLock()
+	queue := c.migrations
+	c.mu.RUnlock()
+	if queue == nil {
+		return
 	}
+	select {
+	case queue <- key:
+	default:
+	}
+}
 
-	return item.Response, true, nil
+// ExpiresAt returns when the item expires, or the zero time if it never does
+func (i *CacheItem) ExpiresAt() time.Time {
+	if i.TTL == 0 {
+		return time.Time{}
+	}
+	return i.CreatedAt.Add(i.TTL)
+}
+
+// Expired reports whether the item's TTL has elapsed
+func (i *CacheItem) Expired() bool {
+	return i.TTL != 0 && time.Since(i.CreatedAt) > i.TTL
 }
@@ -2,18 +2,26 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
 )
 
 // MockStorage implements storage.Storage for testing
 type MockStorage struct {
 	data map[string][]byte
+	ttls map[string]time.Duration
 }
 
 func NewMockStorage() *MockStorage {
 	return &MockStorage{
 		data: make(map[string][]byte),
+		ttls: make(map[string]time.Duration),
 	}
 }
 
@@ -22,6 +30,12 @@ func (m *MockStorage) Set(ctx context.Context, key string, value []byte) error {
 	return nil
 }
 
+func (m *MockStorage) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.data[key] = value
+	m.ttls[key] = ttl
+	return nil
+}
+
 func (m *MockStorage) Get(ctx context.Context, key string) ([]byte, error) {
 	val, ok := m.data[key]
 	if !ok {
@@ -35,14 +49,124 @@ func (m *MockStorage) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+func (m *MockStorage) DeleteBatch(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		delete(m.data, key)
+	}
+	return nil
+}
+
 func (m *MockStorage) GetAllEmbeddings(ctx context.Context) (map[string][]byte, error) {
-	return nil, nil
+	res := make(map[string][]byte)
+	for k, v := range m.data {
+		if strings.HasPrefix(k, "emb:") {
+			res[k] = v
+		}
+	}
+	return res, nil
 }
 
 func (m *MockStorage) GetPrompt(ctx context.Context, key string) (string, error) {
 	return "", nil
 }
 
+func (m *MockStorage) PutEntry(ctx context.Context, entry *storage.Entry) error {
+	var previous storage.EntryMetadata
+	if data, ok := m.data[storage.MetaPrefix+entry.Key]; ok {
+		json.Unmarshal(data, &previous)
+	}
+	for _, tag := range previous.Tags {
+		if !slices.Contains(entry.Metadata.Tags, tag) {
+			m.Delete(ctx, storage.TagKey(tag, entry.Key))
+		}
+	}
+
+	meta := entry.Metadata
+	meta.EmbeddingKeys = nil
+	for key, vec := range entry.Embeddings {
+		meta.EmbeddingKeys = append(meta.EmbeddingKeys, key)
+		m.SetWithTTL(ctx, key, vec, meta.TTL)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	m.SetWithTTL(ctx, entry.Key, entry.Value, meta.TTL)
+	m.SetWithTTL(ctx, storage.PromptPrefix+entry.Key, entry.Prompt, meta.TTL)
+	m.SetWithTTL(ctx, storage.MetaPrefix+entry.Key, data, meta.TTL)
+	for _, tag := range meta.Tags {
+		m.SetWithTTL(ctx, storage.TagKey(tag, entry.Key), []byte(entry.Key), meta.TTL)
+	}
+	return nil
+}
+
+func (m *MockStorage) GetEntry(ctx context.Context, key string) (*storage.Entry, error) {
+	value, ok := m.data[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	entry := &storage.Entry{Key: key, Value: value, Prompt: m.data[storage.PromptPrefix+key], Embeddings: map[string][]byte{}}
+	if data, ok := m.data[storage.MetaPrefix+key]; ok {
+		if err := json.Unmarshal(data, &entry.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	for _, embKey := range entry.Metadata.EmbeddingKeys {
+		if vec, ok := m.data[embKey]; ok {
+			entry.Embeddings[embKey] = vec
+		}
+	}
+	return entry, nil
+}
+
+func (m *MockStorage) Update(ctx context.Context, key string, fn storage.UpdateFunc) error {
+	value, err := fn(m.data[key])
+	if err != nil {
+		return err
+	}
+	m.data[key] = value
+	return nil
+}
+
+func (m *MockStorage) RecordHit(ctx context.Context, key string) error {
+	data, ok := m.data[storage.MetaPrefix+key]
+	if !ok {
+		return nil
+	}
+	var meta storage.EntryMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	meta.Hits++
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	m.data[storage.MetaPrefix+key] = data
+	return nil
+}
+
+func (m *MockStorage) Scan(ctx context.Context, prefix, cursor string, limit int) ([]storage.KV, string, error) {
+	var keys []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) && k > cursor {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	items := make([]storage.KV, len(keys))
+	for i, k := range keys {
+		items[i] = storage.KV{Key: k, Value: m.data[k]}
+	}
+	return items, next, nil
+}
+
 func (m *MockStorage) Close() {}
 
 func TestCache_SetAndGet(t *testing.T) {
@@ -71,6 +195,10 @@ func TestCache_SetAndGet(t *testing.T) {
 	if string(got) != string(response) {
 		t.Errorf("Get = %s, want %s", got, response)
 	}
+
+	if store.ttls[key] != ttl {
+		t.Errorf("Expected TTL %s passed to the backend, got %s", ttl, store.ttls[key])
+	}
 }
 
 func TestCache_Expiration(t *testing.T) {
@@ -110,3 +238,94 @@ func TestCache_Miss(t *testing.T) {
 		t.Fatal("Expected cache miss, got hit")
 	}
 }
+
+func TestCache_Inspect(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "expired-key", []byte("response"), -1*time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// Inspect still returns expired items so callers can report on them
+	item, found, err := c.Inspect(ctx, "expired-key")
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if !found {
+		t.Fatal("Expected Inspect to find expired item")
+	}
+	if !item.Expired() {
+		t.Error("Expected item to be expired")
+	}
+	if item.ExpiresAt().After(time.Now()) {
+		t.Errorf("Expected ExpiresAt in the past, got %v", item.ExpiresAt())
+	}
+}
+
+func TestCache_SetEntry(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+
+	entry := &storage.Entry{
+		Key:        "hash",
+		Value:      []byte("response"),
+		Prompt:     []byte("prompt"),
+		Embeddings: map[string][]byte{"emb:openai:hash": {1, 2}},
+		Metadata:   storage.EntryMetadata{Model: "gpt-4o", EmbeddingProvider: "openai", TTL: time.Hour},
+	}
+	if err := c.SetEntry(ctx, entry); err != nil {
+		t.Fatalf("SetEntry failed: %v", err)
+	}
+
+	// The response record stays readable through Get
+	got, found, err := c.Get(ctx, "hash")
+	if err != nil || !found || string(got) != "response" {
+		t.Fatalf("Get = (%s, %v, %v), want response", got, found, err)
+	}
+	if store.ttls["emb:openai:hash"] != time.Hour {
+		t.Errorf("Expected the embedding to share the entry TTL, got %s", store.ttls["emb:openai:hash"])
+	}
+
+	if err := store.RecordHit(ctx, "hash"); err != nil {
+		t.Fatalf("RecordHit failed: %v", err)
+	}
+	read, err := c.GetEntry(ctx, "hash")
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if string(read.Value) != "response" || string(read.Prompt) != "prompt" || len(read.Embeddings) != 1 {
+		t.Errorf("Unexpected entry: %+v", read)
+	}
+	if read.Metadata.Model != "gpt-4o" || read.Metadata.Hits != 1 || read.Metadata.CreatedAt.IsZero() {
+		t.Errorf("Unexpected metadata: %+v", read.Metadata)
+	}
+
+	// Entries written by Set fall back to the item's creation time and TTL
+	c.Set(ctx, "legacy", []byte("response"), time.Minute)
+	legacy, err := c.GetEntry(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if legacy.Metadata.TTL != time.Minute || legacy.Metadata.CreatedAt.IsZero() {
+		t.Errorf("Unexpected legacy metadata: %+v", legacy.Metadata)
+	}
+}
+
+func TestParamsFingerprint(t *testing.T) {
+	a := ParamsFingerprint([]byte(`{"model": "gpt-4o", "temperature": 0.2, "messages": [{"role": "user", "content": "a"}]}`))
+	b := ParamsFingerprint([]byte(`{"messages":[{"role":"user","content":"b"}],"temperature":0.2,"model":"gpt-4o"}`))
+	c := ParamsFingerprint([]byte(`{"model": "gpt-4o", "temperature": 0.7}`))
+
+	if a == "" || a != b {
+		t.Errorf("Expected the messages, key order and whitespace to be ignored, got %q and %q", a, b)
+	}
+	if a == c {
+		t.Error("Expected different parameters to differ")
+	}
+	if ParamsFingerprint([]byte("not json")) != "" {
+		t.Error("Expected an empty fingerprint for invalid JSON")
+	}
+}
@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how response bodies are compressed
+type Compression string
+
+const (
+	CompressNone   Compression = "none"
+	CompressSnappy Compression = "snappy"
+	CompressZstd   Compression = "zstd"
+)
+
+// Items are stored in a binary envelope:
+//
+//	version(1) | codec(1) | created_at unix nanos(8) | ttl nanos(8) | body
+//
+// Items written before the envelope are JSON objects and start with '{'.
+const (
+	envelopeV1     = 0x01
+	envelopeHeader = 18
+)
+
+// Codec bytes of the envelope; a body is stored uncompressed when
+// compression would not make it smaller
+const (
+	codecNone byte = iota
+	codecSnappy
+	codecZstd
+)
+
+// DefaultDictSize is the size of trained dictionaries, zstd's own default
+const DefaultDictSize = 110 << 10
+
+// CodecConfig configures how cache items are encoded
+type CodecConfig struct {
+	Compression Compression
+	DictPaths   []string // zstd dictionaries; the first is used for new items
+}
+
+// LoadCodecConfig loads the codec configuration from environment variables
+func LoadCodecConfig() (CodecConfig, error) {
+	config := CodecConfig{Compression: CompressZstd}
+
+	if val := os.Getenv("CACHE_COMPRESSION"); val != "" {
+		switch c := Compression(strings.ToLower(val)); c {
+		case CompressNone, CompressSnappy, CompressZstd:
+			config.Compression = c
+		default:
+			return config, fmt.Errorf("unknown cache compression %q (supported: none, snappy, zstd)", val)
+		}
+	}
+
+	if val := os.Getenv("CACHE_COMPRESSION_DICT"); val != "" {
+		for _, path := range strings.Split(val, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				config.DictPaths = append(config.DictPaths, path)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// defaultCodec is used by caches without a configured codec
+var defaultCodec = sync.OnceValue(func() *Codec {
+	codec, err := NewCodec(CodecConfig{Compression: CompressZstd})
+	if err != nil {
+		panic(err)
+	}
+	return codec
+})
+
+// Codec encodes cache items into the binary envelope and decodes both the
+// envelope and legacy JSON items. It is safe for concurrent use.
+type Codec struct {
+	compression Compression
+	encoder     *zstd.Encoder
+	decoder     *zstd.Decoder
+}
+
+// NewCodec creates a codec, loading the configured dictionaries. Items
+// compressed with any of them can be decoded.
+func NewCodec(config CodecConfig) (*Codec, error) {
+	var dicts [][]byte
+	for _, path := range config.DictPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read compression dictionary: %w", err)
+		}
+		dicts = append(dicts, data)
+	}
+
+	var encoderOpts []zstd.EOption
+	if len(dicts) > 0 {
+		if config.Compression != CompressZstd {
+			return nil, fmt.Errorf("compression dictionaries require zstd compression")
+		}
+		encoderOpts = append(encoderOpts, zstd.WithEncoderDict(dicts[0]))
+	}
+	encoder, err := zstd.NewWriter(nil, encoderOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compression dictionary: %w", err)
+	}
+	// Every item can be decoded whatever the current compression is
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderDicts(dicts...))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compression dictionary: %w", err)
+	}
+
+	return &Codec{compression: config.Compression, encoder: encoder, decoder: decoder}, nil
+}
+
+// Compression returns the compression applied to new items
+func (c *Codec) Compression() Compression {
+	return c.compression
+}
+
+// Encode encodes item into the binary envelope
+func (c *Codec) Encode(item *CacheItem) ([]byte, error) {
+	codec, body := codecNone, item.Response
+	switch c.compression {
+	case CompressSnappy:
+		codec, body = codecSnappy, s2.EncodeSnappy(nil, item.Response)
+	case CompressZstd:
+		codec, body = codecZstd, c.encoder.EncodeAll(item.Response, nil)
+	}
+	if len(body) >= len(item.Response) {
+		codec, body = codecNone, item.Response
+	}
+
+	data := make([]byte, envelopeHeader, envelopeHeader+len(body))
+	data[0] = envelopeV1
+	data[1] = codec
+	var createdAt int64
+	if !item.CreatedAt.IsZero() {
+		createdAt = item.CreatedAt.UnixNano()
+	}
+	binary.BigEndian.PutUint64(data[2:10], uint64(createdAt))
+	binary.BigEndian.PutUint64(data[10:18], uint64(item.TTL))
+	return append(data, body...), nil
+}
+
+// currentItem reports whether data is encoded in the current envelope
+func currentItem(data []byte) bool {
+	return len(data) > 0 && data[0] == envelopeV1
+}
+
+// Decode decodes an item in any encoding the cache has written
+func (c *Codec) Decode(data []byte) (*CacheItem, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty cache item")
+	}
+
+	switch data[0] {
+	case '{':
+		var item CacheItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		return &item, nil
+	case envelopeV1:
+		return c.decodeV1(data)
+	default:
+		return nil, fmt.Errorf("unknown cache item encoding %#x", data[0])
+	}
+}
+
+func (c *Codec) decodeV1(data []byte) (*CacheItem, error) {
+	if len(data) < envelopeHeader {
+		return nil, fmt.Errorf("cache item is truncated")
+	}
+
+	item := &CacheItem{TTL: time.Duration(binary.BigEndian.Uint64(data[10:18]))}
+	if createdAt := int64(binary.BigEndian.Uint64(data[2:10])); createdAt != 0 {
+		item.CreatedAt = time.Unix(0, createdAt)
+	}
+
+	body := data[envelopeHeader:]
+	var err error
+	switch data[1] {
+	case codecNone:
+		item.Response = append([]byte{}, body...)
+	case codecSnappy:
+		item.Response, err = s2.Decode(nil, body)
+	case codecZstd:
+		item.Response, err = c.decoder.DecodeAll(body, nil)
+	default:
+		return nil, fmt.Errorf("unknown cache item codec %d", data[1])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompress cache item: %w", err)
+	}
+	return item, nil
+}
+
+// Responses returns up to limit cached responses, e.g. as dictionary samples
+func (c *Cache) Responses(ctx context.Context, limit int) ([][]byte, error) {
+	var responses [][]byte
+	err := c.walk(ctx, func(key string) error {
+		if len(responses) >= limit {
+			return errStopWalk
+		}
+		item, found, err := c.Inspect(ctx, key)
+		if err != nil || !found {
+			return err
+		}
+		responses = append(responses, item.Response)
+		return nil
+	})
+	return responses, err
+}
+
+// TrainDictionary builds a zstd dictionary of about size bytes from sample
+// responses. Responses of one API share most of their structure, so a
+// dictionary improves the compression of small bodies considerably.
+func TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples to train a dictionary on")
+	}
+	if size <= 0 {
+		size = DefaultDictSize
+	}
+	return dict.BuildZstdDict(samples, dict.Options{MaxDictSize: size, HashBytes: 6})
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// chatResponse returns a chat completion body like the ones the cache stores
+func chatResponse(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":"chatcmpl-%d","object":"chat.completion","created":%d,"model":"gpt-4o-mini","choices":[{"index":0,"message":{"role":"assistant","content":"Answer number %d to the question."},"finish_reason":"stop"}],"usage":{"prompt_tokens":%d,"completion_tokens":12,"total_tokens":%d}}`, i, 1700000000+i, i, 20+i, 32+i))
+}
+
+func TestLoadCodecConfig(t *testing.T) {
+	t.Setenv("CACHE_COMPRESSION", "Snappy")
+	t.Setenv("CACHE_COMPRESSION_DICT", "new.dict, old.dict")
+
+	config, err := LoadCodecConfig()
+	if err != nil {
+		t.Fatalf("LoadCodecConfig failed: %v", err)
+	}
+	if config.Compression != CompressSnappy || len(config.DictPaths) != 2 || config.DictPaths[1] != "old.dict" {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+
+	t.Setenv("CACHE_COMPRESSION", "lz4")
+	if _, err := LoadCodecConfig(); err == nil {
+		t.Error("Expected an unknown compression to fail")
+	}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	item := &CacheItem{
+		Response:  bytes.Repeat(chatResponse(1), 4),
+		CreatedAt: time.Unix(1700000000, 123),
+		TTL:       time.Hour,
+	}
+	legacy, _ := json.Marshal(item)
+
+	for _, compression := range []Compression{CompressNone, CompressSnappy, CompressZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			codec, err := NewCodec(CodecConfig{Compression: compression})
+			if err != nil {
+				t.Fatalf("NewCodec failed: %v", err)
+			}
+
+			data, err := codec.Encode(item)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if len(data) >= len(legacy) {
+				t.Errorf("Expected the envelope to be smaller than JSON, got %d >= %d bytes", len(data), len(legacy))
+			}
+			if compression != CompressNone && len(data) >= len(item.Response) {
+				t.Errorf("Expected the body to be compressed, got %d bytes", len(data))
+			}
+
+			got, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !bytes.Equal(got.Response, item.Response) || !got.CreatedAt.Equal(item.CreatedAt) || got.TTL != item.TTL {
+				t.Errorf("Round trip changed the item: %+v", got)
+			}
+
+			// Items written before the envelope stay readable
+			got, err = codec.Decode(legacy)
+			if err != nil || !bytes.Equal(got.Response, item.Response) || got.TTL != time.Hour {
+				t.Errorf("Decode of a JSON item = (%+v, %v)", got, err)
+			}
+		})
+	}
+}
+
+func TestCodec_Incompressible(t *testing.T) {
+	codec, _ := NewCodec(CodecConfig{Compression: CompressZstd})
+	item := &CacheItem{Response: []byte("x")}
+
+	data, _ := codec.Encode(item)
+	if data[1] != codecNone || len(data) != envelopeHeader+1 {
+		t.Errorf("Expected a tiny body to be stored as it is, got %v", data)
+	}
+
+	// Any codec decodes any compression
+	snappy, _ := NewCodec(CodecConfig{Compression: CompressSnappy})
+	big := &CacheItem{Response: bytes.Repeat([]byte("abc"), 100)}
+	data, _ = codec.Encode(big)
+	if got, err := snappy.Decode(data); err != nil || !bytes.Equal(got.Response, big.Response) {
+		t.Errorf("Expected a snappy codec to decode zstd, got %v", err)
+	}
+
+	for _, bad := range [][]byte{nil, {0x7f, 0}, {envelopeV1, 0}, append(make([]byte, 1), bytes.Repeat([]byte{9}, envelopeHeader)...)} {
+		if _, err := codec.Decode(bad); err == nil {
+			t.Errorf("Expected %v to fail", bad)
+		}
+	}
+}
+
+func TestCodec_Dictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 500; i++ {
+		samples = append(samples, chatResponse(i))
+	}
+	dict, err := TrainDictionary(samples, 4<<10)
+	if err != nil {
+		t.Fatalf("TrainDictionary failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "responses.dict")
+	os.WriteFile(path, dict, 0o644)
+
+	plain, _ := NewCodec(CodecConfig{Compression: CompressZstd})
+	withDict, err := NewCodec(CodecConfig{Compression: CompressZstd, DictPaths: []string{path}})
+	if err != nil {
+		t.Fatalf("NewCodec failed: %v", err)
+	}
+
+	item := &CacheItem{Response: chatResponse(1000)}
+	small, _ := withDict.Encode(item)
+	large, _ := plain.Encode(item)
+	if len(small) >= len(large) {
+		t.Errorf("Expected the dictionary to help, got %d >= %d bytes", len(small), len(large))
+	}
+	if got, err := withDict.Decode(small); err != nil || !bytes.Equal(got.Response, item.Response) {
+		t.Errorf("Decode with the dictionary = (%v, %v)", got, err)
+	}
+	if got, err := withDict.Decode(large); err != nil || !bytes.Equal(got.Response, item.Response) {
+		t.Errorf("Expected items without a dictionary to stay readable, got %v", err)
+	}
+	if _, err := plain.Decode(small); err == nil {
+		t.Error("Expected decoding without the dictionary to fail")
+	}
+
+	if _, err := NewCodec(CodecConfig{Compression: CompressSnappy, DictPaths: []string{path}}); err == nil {
+		t.Error("Expected a dictionary without zstd to fail")
+	}
+}
+
+func TestCache_Responses(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		c.Set(ctx, fmt.Sprintf("key%d", i), chatResponse(i), 0)
+	}
+	store.Set(ctx, "prompt:key0", []byte("prompt"))
+
+	responses, err := c.Responses(ctx, 3)
+	if err != nil {
+		t.Fatalf("Responses failed: %v", err)
+	}
+	if len(responses) != 3 || !bytes.Equal(responses[0], chatResponse(0)) {
+		t.Errorf("Expected the first 3 responses, got %d", len(responses))
+	}
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// EntryFilter selects entries to list or purge. Zero fields match every entry.
+type EntryFilter struct {
+	Model     string        // Only entries for this upstream model
+	Namespace string        // Only entries with a vector in this namespace
+	MaxAge    time.Duration // Only entries created at most MaxAge ago
+	MinAge    time.Duration // Only entries created at least MinAge ago
+	MinHits   int64         // Only entries hit at least MinHits times
+	MaxHits   *int64        // Only entries hit at most MaxHits times
+}
+
+// IsZero reports whether filter matches every entry
+func (f EntryFilter) IsZero() bool {
+	return f == EntryFilter{}
+}
+
+func (f EntryFilter) match(entry *storage.Entry, now time.Time) bool {
+	meta := entry.Metadata
+	age := now.Sub(meta.CreatedAt)
+	switch {
+	case f.Model != "" && meta.Model != f.Model:
+		return false
+	case f.MaxAge > 0 && age > f.MaxAge, f.MinAge > 0 && age < f.MinAge:
+		return false
+	case meta.Hits < f.MinHits, f.MaxHits != nil && meta.Hits > *f.MaxHits:
+		return false
+	}
+	if f.Namespace == "" {
+		return true
+	}
+	for key := range entry.Embeddings {
+		if namespace, _ := semantic.ParseEmbeddingKey(key); namespace == f.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// EntryInfo describes a cache entry in the management API
+type EntryInfo struct {
+	Key        string                `json:"key"`
+	Prompt     string                `json:"prompt"`
+	Response   json.RawMessage       `json:"response,omitempty"` // Only set for a single entry; a JSON string if the body is not JSON
+	Size       int                   `json:"size"`               // Bytes of the response
+	Namespaces []string              `json:"namespaces"`         // Namespaces the entry has a vector in
+	Metadata   storage.EntryMetadata `json:"metadata"`
+	ExpiresAt  *time.Time            `json:"expires_at,omitempty"`
+}
+
+func entryInfo(entry *storage.Entry) *EntryInfo {
+	info := &EntryInfo{
+		Key:        entry.Key,
+		Prompt:     string(entry.Prompt),
+		Size:       len(entry.Value),
+		Namespaces: []string{},
+		Metadata:   entry.Metadata,
+	}
+	for key := range entry.Embeddings {
+		namespace, _ := semantic.ParseEmbeddingKey(key)
+		info.Namespaces = append(info.Namespaces, namespace)
+	}
+	sort.Strings(info.Namespaces)
+	if meta := entry.Metadata; meta.TTL > 0 {
+		expiresAt := meta.CreatedAt.Add(meta.TTL)
+		info.ExpiresAt = &expiresAt
+	}
+	return info
+}
+
+// expired reports whether entry has outlived its TTL
+func expired(entry *storage.Entry, now time.Time) bool {
+	meta := entry.Metadata
+	return meta.TTL > 0 && !now.Before(meta.CreatedAt.Add(meta.TTL))
+}
+
+// EntryPage is one page of a listing
+type EntryPage struct {
+	Entries []*EntryInfo `json:"entries"`
+	Cursor  string       `json:"cursor,omitempty"` // Continues the listing; empty on the last page
+}
+
+// ListEntries returns up to limit live entries matching filter, starting at
+// cursor; pass "" for the first page. Pages may hold fewer entries than
+// limit before the last one, and a few more on Redis, whose scans only take
+// the limit as a hint.
+func (c *Cache) ListEntries(ctx context.Context, filter EntryFilter, cursor string, limit int) (*EntryPage, error) {
+	if limit <= 0 {
+		limit = storage.DefaultScanLimit
+	}
+	page := &EntryPage{Entries: []*EntryInfo{}}
+	now := time.Now()
+
+	for {
+		// Each scanned key is at most one entry, so the page cannot overflow
+		items, next, err := c.store.Scan(ctx, "", cursor, limit-len(page.Entries))
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if strings.Contains(item.Key, ":") {
+				continue
+			}
+			entry, err := c.GetEntry(ctx, item.Key)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read entry %s: %w", item.Key, err)
+			}
+			if !expired(entry, now) && filter.match(entry, now) {
+				page.Entries = append(page.Entries, entryInfo(entry))
+			}
+		}
+
+		cursor = next
+		if cursor == "" || len(page.Entries) >= limit {
+			page.Cursor = cursor
+			return page, nil
+		}
+	}
+}
+
+// Entry returns the entry stored under key with its response. Expired
+// entries are not returned.
+func (c *Cache) Entry(ctx context.Context, key string) (*EntryInfo, error) {
+	entry, err := c.GetEntry(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if expired(entry, time.Now()) {
+		return nil, storage.ErrNotFound
+	}
+
+	info := entryInfo(entry)
+	info.Response = entry.Value
+	if !json.Valid(entry.Value) {
+		info.Response, _ = json.Marshal(string(entry.Value))
+	}
+	return info, nil
+}
+
+// DeleteEntries removes the entries under keys with their prompts, metadata
+// and vectors in every namespace, and returns how many existed. Vectors are
+// also looked for by their key in each namespace, so entries written
+// without metadata lose them too.
+func (c *Cache) DeleteEntries(ctx context.Context, keys []string) (int, error) {
+	deleted := 0
+	for _, key := range keys {
+		value, err := c.store.Get(ctx, key)
+		if err != nil {
+			return deleted, err
+		}
+		// The other records may outlive an expired response
+		records, err := c.recordKeys(ctx, key, nil)
+		if err != nil {
+			return deleted, err
+		}
+		if err := c.store.DeleteBatch(ctx, records); err != nil {
+			return deleted, err
+		}
+		if value != nil {
+			c.notifyDelete(key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Purge deletes every entry matching filter, expired or not, and returns
+// how many it deleted. A zero filter empties the cache.
+func (c *Cache) Purge(ctx context.Context, filter EntryFilter) (int, error) {
+	now := time.Now()
+	var keys []string
+	err := c.walk(ctx, func(key string) error {
+		if !filter.IsZero() {
+			entry, err := c.GetEntry(ctx, key)
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read entry %s: %w", key, err)
+			}
+			if !filter.match(entry, now) {
+				return nil
+			}
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return c.DeleteEntries(ctx, keys)
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// seedEntries stores entries key0..key9: even keys for gpt-4o in the openai
+// namespace, odd keys for gpt-4o-mini in mistral. Entry i was created i hours
+// ago and hit i times.
+func seedEntries(t *testing.T, c *Cache, store *MockStorage) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		model, namespace := "gpt-4o", "openai"
+		if i%2 == 1 {
+			model, namespace = "gpt-4o-mini", "mistral"
+		}
+		err := c.SetEntry(ctx, &storage.Entry{
+			Key:        key,
+			Value:      chatResponse(i),
+			Prompt:     []byte("prompt " + key),
+			Embeddings: map[string][]byte{"emb:" + namespace + ":" + key: {1, 2, 3, 4}},
+			Metadata: storage.EntryMetadata{
+				Model:     model,
+				CreatedAt: time.Now().Add(-time.Duration(i) * time.Hour),
+				TTL:       24 * time.Hour,
+				Hits:      int64(i),
+			},
+		})
+		if err != nil {
+			t.Fatalf("SetEntry failed: %v", err)
+		}
+	}
+}
+
+func TestCache_ListEntries(t *testing.T) {
+	two := int64(2)
+	tests := []struct {
+		name   string
+		filter EntryFilter
+		want   int
+	}{
+		{name: "all", want: 10},
+		{name: "model", filter: EntryFilter{Model: "gpt-4o"}, want: 5},
+		{name: "namespace", filter: EntryFilter{Namespace: "mistral"}, want: 5},
+		{name: "max age", filter: EntryFilter{MaxAge: 150 * time.Minute}, want: 3},
+		{name: "min age", filter: EntryFilter{MinAge: 150 * time.Minute}, want: 7},
+		{name: "min hits", filter: EntryFilter{MinHits: 8}, want: 2},
+		{name: "max hits", filter: EntryFilter{MaxHits: &two}, want: 3},
+		{name: "combined", filter: EntryFilter{Model: "gpt-4o", MinHits: 4}, want: 3},
+	}
+
+	store := NewMockStorage()
+	c := NewCache(store)
+	seedEntries(t, c, store)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Pages of 2 entries must add up to every match
+			var keys []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				page, err := c.ListEntries(context.Background(), tt.filter, cursor, 2)
+				if err != nil {
+					t.Fatalf("ListEntries failed: %v", err)
+				}
+				if len(page.Entries) > 2 {
+					t.Fatalf("Expected at most 2 entries per page, got %d", len(page.Entries))
+				}
+				for _, entry := range page.Entries {
+					keys = append(keys, entry.Key)
+				}
+				if page.Cursor == "" {
+					break
+				}
+				if pages > 40 {
+					t.Fatal("Listing did not finish")
+				}
+				cursor = page.Cursor
+			}
+			if len(keys) != tt.want {
+				t.Errorf("Expected %d entries, got %d: %v", tt.want, len(keys), keys)
+			}
+		})
+	}
+
+	page, _ := c.ListEntries(context.Background(), EntryFilter{Model: "gpt-4o-mini"}, "", 1)
+	entry := page.Entries[0]
+	if entry.Key != "key1" || entry.Prompt != "prompt key1" || entry.Response != nil || entry.Namespaces[0] != "mistral" || entry.ExpiresAt == nil {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if entry.Size != len(chatResponse(1)) {
+		t.Errorf("Expected the size of the response, got %d", entry.Size)
+	}
+}
+
+func TestCache_Entry(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+	seedEntries(t, c, store)
+	c.Set(ctx, "text", []byte("not json"), 0)
+	c.SetEntry(ctx, &storage.Entry{Key: "expired", Value: []byte("{}"), Metadata: storage.EntryMetadata{CreatedAt: time.Now().Add(-time.Hour), TTL: time.Minute}})
+
+	entry, err := c.Entry(ctx, "key3")
+	if err != nil {
+		t.Fatalf("Entry failed: %v", err)
+	}
+	if string(entry.Response) != string(chatResponse(3)) || entry.Metadata.Hits != 3 {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+
+	if entry, _ := c.Entry(ctx, "text"); string(entry.Response) != `"not json"` {
+		t.Errorf("Expected a body that is not JSON as a string, got %s", entry.Response)
+	}
+	for _, key := range []string{"expired", "missing"} {
+		if _, err := c.Entry(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for %s, got %v", key, err)
+		}
+	}
+}
+
+func TestCache_DeleteEntries(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+	seedEntries(t, c, store)
+
+	var forgotten []string
+	c.OnDelete(func(key string) { forgotten = append(forgotten, key) })
+
+	// Vectors of entries without metadata are found in each namespace
+	c.SetNamespaces([]string{"openai", "mistral"})
+	store.Set(ctx, "legacy", []byte(`{"response":"e30="}`))
+	store.Set(ctx, "emb:legacy", []byte{1})
+	store.Set(ctx, "emb:mistral:legacy", []byte{1})
+
+	n, err := c.DeleteEntries(ctx, []string{"key0", "legacy", "missing"})
+	if err != nil {
+		t.Fatalf("DeleteEntries failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 entries deleted, got %d", n)
+	}
+	for _, key := range []string{"key0", "prompt:key0", "meta:key0", "emb:openai:key0", "legacy", "emb:legacy", "emb:mistral:legacy"} {
+		if _, ok := store.data[key]; ok {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+	if len(forgotten) != 2 {
+		t.Errorf("Expected the listeners to be told about 2 entries, got %v", forgotten)
+	}
+
+	// Purge deletes what matches, expired or not
+	n, err = c.Purge(ctx, EntryFilter{Model: "gpt-4o-mini", MinAge: 4 * time.Hour})
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 entries purged, got %d", n)
+	}
+	if _, ok := store.data["key3"]; !ok {
+		t.Error("Expected key3 to be kept")
+	}
+
+	n, _ = c.Purge(ctx, EntryFilter{})
+	if n != 6 || len(store.data) != 0 {
+		t.Errorf("Expected the rest to be purged, got %d with %d records left", n, len(store.data))
+	}
+}
@@ -0,0 +1,381 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy decides which entries are evicted first once a capacity
+// limit is reached
+type EvictionPolicy string
+
+const (
+	// EvictLRU evicts the least recently used entries
+	EvictLRU EvictionPolicy = "lru"
+
+	// EvictLFU evicts the least frequently used entries
+	EvictLFU EvictionPolicy = "lfu"
+
+	// EvictTTL evicts the entries closest to expiry
+	EvictTTL EvictionPolicy = "ttl"
+
+	// EvictCost evicts the entries that save the least upstream spend per
+	// byte, counting every hit
+	EvictCost EvictionPolicy = "cost"
+)
+
+// EvictionConfig bounds the size of the cache. Zero limits mean unlimited.
+type EvictionConfig struct {
+	MaxEntries int
+	MaxBytes   int64 // Total size of cached responses and prompts
+	Policy     EvictionPolicy
+}
+
+// LoadEvictionConfig loads the capacity limits from environment variables
+func LoadEvictionConfig() (*EvictionConfig, error) {
+	config := &EvictionConfig{Policy: EvictLRU}
+
+	if val := os.Getenv("CACHE_MAX_ENTRIES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			config.MaxEntries = n
+		}
+	}
+
+	if val := os.Getenv("CACHE_MAX_BYTES"); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && n >= 0 {
+			config.MaxBytes = n
+		}
+	}
+
+	if val := os.Getenv("CACHE_EVICTION_POLICY"); val != "" {
+		switch policy := EvictionPolicy(strings.ToLower(val)); policy {
+		case EvictLRU, EvictLFU, EvictTTL, EvictCost:
+			config.Policy = policy
+		default:
+			return nil, fmt.Errorf("invalid CACHE_EVICTION_POLICY: %s (supported: lru, lfu, ttl, cost)", val)
+		}
+	}
+
+	return config, nil
+}
+
+// Enabled reports whether any limit is set
+func (c *EvictionConfig) Enabled() bool {
+	return c.MaxEntries > 0 || c.MaxBytes > 0
+}
+
+// entryStats is the access metadata of one cache entry
+type entryStats struct {
+	key           string
+	embeddingKeys []string
+	size          int64
+	createdAt     time.Time
+	expiresAt     time.Time // Zero if the entry never expires
+	lastAccess    time.Time
+	hits          int64
+	value         float64 // USD one hit saves
+}
+
+// EvictionStats reports the state of the evictor
+type EvictionStats struct {
+	Policy       EvictionPolicy `json:"policy"`
+	MaxEntries   int            `json:"max_entries"`
+	MaxBytes     int64          `json:"max_bytes"`
+	Entries      int            `json:"entries"`
+	Bytes        int64          `json:"bytes"`
+	Evictions    int64          `json:"evictions"`
+	EvictedBytes int64          `json:"evicted_bytes"`
+	Failures     int64          `json:"failures"` // Evictions that failed to delete
+}
+
+// Valuer returns the upstream spend one hit on a cached response saves
+type Valuer func(response []byte) float64
+
+// Evictor tracks the size and access metadata of every entry and evicts
+// entries by policy once the cache grows beyond its limits
+type Evictor struct {
+	cache  *Cache
+	config *EvictionConfig
+	value  Valuer
+
+	mu      sync.Mutex
+	entries map[string]*entryStats
+	bytes   int64
+	stats   EvictionStats
+	now     func() time.Time
+	trigger chan struct{}
+}
+
+// NewEvictor creates an evictor for c. value prices responses for the cost
+// policy; nil values every response the same. Entries deleted through c, e.g.
+// by the sweeper, are forgotten automatically.
+func NewEvictor(c *Cache, config *EvictionConfig, value Valuer) *Evictor {
+	if value == nil {
+		value = func([]byte) float64 { return 1 }
+	}
+	e := &Evictor{
+		cache:   c,
+		config:  config,
+		value:   value,
+		entries: make(map[string]*entryStats),
+		now:     time.Now,
+		trigger: make(chan struct{}, 1),
+	}
+	c.OnDelete(e.forget)
+	return e
+}
+
+// Load rebuilds the metadata of the entries in the cache, replacing what
+// was tracked before, e.g. after a restore. Entries are read page by page,
+// vectors or not. Hit counts are read from the entry metadata; access times
+// are not persisted, so loaded entries start out as last used when created.
+func (e *Evictor) Load(ctx context.Context) error {
+	e.mu.Lock()
+	e.entries = make(map[string]*entryStats)
+	e.bytes = 0
+	e.mu.Unlock()
+
+	err := e.cache.walk(ctx, func(key string) error {
+		// Entries deleted since the scan are skipped
+		entry, err := e.cache.GetEntry(ctx, key)
+		if err != nil {
+			return nil
+		}
+
+		meta := entry.Metadata
+		var expiresAt time.Time
+		if meta.TTL > 0 {
+			expiresAt = meta.CreatedAt.Add(meta.TTL)
+		}
+		embKeys := make([]string, 0, len(entry.Embeddings))
+		for embKey := range entry.Embeddings {
+			embKeys = append(embKeys, embKey)
+		}
+		e.track(key, entrySize(entry.Value, entry.Prompt), meta.CreatedAt, expiresAt, meta.Hits, e.value(entry.Value), embKeys)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.signal()
+	return nil
+}
+
+// Track records a newly written entry and wakes Run if the cache grew beyond
+// its limits
+func (e *Evictor) Track(key string, response []byte, prompt string, ttl time.Duration, embeddingKeys ...string) {
+	now := e.now()
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+	e.track(key, entrySize(response, []byte(prompt)), now, expiresAt, 0, e.value(response), embeddingKeys)
+	e.signal()
+}
+
+// entrySize is the size an entry counts against MaxBytes. Keys and vectors
+// are small and fixed per entry, so MaxEntries bounds them instead.
+func entrySize(response, prompt []byte) int64 {
+	return int64(len(response) + len(prompt))
+}
+
+func (e *Evictor) track(key string, size int64, createdAt, expiresAt time.Time, hits int64, value float64, embeddingKeys []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if old, ok := e.entries[key]; ok {
+		e.bytes -= old.size
+		// A rewrite may embed in another namespace; keep the old vectors
+		for _, embKey := range old.embeddingKeys {
+			if !slices.Contains(embeddingKeys, embKey) {
+				embeddingKeys = append(embeddingKeys, embKey)
+			}
+		}
+	}
+	e.entries[key] = &entryStats{
+		key:           key,
+		embeddingKeys: embeddingKeys,
+		size:          size,
+		createdAt:     createdAt,
+		expiresAt:     expiresAt,
+		lastAccess:    createdAt,
+		hits:          hits,
+		value:         value,
+	}
+	e.bytes += size
+}
+
+// Touch records a hit on the entry stored under key
+func (e *Evictor) Touch(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if entry, ok := e.entries[key]; ok {
+		entry.hits++
+		entry.lastAccess = e.now()
+	}
+}
+
+// forget drops the metadata of a deleted entry
+func (e *Evictor) forget(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if entry, ok := e.entries[key]; ok {
+		e.bytes -= entry.size
+		delete(e.entries, key)
+	}
+}
+
+func (e *Evictor) overLimit() bool {
+	return (e.config.MaxEntries > 0 && len(e.entries) > e.config.MaxEntries) ||
+		(e.config.MaxBytes > 0 && e.bytes > e.config.MaxBytes)
+}
+
+// signal wakes Run if the cache is over its limits
+func (e *Evictor) signal() {
+	e.mu.Lock()
+	over := e.overLimit()
+	e.mu.Unlock()
+
+	if over {
+		select {
+		case e.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// victims returns the entries to evict, in order, to get back within limits.
+// Entries that already expired are always dropped first.
+func (e *Evictor) victims() []*entryStats {
+	now := e.now()
+	entries := make([]*entryStats, 0, len(e.entries))
+	for _, entry := range e.entries {
+		entries = append(entries, entry)
+	}
+
+	expired := func(entry *entryStats) bool {
+		return !entry.expiresAt.IsZero() && now.After(entry.expiresAt)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if ea, eb := expired(a), expired(b); ea != eb {
+			return ea
+		}
+		switch e.config.Policy {
+		case EvictLFU:
+			if a.hits != b.hits {
+				return a.hits < b.hits
+			}
+		case EvictTTL:
+			if !a.expiresAt.Equal(b.expiresAt) {
+				// Entries that never expire go last
+				if a.expiresAt.IsZero() || b.expiresAt.IsZero() {
+					return b.expiresAt.IsZero()
+				}
+				return a.expiresAt.Before(b.expiresAt)
+			}
+		case EvictCost:
+			if sa, sb := a.score(), b.score(); sa != sb {
+				return sa < sb
+			}
+		}
+		return a.lastAccess.Before(b.lastAccess)
+	})
+
+	count, bytes := len(e.entries), e.bytes
+	var victims []*entryStats
+	for _, entry := range entries {
+		withinEntries := e.config.MaxEntries <= 0 || count <= e.config.MaxEntries
+		withinBytes := e.config.MaxBytes <= 0 || bytes <= e.config.MaxBytes
+		if withinEntries && withinBytes && !expired(entry) {
+			break
+		}
+		victims = append(victims, entry)
+		count--
+		bytes -= entry.size
+	}
+	return victims
+}
+
+// score is the upstream spend an entry saves per byte of storage
+func (s *entryStats) score() float64 {
+	return float64(s.hits+1) * s.value / float64(s.size+1)
+}
+
+// Enforce evicts entries until the cache is within its limits and returns how
+// many were evicted
+func (e *Evictor) Enforce(ctx context.Context) (int, error) {
+	e.mu.Lock()
+	if !e.overLimit() {
+		e.mu.Unlock()
+		return 0, nil
+	}
+	victims := e.victims()
+	e.mu.Unlock()
+
+	evicted := 0
+	for _, entry := range victims {
+		if err := ctx.Err(); err != nil {
+			return evicted, err
+		}
+
+		// DeleteEntry removes all records at once and calls forget
+		if err := e.cache.DeleteEntry(ctx, entry.key, entry.embeddingKeys...); err != nil {
+			e.mu.Lock()
+			e.stats.Failures++
+			e.mu.Unlock()
+			return evicted, err
+		}
+
+		e.mu.Lock()
+		e.stats.Evictions++
+		e.stats.EvictedBytes += entry.size
+		e.mu.Unlock()
+		evicted++
+	}
+	return evicted, nil
+}
+
+// Stats returns the eviction counters and current usage
+func (e *Evictor) Stats() EvictionStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := e.stats
+	stats.Policy = e.config.Policy
+	stats.MaxEntries = e.config.MaxEntries
+	stats.MaxBytes = e.config.MaxBytes
+	stats.Entries = len(e.entries)
+	stats.Bytes = e.bytes
+	return stats
+}
+
+// Run evicts whenever a write takes the cache over its limits, until ctx is done
+func (e *Evictor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.trigger:
+			n, err := e.Enforce(ctx)
+			if err != nil {
+				log.Printf("Eviction error: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Evicted %d entries (%s policy)", n, e.config.Policy)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+func TestLoadEvictionConfig(t *testing.T) {
+	t.Setenv("CACHE_MAX_ENTRIES", "100")
+	t.Setenv("CACHE_MAX_BYTES", "invalid")
+	t.Setenv("CACHE_EVICTION_POLICY", "LFU")
+
+	config, err := LoadEvictionConfig()
+	if err != nil {
+		t.Fatalf("LoadEvictionConfig failed: %v", err)
+	}
+	if config.MaxEntries != 100 || config.MaxBytes != 0 || config.Policy != EvictLFU {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+	if !config.Enabled() {
+		t.Error("Expected limits to be enabled")
+	}
+
+	t.Setenv("CACHE_EVICTION_POLICY", "random")
+	if _, err := LoadEvictionConfig(); err == nil {
+		t.Error("Expected an unknown policy to fail")
+	}
+}
+
+// writeEntry stores every record of an entry and tracks it
+func writeEntry(t *testing.T, c *Cache, store *MockStorage, e *Evictor, key, response string, ttl time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.Set(ctx, key, []byte(response), ttl); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	store.Set(ctx, "prompt:"+key, []byte("prompt"))
+	store.Set(ctx, "emb:openai:"+key, []byte{1})
+	e.Track(key, []byte(response), "prompt", ttl, "emb:openai:"+key)
+}
+
+func TestEvictor_Policies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  EvictionPolicy
+		evicted string
+	}{
+		// a is oldest and used once, b expires first and is unused, c is the
+		// newest, used most and saves the least
+		{name: "lru", policy: EvictLRU, evicted: "b"},
+		{name: "lfu", policy: EvictLFU, evicted: "b"},
+		{name: "ttl", policy: EvictTTL, evicted: "b"},
+		{name: "cost", policy: EvictCost, evicted: "c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockStorage()
+			c := NewCache(store)
+			values := map[string]float64{"a-response": 1, "b-response": 1, "c-response": 0.001}
+			e := NewEvictor(c, &EvictionConfig{MaxEntries: 2, Policy: tt.policy}, func(response []byte) float64 {
+				return values[string(response)]
+			})
+			now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			e.now = func() time.Time { return now }
+
+			writeEntry(t, c, store, e, "a", "a-response", 0)
+			now = now.Add(time.Second)
+			writeEntry(t, c, store, e, "b", "b-response", time.Hour)
+			now = now.Add(time.Second)
+			e.Touch("a")
+			now = now.Add(time.Second)
+			writeEntry(t, c, store, e, "c", "c-response", 2*time.Hour)
+			e.Touch("c")
+			e.Touch("c")
+
+			n, err := e.Enforce(context.Background())
+			if err != nil {
+				t.Fatalf("Enforce failed: %v", err)
+			}
+			if n != 1 {
+				t.Fatalf("Expected 1 eviction, got %d", n)
+			}
+			for _, key := range []string{tt.evicted, "prompt:" + tt.evicted, "emb:openai:" + tt.evicted} {
+				if _, ok := store.data[key]; ok {
+					t.Errorf("Expected %s to be evicted", key)
+				}
+			}
+
+			stats := e.Stats()
+			if stats.Entries != 2 || stats.Evictions != 1 {
+				t.Errorf("Unexpected stats: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestEvictor_MaxBytes(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	e := NewEvictor(c, &EvictionConfig{MaxBytes: 40, Policy: EvictLRU}, nil)
+
+	// Each entry counts 10 response and 6 prompt bytes
+	for _, key := range []string{"a", "b", "c"} {
+		writeEntry(t, c, store, e, key, "0123456789", 0)
+	}
+
+	if _, err := e.Enforce(context.Background()); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	stats := e.Stats()
+	if stats.Bytes != 32 || stats.EvictedBytes != 16 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if _, ok := store.data["a"]; ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+}
+
+func TestEvictor_ExpiredFirstAndForget(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	e := NewEvictor(c, &EvictionConfig{MaxEntries: 2, Policy: EvictLFU}, nil)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	writeEntry(t, c, store, e, "expired", "response", time.Minute)
+	e.Touch("expired")
+	writeEntry(t, c, store, e, "a", "response", 0)
+	writeEntry(t, c, store, e, "b", "response", 0)
+	now = now.Add(time.Hour)
+
+	if _, err := e.Enforce(context.Background()); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	if _, ok := store.data["expired"]; ok {
+		t.Error("Expected the expired entry to be evicted despite its hits")
+	}
+
+	// Entries deleted elsewhere are forgotten
+	if err := c.DeleteEntry(context.Background(), "a", "emb:openai:a"); err != nil {
+		t.Fatalf("DeleteEntry failed: %v", err)
+	}
+	if stats := e.Stats(); stats.Entries != 1 {
+		t.Errorf("Expected 1 tracked entry, got %+v", stats)
+	}
+}
+
+func TestEvictor_Load(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+
+	c.SetEntry(ctx, &storage.Entry{
+		Key:        "live",
+		Value:      []byte("response"),
+		Prompt:     []byte("prompt"),
+		Embeddings: map[string][]byte{"emb:openai:live": {1}, "emb:mistral:live": {1}},
+		Metadata:   storage.EntryMetadata{TTL: time.Hour},
+	})
+	// Entries are found without their vectors, and orphaned vectors are left to the sweeper
+	c.Set(ctx, "novector", []byte("response"), time.Hour)
+	store.Set(ctx, "emb:openai:orphan", []byte{1})
+
+	e := NewEvictor(c, &EvictionConfig{MaxEntries: 10}, nil)
+	if err := e.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if stats := e.Stats(); stats.Entries != 2 || stats.Bytes != 22 {
+		t.Errorf("Expected both entries to be loaded, got %+v", stats)
+	}
+	if len(e.entries["live"].embeddingKeys) != 2 {
+		t.Errorf("Expected both namespaces, got %v", e.entries["live"].embeddingKeys)
+	}
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// ExportEmbedding is one vector of an exported entry
+type ExportEmbedding struct {
+	Namespace string `json:"namespace"`
+	Vector    []byte `json:"vector"` // Little-endian float32s, base64 in JSON
+}
+
+// ExportRecord is one line of a JSONL export. It only depends on the logical
+// entry, so it can be imported into any storage backend.
+type ExportRecord struct {
+	Key        string                `json:"key"`
+	Prompt     string                `json:"prompt"`
+	Response   json.RawMessage       `json:"response"` // Upstream body; a JSON string if the body is not JSON
+	Embeddings []ExportEmbedding     `json:"embeddings,omitempty"`
+	Metadata   storage.EntryMetadata `json:"metadata"`
+	ExpiresIn  int64                 `json:"expires_in"` // Seconds left to live at export; 0 never expires
+}
+
+// ExportFilter selects the entries to export. Zero fields match every entry.
+type ExportFilter struct {
+	Namespace string        // Only entries with a vector in this namespace, and only that vector
+	Model     string        // Only entries for this upstream model
+	MaxAge    time.Duration // Only entries created at most MaxAge ago
+	MinAge    time.Duration // Only entries created at least MinAge ago
+}
+
+// Export writes every live entry matching filter to w, one JSON record per
+// line, and returns the number of entries written
+func (c *Cache) Export(ctx context.Context, w io.Writer, filter ExportFilter) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	now := time.Now()
+	count := 0
+
+	err := c.walk(ctx, func(key string) error {
+		entry, err := c.GetEntry(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read entry %s: %w", key, err)
+		}
+
+		record, ok := exportRecord(entry, filter, now)
+		if !ok {
+			return nil
+		}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// exportRecord converts entry to a record, reporting false if it expired or
+// does not match filter
+func exportRecord(entry *storage.Entry, filter ExportFilter, now time.Time) (*ExportRecord, bool) {
+	meta := entry.Metadata
+	age := now.Sub(meta.CreatedAt)
+	if filter.Model != "" && meta.Model != filter.Model {
+		return nil, false
+	}
+	if (filter.MaxAge > 0 && age > filter.MaxAge) || (filter.MinAge > 0 && age < filter.MinAge) {
+		return nil, false
+	}
+
+	var expiresIn int64
+	if meta.TTL > 0 {
+		left := meta.CreatedAt.Add(meta.TTL).Sub(now)
+		if left <= 0 {
+			return nil, false
+		}
+		// Round up so an entry with less than a second left stays finite
+		expiresIn = int64((left + time.Second - 1) / time.Second)
+	}
+
+	record := &ExportRecord{
+		Key:       entry.Key,
+		Prompt:    string(entry.Prompt),
+		Response:  entry.Value,
+		ExpiresIn: expiresIn,
+	}
+	if !json.Valid(entry.Value) {
+		record.Response, _ = json.Marshal(string(entry.Value))
+	}
+
+	for key, vec := range entry.Embeddings {
+		namespace, _ := semantic.ParseEmbeddingKey(key)
+		if filter.Namespace != "" && namespace != filter.Namespace {
+			continue
+		}
+		record.Embeddings = append(record.Embeddings, ExportEmbedding{Namespace: namespace, Vector: vec})
+	}
+	if filter.Namespace != "" && len(record.Embeddings) == 0 {
+		return nil, false
+	}
+
+	// The keys depend on the backend; the vectors carry their namespaces
+	meta.EmbeddingKeys = nil
+	record.Metadata = meta
+	return record, true
+}
+
+// Embedder embeds the prompts of imported entries. It is implemented by
+// *semantic.SemanticEngine.
+type Embedder interface {
+	// Namespaces returns the namespaces the target cache searches, primary first
+	Namespaces() []string
+	EmbedEntry(ctx context.Context, hash, prompt string) (string, []byte, error)
+	EmbeddingModel(namespace string) string
+}
+
+// ImportStats reports what an import did
+type ImportStats struct {
+	Imported   int `json:"imported"`
+	Reembedded int `json:"reembedded"` // Entries whose prompt was embedded again
+	Skipped    int `json:"skipped"`    // Records without a valid key
+}
+
+// Import reads a JSONL export from r and stores its entries. Vectors in
+// namespaces embedder does not search are dropped, and entries without a
+// vector in its primary namespace are embedded again. A nil embedder keeps
+// the vectors as exported. Entries keep their creation time and expire when
+// they would have at the source.
+func (c *Cache) Import(ctx context.Context, r io.Reader, embedder Embedder) (ImportStats, error) {
+	var stats ImportStats
+	dec := json.NewDecoder(r)
+
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		var record ExportRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, fmt.Errorf("record %d: %w", n, err)
+		}
+		if record.Key == "" || strings.Contains(record.Key, ":") {
+			stats.Skipped++
+			continue
+		}
+
+		entry, err := importEntry(&record)
+		if err != nil {
+			return stats, fmt.Errorf("record %d: %w", n, err)
+		}
+
+		if embedder != nil {
+			reembedded, err := reembed(ctx, entry, embedder)
+			if err != nil {
+				return stats, fmt.Errorf("record %d: embed prompt: %w", n, err)
+			}
+			if reembedded {
+				stats.Reembedded++
+			}
+		}
+
+		if err := c.SetEntry(ctx, entry); err != nil {
+			return stats, fmt.Errorf("record %d: %w", n, err)
+		}
+		stats.Imported++
+	}
+}
+
+// importEntry converts record back to an entry, with its TTL set so it
+// expires ExpiresIn seconds from now
+func importEntry(record *ExportRecord) (*storage.Entry, error) {
+	response := []byte(record.Response)
+	if strings.HasPrefix(string(response), `"`) {
+		var text string
+		if err := json.Unmarshal(response, &text); err != nil {
+			return nil, err
+		}
+		response = []byte(text)
+	}
+
+	meta := record.Metadata
+	meta.EmbeddingKeys = nil
+	now := time.Now()
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = now
+	}
+	meta.TTL = 0
+	if record.ExpiresIn > 0 {
+		meta.TTL = now.Add(time.Duration(record.ExpiresIn) * time.Second).Sub(meta.CreatedAt)
+	}
+
+	entry := &storage.Entry{
+		Key:        record.Key,
+		Value:      response,
+		Prompt:     []byte(record.Prompt),
+		Embeddings: make(map[string][]byte, len(record.Embeddings)),
+		Metadata:   meta,
+	}
+	for _, emb := range record.Embeddings {
+		entry.Embeddings[semantic.EmbeddingKey(emb.Namespace, record.Key)] = emb.Vector
+	}
+	return entry, nil
+}
+
+// reembed drops the vectors of entry that embedder does not search and
+// embeds its prompt if none is in the primary namespace
+func reembed(ctx context.Context, entry *storage.Entry, embedder Embedder) (bool, error) {
+	namespaces := embedder.Namespaces()
+	for key := range entry.Embeddings {
+		namespace, _ := semantic.ParseEmbeddingKey(key)
+		if !slices.Contains(namespaces, namespace) {
+			delete(entry.Embeddings, key)
+		}
+	}
+	if len(namespaces) == 0 || entry.Embeddings[semantic.EmbeddingKey(namespaces[0], entry.Key)] != nil {
+		return false, nil
+	}
+
+	key, vec, err := embedder.EmbedEntry(ctx, entry.Key, string(entry.Prompt))
+	if err != nil {
+		return false, err
+	}
+	entry.Embeddings[key] = vec
+	namespace, _ := semantic.ParseEmbeddingKey(key)
+	entry.Metadata.EmbeddingProvider = namespace
+	entry.Metadata.EmbeddingModel = embedder.EmbeddingModel(namespace)
+	return true, nil
+}
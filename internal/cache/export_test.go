@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// fakeEmbedder embeds every prompt into the first of its namespaces
+type fakeEmbedder struct {
+	namespaces []string
+	calls      int
+}
+
+func (f *fakeEmbedder) Namespaces() []string { return f.namespaces }
+
+func (f *fakeEmbedder) EmbedEntry(ctx context.Context, hash, prompt string) (string, []byte, error) {
+	f.calls++
+	return "emb:" + f.namespaces[0] + ":" + hash, []byte{9, 9, 9, 9}, nil
+}
+
+func (f *fakeEmbedder) EmbeddingModel(namespace string) string { return namespace + "-model" }
+
+func seedExport(t *testing.T) *Cache {
+	t.Helper()
+	c := NewCache(NewMockStorage())
+	ctx := context.Background()
+	entries := []*storage.Entry{
+		{
+			Key:        "a",
+			Value:      []byte(`{"id":"a"}`),
+			Prompt:     []byte("prompt a"),
+			Embeddings: map[string][]byte{"emb:openai:a": {1, 0, 0, 0}, "emb:mistral:a": {2, 0, 0, 0}},
+			Metadata:   storage.EntryMetadata{Model: "gpt-4o", EmbeddingProvider: "openai", CreatedAt: time.Now().Add(-time.Hour), TTL: 2 * time.Hour},
+		},
+		{
+			Key:        "b",
+			Value:      []byte("not json"),
+			Prompt:     []byte("prompt b"),
+			Embeddings: map[string][]byte{"emb:openai:b": {3, 0, 0, 0}},
+			Metadata:   storage.EntryMetadata{Model: "gpt-4o-mini", EmbeddingProvider: "openai"},
+		},
+		{
+			Key:      "expired",
+			Value:    []byte(`{}`),
+			Metadata: storage.EntryMetadata{CreatedAt: time.Now().Add(-time.Hour), TTL: time.Minute},
+		},
+	}
+	for _, e := range entries {
+		if err := c.SetEntry(ctx, e); err != nil {
+			t.Fatalf("SetEntry failed: %v", err)
+		}
+	}
+	return c
+}
+
+func exportRecords(t *testing.T, c *Cache, filter ExportFilter) (*bytes.Buffer, map[string]ExportRecord) {
+	t.Helper()
+	var buf bytes.Buffer
+	count, err := c.Export(context.Background(), &buf, filter)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	records := make(map[string]ExportRecord)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record ExportRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid export line %q: %v", line, err)
+		}
+		records[record.Key] = record
+	}
+	if count != len(records) {
+		t.Errorf("Expected a count of %d, got %d", len(records), count)
+	}
+	return &buf, records
+}
+
+func TestCache_Export(t *testing.T) {
+	c := seedExport(t)
+
+	_, records := exportRecords(t, c, ExportFilter{})
+	if len(records) != 2 {
+		t.Fatalf("Expected the 2 live entries, got %v", records)
+	}
+	a := records["a"]
+	if string(a.Response) != `{"id":"a"}` || a.Prompt != "prompt a" || len(a.Embeddings) != 2 {
+		t.Errorf("Unexpected record: %+v", a)
+	}
+	if a.ExpiresIn < 3599 || a.ExpiresIn > 3600 {
+		t.Errorf("Expected about an hour left, got %ds", a.ExpiresIn)
+	}
+	if b := records["b"]; string(b.Response) != `"not json"` || b.ExpiresIn != 0 {
+		t.Errorf("Unexpected record: %+v", b)
+	}
+
+	tests := []struct {
+		name   string
+		filter ExportFilter
+		keys   []string
+	}{
+		{name: "model", filter: ExportFilter{Model: "gpt-4o"}, keys: []string{"a"}},
+		{name: "namespace", filter: ExportFilter{Namespace: "mistral"}, keys: []string{"a"}},
+		{name: "max age", filter: ExportFilter{MaxAge: 30 * time.Minute}, keys: []string{"b"}},
+		{name: "min age", filter: ExportFilter{MinAge: 30 * time.Minute}, keys: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, records := exportRecords(t, c, tt.filter)
+			if len(records) != len(tt.keys) {
+				t.Fatalf("Expected %v, got %v", tt.keys, records)
+			}
+			for _, key := range tt.keys {
+				if _, ok := records[key]; !ok {
+					t.Errorf("Expected %s to be exported", key)
+				}
+			}
+		})
+	}
+
+	// Only the vector of the requested namespace is exported
+	_, records = exportRecords(t, c, ExportFilter{Namespace: "mistral"})
+	if embs := records["a"].Embeddings; len(embs) != 1 || embs[0].Namespace != "mistral" {
+		t.Errorf("Expected only the mistral vector, got %+v", embs)
+	}
+}
+
+func TestCache_Import(t *testing.T) {
+	buf, _ := exportRecords(t, seedExport(t), ExportFilter{})
+	data := buf.Bytes()
+	ctx := context.Background()
+
+	t.Run("same namespace", func(t *testing.T) {
+		c := NewCache(NewMockStorage())
+		embedder := &fakeEmbedder{namespaces: []string{"openai"}}
+		stats, err := c.Import(ctx, bytes.NewReader(data), embedder)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if stats.Imported != 2 || stats.Reembedded != 0 || embedder.calls != 0 {
+			t.Errorf("Expected 2 entries imported as they were, got %+v", stats)
+		}
+
+		a, err := c.GetEntry(ctx, "a")
+		if err != nil {
+			t.Fatalf("GetEntry failed: %v", err)
+		}
+		if string(a.Value) != `{"id":"a"}` || len(a.Embeddings) != 1 || a.Embeddings["emb:openai:a"] == nil {
+			t.Errorf("Expected the response and the openai vector only, got %+v", a)
+		}
+		left := a.Metadata.CreatedAt.Add(a.Metadata.TTL).Sub(time.Now())
+		if left < 59*time.Minute || left > 61*time.Minute {
+			t.Errorf("Expected the entry to keep its remaining hour, got %s", left)
+		}
+
+		b, _ := c.GetEntry(ctx, "b")
+		if string(b.Value) != "not json" || b.Metadata.TTL != 0 {
+			t.Errorf("Unexpected entry: %+v", b)
+		}
+	})
+
+	t.Run("other namespace", func(t *testing.T) {
+		c := NewCache(NewMockStorage())
+		embedder := &fakeEmbedder{namespaces: []string{"ollama"}}
+		stats, err := c.Import(ctx, bytes.NewReader(data), embedder)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if stats.Imported != 2 || stats.Reembedded != 2 {
+			t.Errorf("Expected every entry to be embedded again, got %+v", stats)
+		}
+
+		a, _ := c.GetEntry(ctx, "a")
+		if len(a.Embeddings) != 1 || a.Embeddings["emb:ollama:a"] == nil {
+			t.Errorf("Expected only the ollama vector, got %v", a.Embeddings)
+		}
+		if a.Metadata.EmbeddingProvider != "ollama" || a.Metadata.EmbeddingModel != "ollama-model" {
+			t.Errorf("Unexpected metadata: %+v", a.Metadata)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		c := NewCache(NewMockStorage())
+		input := `{"key":"x","response":{}}` + "\n" + `{"key":"ns:x"}` + "\nnot json\n"
+		stats, err := c.Import(ctx, strings.NewReader(input), nil)
+		if err == nil || !strings.Contains(err.Error(), "record 3") {
+			t.Errorf("Expected an error on record 3, got %v", err)
+		}
+		if stats.Imported != 1 || stats.Skipped != 1 {
+			t.Errorf("Unexpected stats: %+v", stats)
+		}
+	})
+}
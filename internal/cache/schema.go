@@ -0,0 +1,422 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// SchemaKey holds the schema marker of a store. Keys under "system:" are
+// not cache entries.
+const SchemaKey = "system:schema_version"
+
+// SchemaVersion is the on-disk schema this binary writes:
+//
+//  1. JSON items; vectors under emb:<hash>; no metadata records
+//  2. Vectors under emb:<namespace>:<hash> and meta:<hash> records
+//  3. Items in the binary envelope
+//
+// Every earlier schema can be read and is migrated to the current one.
+const SchemaVersion = 3
+
+// migrationQueue is the number of entries read in an older schema that can
+// wait to be migrated; more are left to the next pass
+const migrationQueue = 1024
+
+// ErrNewerSchema is returned for stores written by a newer binary, which
+// this one could corrupt
+var ErrNewerSchema = errors.New("store was written by a newer version")
+
+// SchemaMarker is the value stored under SchemaKey
+type SchemaMarker struct {
+	Version       int `json:"version"`
+	MigratingFrom int `json:"migrating_from,omitempty"` // Oldest schema entries may still be in
+}
+
+// CheckSchema reads the schema marker of store and records that it now holds
+// the current schema. It fails with ErrNewerSchema if a newer binary wrote
+// the store. The returned marker has MigratingFrom set while entries in an
+// older schema may remain; a store without a marker predates it, unless it
+// is empty.
+func CheckSchema(ctx context.Context, store storage.Storage) (SchemaMarker, error) {
+	data, err := store.Get(ctx, SchemaKey)
+	if err != nil {
+		return SchemaMarker{}, err
+	}
+
+	var marker SchemaMarker
+	if data != nil {
+		if err := json.Unmarshal(data, &marker); err != nil {
+			return marker, fmt.Errorf("invalid schema marker: %w", err)
+		}
+	}
+	if marker.Version > SchemaVersion {
+		return marker, fmt.Errorf("%w: it has schema %d, this binary supports up to %d", ErrNewerSchema, marker.Version, SchemaVersion)
+	}
+	if marker.Version == SchemaVersion {
+		return marker, nil
+	}
+
+	updated := SchemaMarker{Version: SchemaVersion}
+	if data == nil {
+		empty, err := isEmpty(ctx, store)
+		if err != nil {
+			return marker, err
+		}
+		if !empty {
+			updated.MigratingFrom = 1
+		}
+	} else {
+		updated.MigratingFrom = marker.Version
+		if marker.MigratingFrom != 0 {
+			updated.MigratingFrom = min(marker.MigratingFrom, marker.Version)
+		}
+	}
+	return updated, writeSchema(ctx, store, updated)
+}
+
+// RestoreBackup loads a backup into store, which backuper is the backend of.
+// Backups written by a newer binary are rejected with ErrNewerSchema before
+// anything is loaded. The schema marker is then checked as on startup, so
+// the returned marker has MigratingFrom set if the restored entries need to
+// be migrated. r is read twice.
+func RestoreBackup(ctx context.Context, store storage.Storage, backuper storage.Backuper, r io.ReadSeeker) (SchemaMarker, error) {
+	data, err := storage.BackupValue(r, SchemaKey)
+	if err != nil {
+		return SchemaMarker{}, err
+	}
+	if data != nil {
+		var marker SchemaMarker
+		if err := json.Unmarshal(data, &marker); err != nil {
+			return SchemaMarker{}, fmt.Errorf("invalid schema marker in backup: %w", err)
+		}
+		if marker.Version > SchemaVersion {
+			return SchemaMarker{}, fmt.Errorf("%w: the backup has schema %d, this binary supports up to %d", ErrNewerSchema, marker.Version, SchemaVersion)
+		}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return SchemaMarker{}, err
+	}
+
+	// A backup without a marker predates it, so the marker of the store must
+	// not vouch for the restored entries; a migration the store still needed
+	// is kept
+	previous, err := CheckSchema(ctx, store)
+	if err != nil {
+		return SchemaMarker{}, err
+	}
+	if err := store.Delete(ctx, SchemaKey); err != nil {
+		return SchemaMarker{}, err
+	}
+
+	restoreErr := backuper.Restore(r)
+	// Even a failed restore may have loaded entries
+	marker, err := CheckSchema(ctx, store)
+	if err != nil {
+		return marker, err
+	}
+	if from := previous.MigratingFrom; from != 0 && (marker.MigratingFrom == 0 || from < marker.MigratingFrom) {
+		marker.MigratingFrom = from
+		if err := writeSchema(ctx, store, marker); err != nil {
+			return marker, err
+		}
+	}
+	return marker, restoreErr
+}
+
+func writeSchema(ctx context.Context, store storage.Storage, marker SchemaMarker) error {
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+	return store.Set(ctx, SchemaKey, data)
+}
+
+// isEmpty reports whether store holds no records. Redis may return empty
+// pages before the last one, so pages are read until one has a record.
+func isEmpty(ctx context.Context, store storage.Storage) (bool, error) {
+	cursor := ""
+	for {
+		items, next, err := store.Scan(ctx, "", cursor, storage.DefaultScanLimit)
+		if err != nil {
+			return false, err
+		}
+		if len(items) > 0 {
+			return false, nil
+		}
+		if next == "" {
+			return true, nil
+		}
+		cursor = next
+	}
+}
+
+// MigrationStatus reports the schema of the store and the migration progress
+type MigrationStatus struct {
+	SchemaVersion int        `json:"schema_version"`
+	MigratingFrom int        `json:"migrating_from,omitempty"`
+	Running       bool       `json:"running"`
+	Migrated      int64      `json:"migrated"` // Entries rewritten since startup
+	Failed        int64      `json:"failed"`   // Entries the last pass could not rewrite
+	LastError     string     `json:"last_error,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
+
+// Migrator rewrites entries written in an older schema: lazily, when the
+// cache reads one, and in a background pass over the whole store while the
+// schema marker says older entries may remain
+type Migrator struct {
+	cache      *Cache
+	store      storage.Storage
+	namespaces []string
+	queue      chan string
+
+	mu        sync.Mutex
+	marker    SchemaMarker
+	status    MigrationStatus
+	resets    int // Counts restores, so a pass notices the store was replaced
+	restoring int
+	passes    sync.WaitGroup // Passes started by Restore
+}
+
+// NewMigrator creates a migrator for the entries of c in store and has c
+// queue the older entries it reads. Vectors stored without a namespace are
+// moved to the first of namespaces, the primary embedding provider.
+func NewMigrator(c *Cache, store storage.Storage, marker SchemaMarker, namespaces []string) *Migrator {
+	m := &Migrator{
+		cache:      c,
+		store:      store,
+		namespaces: namespaces,
+		queue:      make(chan string, migrationQueue),
+		marker:     marker,
+	}
+	c.mu.Lock()
+	c.migrations = m.queue
+	c.mu.Unlock()
+	return m
+}
+
+// Status returns the schema of the store and the migration progress
+func (m *Migrator) Status() MigrationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := m.status
+	status.SchemaVersion = m.marker.Version
+	status.MigratingFrom = m.marker.MigratingFrom
+	return status
+}
+
+// Run migrates the whole store if the marker says older entries may remain,
+// then the entries the cache reads in an older schema, until ctx is done
+func (m *Migrator) Run(ctx context.Context) {
+	if m.Status().MigratingFrom != 0 {
+		m.migrate(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-m.queue:
+			if _, err := m.migrateEntry(ctx, key); err != nil {
+				log.Printf("Failed to migrate entry %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// Restore loads a backup into the store with RestoreBackup and migrates the
+// whole store in the background if the restored entries need it
+func (m *Migrator) Restore(ctx context.Context, backuper storage.Backuper, r io.ReadSeeker) (SchemaMarker, error) {
+	m.mu.Lock()
+	m.restoring++
+	m.mu.Unlock()
+
+	marker, err := RestoreBackup(ctx, m.store, backuper, r)
+
+	m.mu.Lock()
+	m.restoring--
+	if marker.Version != 0 {
+		m.marker = marker
+	}
+	m.resets++
+	// A running pass walks the store again when it notices the restore
+	running := m.status.Running
+	m.mu.Unlock()
+	if m.Status().MigratingFrom != 0 && !running {
+		m.passes.Add(1)
+		go func() {
+			defer m.passes.Done()
+			m.migrate(ctx)
+		}()
+	}
+	return marker, err
+}
+
+// Wait blocks until the migration passes started by Restore have returned;
+// cancel their context first to stop them
+func (m *Migrator) Wait() {
+	m.passes.Wait()
+}
+
+// migrate runs Migrate and logs the outcome
+func (m *Migrator) migrate(ctx context.Context) {
+	n, err := m.Migrate(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Schema migration failed: %v", err)
+	} else if ctx.Err() == nil {
+		log.Printf("Schema migration to version %d complete: %d entries rewritten", SchemaVersion, n)
+	}
+}
+
+// Migrate rewrites every entry in an older schema and returns how many it
+// rewrote. Once every entry is migrated the marker stops asking for a pass.
+func (m *Migrator) Migrate(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	if m.status.Running {
+		m.mu.Unlock()
+		return 0, fmt.Errorf("migration is already running")
+	}
+	m.status.Running = true
+	m.status.Failed = 0
+	m.status.LastError = ""
+	resets := m.resets
+	m.mu.Unlock()
+
+	migrated := 0
+	var failed int64
+	var lastErr error
+	for {
+		err := m.cache.walk(ctx, func(key string) error {
+			done, err := m.migrateEntry(ctx, key)
+			if err != nil {
+				failed++
+				lastErr = fmt.Errorf("migrate %s: %w", key, err)
+			} else if done {
+				migrated++
+			}
+			return nil
+		})
+		if err == nil && lastErr != nil {
+			err = fmt.Errorf("%d entries could not be migrated; last error: %w", failed, lastErr)
+		}
+
+		m.mu.Lock()
+		// A restore during the pass may have loaded entries it already walked past
+		if err == nil && m.resets != resets {
+			resets = m.resets
+			m.mu.Unlock()
+			continue
+		}
+
+		// Only a complete pass may clear the marker; otherwise the next start
+		// retries. A restore in progress starts another pass when it is done.
+		if err == nil && m.restoring > 0 {
+			m.status.Running = false
+			m.status.Failed = failed
+			m.mu.Unlock()
+			return migrated, nil
+		}
+		if err == nil {
+			err = writeSchema(ctx, m.store, SchemaMarker{Version: SchemaVersion})
+		}
+
+		now := time.Now()
+		m.status.Running = false
+		m.status.Failed = failed
+		if err != nil {
+			m.status.LastError = err.Error()
+		} else {
+			m.marker = SchemaMarker{Version: SchemaVersion}
+			m.status.CompletedAt = &now
+		}
+		m.mu.Unlock()
+
+		return migrated, err
+	}
+}
+
+// migrateEntry rewrites the entry under key in the current schema, reporting
+// false if it already was. Expired entries are left to the sweeper.
+func (m *Migrator) migrateEntry(ctx context.Context, key string) (bool, error) {
+	entry, err := m.store.GetEntry(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	legacyKey := semantic.EmbeddingKey("", key)
+	legacyVec, err := m.store.Get(ctx, legacyKey)
+	if err != nil {
+		return false, err
+	}
+	hasMeta := !entry.Metadata.CreatedAt.IsZero()
+	if currentItem(entry.Value) && hasMeta && legacyVec == nil {
+		return false, nil
+	}
+
+	item, err := m.cache.codec.Decode(entry.Value)
+	if err != nil {
+		return false, err
+	}
+	if item.Expired() {
+		return false, nil
+	}
+	entry.Value = item.Response
+
+	if entry.Embeddings == nil {
+		entry.Embeddings = make(map[string][]byte)
+	}
+
+	// Without metadata the vectors can only be found by their namespace
+	if !hasMeta {
+		entry.Metadata.CreatedAt = item.CreatedAt
+		entry.Metadata.TTL = item.TTL
+		for _, namespace := range m.namespaces {
+			embKey := semantic.EmbeddingKey(namespace, key)
+			vec, err := m.store.Get(ctx, embKey)
+			if err != nil {
+				return false, err
+			}
+			if vec != nil {
+				entry.Embeddings[embKey] = vec
+			}
+		}
+	}
+
+	// Vectors stored without a namespace belong to the primary provider
+	moveLegacy := legacyVec != nil && len(m.namespaces) > 0
+	if moveLegacy {
+		primaryKey := semantic.EmbeddingKey(m.namespaces[0], key)
+		if _, ok := entry.Embeddings[primaryKey]; !ok {
+			entry.Embeddings[primaryKey] = legacyVec
+		}
+		if entry.Metadata.EmbeddingProvider == "" {
+			entry.Metadata.EmbeddingProvider = m.namespaces[0]
+		}
+	}
+
+	if err := m.cache.SetEntry(ctx, entry); err != nil {
+		return false, err
+	}
+	if moveLegacy {
+		if err := m.store.Delete(ctx, legacyKey); err != nil {
+			return false, err
+		}
+	}
+
+	m.mu.Lock()
+	m.status.Migrated++
+	m.mu.Unlock()
+	return true, nil
+}
@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// setLegacyItem stores a schema 1 item: a JSON CacheItem without metadata
+func setLegacyItem(t *testing.T, store *MockStorage, key, response string, createdAt time.Time, ttl time.Duration) {
+	t.Helper()
+	data, err := json.Marshal(CacheItem{Response: []byte(response), CreatedAt: createdAt, TTL: ttl})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	store.Set(context.Background(), key, data)
+}
+
+func readMarker(t *testing.T, store *MockStorage) SchemaMarker {
+	t.Helper()
+	var marker SchemaMarker
+	data, _ := store.Get(context.Background(), SchemaKey)
+	if err := json.Unmarshal(data, &marker); err != nil {
+		t.Fatalf("Invalid marker %q: %v", data, err)
+	}
+	return marker
+}
+
+func TestCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		setup   func(store *MockStorage)
+		want    SchemaMarker
+		wantErr error
+	}{
+		{
+			name:  "empty store",
+			setup: func(store *MockStorage) {},
+			want:  SchemaMarker{Version: SchemaVersion},
+		},
+		{
+			name:  "unmarked data",
+			setup: func(store *MockStorage) { store.Set(ctx, "hash", []byte(`{}`)) },
+			want:  SchemaMarker{Version: SchemaVersion, MigratingFrom: 1},
+		},
+		{
+			name:  "older marker",
+			setup: func(store *MockStorage) { store.Set(ctx, SchemaKey, []byte(`{"version":2}`)) },
+			want:  SchemaMarker{Version: SchemaVersion, MigratingFrom: 2},
+		},
+		{
+			name:  "interrupted migration",
+			setup: func(store *MockStorage) { store.Set(ctx, SchemaKey, []byte(`{"version":3,"migrating_from":1}`)) },
+			want:  SchemaMarker{Version: SchemaVersion, MigratingFrom: 1},
+		},
+		{
+			name:    "newer marker",
+			setup:   func(store *MockStorage) { store.Set(ctx, SchemaKey, []byte(`{"version":99}`)) },
+			wantErr: ErrNewerSchema,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockStorage()
+			tt.setup(store)
+
+			marker, err := CheckSchema(ctx, store)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				if got := readMarker(t, store); got.Version != 99 {
+					t.Errorf("Expected the newer marker to be left alone, got %+v", got)
+				}
+				return
+			}
+			if marker != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, marker)
+			}
+			if stored := readMarker(t, store); stored != tt.want {
+				t.Errorf("Expected %+v to be stored, got %+v", tt.want, stored)
+			}
+		})
+	}
+}
+
+func TestMigrator_Migrate(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+	createdAt := time.Now().Add(-time.Minute)
+
+	// Schema 1: JSON item, prompt and a vector without a namespace
+	setLegacyItem(t, store, "v1", "response 1", createdAt, time.Hour)
+	store.Set(ctx, "prompt:v1", []byte("prompt 1"))
+	store.Set(ctx, "emb:v1", []byte{1})
+
+	// Schema 2: JSON item with metadata and a namespaced vector
+	setLegacyItem(t, store, "v2", "response 2", createdAt, 0)
+	store.PutEntry(ctx, &storage.Entry{
+		Key:        "v2",
+		Value:      store.data["v2"],
+		Prompt:     []byte("prompt 2"),
+		Embeddings: map[string][]byte{"emb:mistral:v2": {2}},
+		Metadata:   storage.EntryMetadata{Model: "gpt-4o", CreatedAt: createdAt, Hits: 4},
+	})
+
+	// Current entries and expired ones are left alone
+	c.SetEntry(ctx, &storage.Entry{Key: "v3", Value: []byte("response 3"), Metadata: storage.EntryMetadata{TTL: time.Hour}})
+	setLegacyItem(t, store, "expired", "old", createdAt.Add(-time.Hour), time.Minute)
+
+	marker, _ := CheckSchema(ctx, store)
+	m := NewMigrator(c, store, marker, []string{"openai", "mistral"})
+	n, err := m.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 entries migrated, got %d", n)
+	}
+
+	for _, key := range []string{"v1", "v2", "v3"} {
+		if !currentItem(store.data[key]) {
+			t.Errorf("Expected %s in the current envelope", key)
+		}
+	}
+	if currentItem(store.data["expired"]) {
+		t.Error("Expected the expired entry to be left to the sweeper")
+	}
+
+	v1, err := c.GetEntry(ctx, "v1")
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if string(v1.Value) != "response 1" || string(v1.Prompt) != "prompt 1" || v1.Embeddings["emb:openai:v1"] == nil {
+		t.Errorf("Unexpected migrated entry: %+v", v1)
+	}
+	if !v1.Metadata.CreatedAt.Equal(createdAt) || v1.Metadata.TTL != time.Hour || v1.Metadata.EmbeddingProvider != "openai" {
+		t.Errorf("Expected the metadata to be rebuilt from the item, got %+v", v1.Metadata)
+	}
+	if _, ok := store.data["emb:v1"]; ok {
+		t.Error("Expected the vector without a namespace to be moved")
+	}
+
+	v2, _ := c.GetEntry(ctx, "v2")
+	if v2.Metadata.Hits != 4 || v2.Metadata.Model != "gpt-4o" || v2.Embeddings["emb:mistral:v2"] == nil {
+		t.Errorf("Expected the metadata and vectors to be kept, got %+v", v2)
+	}
+
+	if got := readMarker(t, store); got != (SchemaMarker{Version: SchemaVersion}) {
+		t.Errorf("Expected a complete pass to clear the marker, got %+v", got)
+	}
+	if status := m.Status(); status.MigratingFrom != 0 || status.Migrated != 2 || status.CompletedAt == nil {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
+
+func TestMigrator_Lazy(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+	setLegacyItem(t, store, "hash", "response", time.Now(), 0)
+	c.Set(ctx, "current", []byte("response"), 0)
+
+	m := NewMigrator(c, store, SchemaMarker{Version: SchemaVersion}, []string{"openai"})
+	if _, found, _ := c.Get(ctx, "hash"); !found {
+		t.Fatal("Expected the legacy entry to be served")
+	}
+	c.Get(ctx, "current")
+
+	if len(m.queue) != 1 {
+		t.Fatalf("Expected only the legacy entry to be queued, got %d", len(m.queue))
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		m.Run(runCtx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for m.Status().Migrated == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if m.Status().Migrated != 1 {
+		t.Errorf("Expected the queued entry to be migrated, got %+v", m.Status())
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		marker  string // Stored in the backup unless empty
+		want    SchemaMarker
+		wantErr error
+	}{
+		{name: "current backup", marker: `{"version":3}`, want: SchemaMarker{Version: SchemaVersion}},
+		{name: "older backup", marker: `{"version":2}`, want: SchemaMarker{Version: SchemaVersion, MigratingFrom: 2}},
+		{name: "unmarked backup", want: SchemaMarker{Version: SchemaVersion, MigratingFrom: 1}},
+		{name: "newer backup", marker: `{"version":99}`, wantErr: ErrNewerSchema},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := storage.NewBadgerStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewBadgerStore failed: %v", err)
+			}
+			defer source.Close()
+			source.Set(ctx, "hash", []byte(`{}`))
+			if tt.marker != "" {
+				source.Set(ctx, SchemaKey, []byte(tt.marker))
+			}
+			var backup bytes.Buffer
+			if _, err := source.Backup(&backup, 0); err != nil {
+				t.Fatalf("Backup failed: %v", err)
+			}
+
+			// The target is current, so only the backup can ask for a migration
+			target, err := storage.NewBadgerStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewBadgerStore failed: %v", err)
+			}
+			defer target.Close()
+			if _, err := CheckSchema(ctx, target); err != nil {
+				t.Fatalf("CheckSchema failed: %v", err)
+			}
+
+			marker, err := RestoreBackup(ctx, target, target, bytes.NewReader(backup.Bytes()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				if got, _ := target.Get(ctx, "hash"); got != nil {
+					t.Error("Expected the incompatible backup not to be loaded")
+				}
+				return
+			}
+			if marker != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, marker)
+			}
+			if got, _ := target.Get(ctx, "hash"); got == nil {
+				t.Error("Expected the backup to be loaded")
+			}
+			if again, _ := CheckSchema(ctx, target); again != tt.want {
+				t.Errorf("Expected %+v to be stored, got %+v", tt.want, again)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// GarbageCollector is implemented by storage backends that reclaim disk space
+// explicitly, such as Badger's value log
+type GarbageCollector interface {
+	CollectGarbage() error
+}
+
+// SweepStats reports what one sweep removed
+type SweepStats struct {
+	Scanned  int // Embedding keys examined
+	Expired  int // Entries removed because their response expired
+	Orphaned int // Entries removed because their response is gone
+}
+
+// Sweeper deletes expired entries and the embedding and prompt records left
+// behind by responses that no longer exist. Native TTLs expire most records on
+// their own; the sweeper covers entries written before TTLs were coordinated
+// and vectors re-embedded by the failover backfill.
+type Sweeper struct {
+	cache *Cache
+	store storage.Storage
+}
+
+// NewSweeper creates a sweeper over the entries of c in store
+func NewSweeper(c *Cache, store storage.Storage) *Sweeper {
+	return &Sweeper{cache: c, store: store}
+}
+
+// Sweep runs one pass over every stored embedding, a page at a time
+func (s *Sweeper) Sweep(ctx context.Context) (SweepStats, error) {
+	var stats SweepStats
+
+	// An entry has one embedding per provider namespace; those in a
+	// namespace the cache does not know of are met later in the scan
+	deleted := make(map[string]bool)
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		items, next, err := s.store.Scan(ctx, storage.EmbeddingPrefix, cursor, walkBatch)
+		if err != nil {
+			return stats, err
+		}
+
+		for _, kv := range items {
+			stats.Scanned++
+			hash := semantic.HashFromKey(kv.Key)
+			if deleted[hash] {
+				if err := s.store.Delete(ctx, kv.Key); err != nil {
+					return stats, err
+				}
+				continue
+			}
+
+			item, found, err := s.cache.Inspect(ctx, hash)
+			if err != nil {
+				log.Printf("Sweeper: failed to read entry %s: %v", hash, err)
+				continue
+			}
+
+			switch {
+			case !found:
+				stats.Orphaned++
+			case item.Expired():
+				stats.Expired++
+			default:
+				continue
+			}
+
+			if err := s.cache.DeleteEntry(ctx, hash, kv.Key); err != nil {
+				return stats, err
+			}
+			deleted[hash] = true
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	// Natively expired records take disk space until garbage is collected,
+	// so collect even when this pass deleted nothing
+	if gc, ok := storage.Base(s.store).(GarbageCollector); ok {
+		if err := gc.CollectGarbage(); err != nil {
+			log.Printf("Sweeper: garbage collection failed: %v", err)
+		}
+	}
+
+	return stats, nil
+}
+
+// Run sweeps every interval until ctx is done
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := s.Sweep(ctx)
+			if err != nil {
+				log.Printf("Sweeper error: %v", err)
+			}
+			if stats.Expired+stats.Orphaned > 0 {
+				log.Printf("Swept %d expired and %d orphaned entries", stats.Expired, stats.Orphaned)
+			}
+		}
+	}
+}
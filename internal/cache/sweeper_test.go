@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// CollectingStorage counts garbage collections
+type CollectingStorage struct {
+	*MockStorage
+	collections int
+}
+
+func (s *CollectingStorage) CollectGarbage() error {
+	s.collections++
+	return nil
+}
+
+func TestCache_DeleteEntry(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+
+	c.Set(ctx, "hash", []byte("response"), time.Hour)
+	store.Set(ctx, "prompt:hash", []byte("prompt"))
+	store.Set(ctx, "emb:openai:hash", []byte{1})
+	store.Set(ctx, "emb:openai:other", []byte{2})
+
+	if err := c.DeleteEntry(ctx, "hash", "emb:openai:hash"); err != nil {
+		t.Fatalf("DeleteEntry failed: %v", err)
+	}
+	for _, key := range []string{"hash", "prompt:hash", "emb:openai:hash"} {
+		if _, ok := store.data[key]; ok {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+	if _, ok := store.data["emb:openai:other"]; !ok {
+		t.Error("Expected other entries to be kept")
+	}
+}
+
+func TestSweeper_Sweep(t *testing.T) {
+	store := &CollectingStorage{MockStorage: NewMockStorage()}
+	c := NewCache(store)
+	ctx := context.Background()
+
+	// live: response valid; expired: response TTL elapsed; orphan: no response
+	c.Set(ctx, "live", []byte("response"), time.Hour)
+	c.Set(ctx, "expired", []byte("response"), -time.Hour)
+	for _, hash := range []string{"live", "expired", "orphan"} {
+		store.Set(ctx, "prompt:"+hash, []byte("prompt"))
+		store.Set(ctx, "emb:openai:"+hash, []byte{1})
+	}
+	store.Set(ctx, "emb:mistral:expired", []byte{1})
+
+	stats, err := NewSweeper(c, store).Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	if stats.Scanned != 4 || stats.Expired != 1 || stats.Orphaned != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	for _, key := range []string{"expired", "prompt:expired", "emb:openai:expired", "emb:mistral:expired", "prompt:orphan", "emb:openai:orphan"} {
+		if _, ok := store.data[key]; ok {
+			t.Errorf("Expected %s to be swept", key)
+		}
+	}
+	for _, key := range []string{"live", "prompt:live", "emb:openai:live"} {
+		if _, ok := store.data[key]; !ok {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+	if store.collections != 1 {
+		t.Errorf("Expected garbage to be collected once, got %d", store.collections)
+	}
+}
+
+func TestSweeper_SweepPages(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+
+	for i := 0; i < 2*walkBatch+10; i++ {
+		store.Set(ctx, fmt.Sprintf("emb:openai:orphan%04d", i), []byte{1})
+	}
+
+	stats, err := NewSweeper(c, store).Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if stats.Scanned != 2*walkBatch+10 || stats.Orphaned != 2*walkBatch+10 || len(store.data) != 0 {
+		t.Errorf("Expected every page to be swept, got %+v with %d records left", stats, len(store.data))
+	}
+}
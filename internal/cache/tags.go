@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+const (
+	// MaxTags is the most tags an entry can carry
+	MaxTags = 32
+
+	// maxTagLength is the longest a tag can be
+	maxTagLength = 128
+
+	// MaxTagInvalidation is the most entries InvalidateTag deletes, all in
+	// one atomic batch
+	MaxTagInvalidation = 10000
+)
+
+// ErrTagTooLarge is returned when a tag is on too many entries to delete
+// them in one atomic batch
+var ErrTagTooLarge = errors.New("tag is on too many entries")
+
+// validTag reports whether tag only holds letters, digits and . _ - = @, so
+// it is safe in index keys and URL paths
+func validTag(tag string) bool {
+	if tag == "" || len(tag) > maxTagLength {
+		return false
+	}
+	for _, r := range tag {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("._-=@", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTags parses a comma-separated list of tags, as sent in a header
+func ParseTags(list string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return NormalizeTags(tags)
+}
+
+// NormalizeTags validates tags and returns them sorted, without duplicates
+func NormalizeTags(tags []string) ([]string, error) {
+	for _, tag := range tags {
+		if !validTag(tag) {
+			return nil, fmt.Errorf("invalid tag %q: tags are 1 to %d letters, digits and . _ - = @", tag, maxTagLength)
+		}
+	}
+	tags = slices.Compact(slices.Sorted(slices.Values(tags)))
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("an entry can carry at most %d tags, got %d", MaxTags, len(tags))
+	}
+	return tags, nil
+}
+
+// TaggedKeys returns the keys of the entries carrying tag
+func (c *Cache) TaggedKeys(ctx context.Context, tag string) ([]string, error) {
+	prefix := storage.TagKey(tag, "")
+	var keys []string
+	cursor := ""
+	for {
+		items, next, err := c.store.Scan(ctx, prefix, cursor, walkBatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			keys = append(keys, strings.TrimPrefix(item.Key, prefix))
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	// Redis may return a key twice
+	return slices.Compact(slices.Sorted(slices.Values(keys))), nil
+}
+
+// InvalidateTag atomically deletes every entry carrying tag, with all their
+// records, and returns how many entries it deleted. A tag carried by more
+// than MaxTagInvalidation entries is left alone, with ErrTagTooLarge.
+func (c *Cache) InvalidateTag(ctx context.Context, tag string) (int, error) {
+	if !validTag(tag) {
+		return 0, fmt.Errorf("invalid tag %q", tag)
+	}
+	keys, err := c.TaggedKeys(ctx, tag)
+	if err != nil {
+		return 0, err
+	}
+	if len(keys) > MaxTagInvalidation {
+		return 0, fmt.Errorf("%w: %d entries carry %s, at most %d can be deleted at once", ErrTagTooLarge, len(keys), tag, MaxTagInvalidation)
+	}
+
+	var batch []string
+	found := 0
+	for _, key := range keys {
+		value, err := c.store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if value != nil {
+			found++
+		}
+		records, err := c.recordKeys(ctx, key, nil)
+		if err != nil {
+			return 0, err
+		}
+		// The index record is deleted even if the metadata is gone
+		batch = append(batch, records...)
+		batch = append(batch, storage.TagKey(tag, key))
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if err := c.store.DeleteBatch(ctx, slices.Compact(slices.Sorted(slices.Values(batch)))); err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		c.notifyDelete(key)
+	}
+	return found, nil
+}
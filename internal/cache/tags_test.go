@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{list: "", want: nil},
+		{list: "pricing, docs-v2,pricing", want: []string{"docs-v2", "pricing"}},
+		{list: "doc=faq@3, feature_x", want: []string{"doc=faq@3", "feature_x"}},
+		{list: "a:b", wantErr: true},
+		{list: "docs/v2", wantErr: true},
+		{list: "two words", wantErr: true},
+		{list: strings.Repeat("x", 129), wantErr: true},
+		{list: strings.Repeat("t,", MaxTags+1), want: []string{"t"}}, // Duplicates do not count
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := ParseTags(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	var many []string
+	for i := 0; i <= MaxTags; i++ {
+		many = append(many, fmt.Sprintf("tag%d", i))
+	}
+	if _, err := NormalizeTags(many); err == nil {
+		t.Errorf("Expected more than %d tags to fail", MaxTags)
+	}
+}
+
+// batchStore records the size of every DeleteBatch
+type batchStore struct {
+	*MockStorage
+	batches []int
+}
+
+func (s *batchStore) DeleteBatch(ctx context.Context, keys []string) error {
+	s.batches = append(s.batches, len(keys))
+	return s.MockStorage.DeleteBatch(ctx, keys)
+}
+
+func TestCache_InvalidateTagAtomic(t *testing.T) {
+	store := &batchStore{MockStorage: NewMockStorage()}
+	c := NewCache(store)
+	ctx := context.Background()
+
+	for i := 0; i < 2*walkBatch+10; i++ {
+		c.SetEntry(ctx, &storage.Entry{
+			Key:      fmt.Sprintf("key%05d", i),
+			Value:    []byte("response"),
+			Metadata: storage.EntryMetadata{Tags: []string{"bulk"}, TTL: time.Hour},
+		})
+	}
+
+	n, err := c.InvalidateTag(ctx, "bulk")
+	if err != nil {
+		t.Fatalf("InvalidateTag failed: %v", err)
+	}
+	if n != 2*walkBatch+10 {
+		t.Errorf("Expected %d entries invalidated, got %d", 2*walkBatch+10, n)
+	}
+	if len(store.batches) != 1 {
+		t.Errorf("Expected a single batch, got %v", store.batches)
+	}
+	if keys, _ := c.TaggedKeys(ctx, "bulk"); len(keys) != 0 {
+		t.Errorf("Expected the tag index to be empty, got %d keys", len(keys))
+	}
+
+	// A tag too large for one batch is left alone
+	for i := 0; i <= MaxTagInvalidation; i++ {
+		store.Set(ctx, storage.TagKey("huge", fmt.Sprintf("key%05d", i)), nil)
+	}
+	store.batches = nil
+	if n, err := c.InvalidateTag(ctx, "huge"); !errors.Is(err, ErrTagTooLarge) || n != 0 {
+		t.Errorf("Expected ErrTagTooLarge, got (%d, %v)", n, err)
+	}
+	if len(store.batches) != 0 {
+		t.Errorf("Expected nothing deleted, got %v", store.batches)
+	}
+}
+
+func TestCache_InvalidateTag(t *testing.T) {
+	store := NewMockStorage()
+	c := NewCache(store)
+	ctx := context.Background()
+
+	for i, tags := range [][]string{{"pricing"}, {"pricing", "docs-v2"}, {"docs-v2"}, nil} {
+		key := fmt.Sprintf("key%d", i)
+		c.SetEntry(ctx, &storage.Entry{
+			Key:        key,
+			Value:      []byte("response"),
+			Embeddings: map[string][]byte{"emb:openai:" + key: {1}},
+			Metadata:   storage.EntryMetadata{Tags: tags, TTL: time.Hour},
+		})
+	}
+	// A vector the metadata does not list goes as well
+	store.Set(ctx, "emb:key1", []byte{1})
+
+	var forgotten []string
+	c.OnDelete(func(key string) { forgotten = append(forgotten, key) })
+
+	if keys, _ := c.TaggedKeys(ctx, "pricing"); fmt.Sprint(keys) != "[key0 key1]" {
+		t.Errorf("Expected key0 and key1 to be tagged, got %v", keys)
+	}
+
+	n, err := c.InvalidateTag(ctx, "pricing")
+	if err != nil {
+		t.Fatalf("InvalidateTag failed: %v", err)
+	}
+	if n != 2 || len(forgotten) != 2 {
+		t.Errorf("Expected 2 entries invalidated, got %d (%v)", n, forgotten)
+	}
+	for _, key := range []string{"key0", "meta:key1", "emb:openai:key1", "emb:key1", "tag:pricing:key0", "tag:docs-v2:key1"} {
+		if _, ok := store.data[key]; ok {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+	if keys, _ := c.TaggedKeys(ctx, "docs-v2"); fmt.Sprint(keys) != "[key2]" {
+		t.Errorf("Expected only key2 to keep its tag, got %v", keys)
+	}
+	if _, ok := store.data["key3"]; !ok {
+		t.Error("Expected untagged entries to be kept")
+	}
+
+	// Deleting an entry drops it from the index too
+	c.DeleteEntry(ctx, "key2")
+	if _, ok := store.data["tag:docs-v2:key2"]; ok {
+		t.Error("Expected DeleteEntry to remove the tag index record")
+	}
+	if n, err := c.InvalidateTag(ctx, "pricing"); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to invalidate, got (%d, %v)", n, err)
+	}
+
+	// Rewriting an entry with other tags drops it from the old ones
+	c.SetEntry(ctx, &storage.Entry{Key: "key3", Value: []byte("response"), Metadata: storage.EntryMetadata{Tags: []string{"docs-v2"}, TTL: time.Hour}})
+	c.SetEntry(ctx, &storage.Entry{Key: "key3", Value: []byte("response"), Metadata: storage.EntryMetadata{Tags: []string{"docs-v3"}, TTL: time.Hour}})
+	if n, err := c.InvalidateTag(ctx, "docs-v2"); err != nil || n != 0 {
+		t.Errorf("Expected the retagged entry to survive its old tag, got (%d, %v)", n, err)
+	}
+	if _, ok := store.data["key3"]; !ok {
+		t.Error("Expected the retagged entry to be kept")
+	}
+}
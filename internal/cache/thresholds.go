@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// ThresholdsKey holds the thresholds set at runtime with their history, so
+// they outlive a restart
+const ThresholdsKey = "system:thresholds"
+
+// MaxThresholdHistory is the number of changes kept, newest last
+const MaxThresholdHistory = 50
+
+// ErrInvalidThresholds is returned for updates that fail validation
+var ErrInvalidThresholds = errors.New("invalid thresholds")
+
+// ThresholdChange is one runtime update of the thresholds
+type ThresholdChange struct {
+	Time     time.Time           `json:"time"`
+	Previous semantic.Thresholds `json:"previous"`
+	Current  semantic.Thresholds `json:"current"`
+	Reason   string              `json:"reason,omitempty"`
+}
+
+// ThresholdRecord is the value stored under ThresholdsKey
+type ThresholdRecord struct {
+	Thresholds semantic.Thresholds `json:"thresholds"`
+	UpdatedAt  *time.Time          `json:"updated_at,omitempty"` // Unset while the startup configuration applies
+	History    []ThresholdChange   `json:"history"`
+}
+
+// ThresholdStore persists the thresholds of a semantic engine. Updates are
+// validated, written to the store and only then swapped into the engine.
+type ThresholdStore struct {
+	store  storage.Storage
+	engine *semantic.SemanticEngine
+	mu     sync.Mutex // Serializes updates, so the history has no gaps
+}
+
+// NewThresholdStore creates a store for the thresholds of engine
+func NewThresholdStore(store storage.Storage, engine *semantic.SemanticEngine) *ThresholdStore {
+	return &ThresholdStore{store: store, engine: engine}
+}
+
+func (ts *ThresholdStore) read(ctx context.Context) (*ThresholdRecord, error) {
+	data, err := ts.store.Get(ctx, ThresholdsKey)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var record ThresholdRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid thresholds record: %w", err)
+	}
+	return &record, nil
+}
+
+// Load applies the persisted thresholds to the engine, overriding the
+// startup configuration. It reports whether any were persisted.
+func (ts *ThresholdStore) Load(ctx context.Context) (bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	record, err := ts.read(ctx)
+	if err != nil || record == nil {
+		return false, err
+	}
+	if err := ts.engine.SetThresholds(record.Thresholds); err != nil {
+		return false, fmt.Errorf("persisted thresholds: %w", err)
+	}
+	return true, nil
+}
+
+// Record returns the thresholds in effect with the history of changes
+func (ts *ThresholdStore) Record(ctx context.Context) (*ThresholdRecord, error) {
+	record, err := ts.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		record = &ThresholdRecord{History: []ThresholdChange{}}
+	}
+	record.Thresholds = ts.engine.Thresholds()
+	return record, nil
+}
+
+// ThresholdPatch changes some of the thresholds; nil fields keep their value
+type ThresholdPatch struct {
+	High             *float32 `json:"high_threshold"`
+	Low              *float32 `json:"low_threshold"`
+	GrayZoneVerifier *bool    `json:"gray_zone_verifier"`
+}
+
+// IsZero reports whether patch changes nothing
+func (p ThresholdPatch) IsZero() bool {
+	return p == ThresholdPatch{}
+}
+
+// Apply returns thresholds with the fields of patch set
+func (p ThresholdPatch) Apply(thresholds semantic.Thresholds) semantic.Thresholds {
+	if p.High != nil {
+		thresholds.High = *p.High
+	}
+	if p.Low != nil {
+		thresholds.Low = *p.Low
+	}
+	if p.GrayZoneVerifier != nil {
+		thresholds.GrayZoneVerifier = *p.GrayZoneVerifier
+	}
+	return thresholds
+}
+
+// Update applies patch to the thresholds in effect, validates the result,
+// persists it with a history entry and swaps it into the engine. Updates are
+// serialized, so concurrent patches of different fields are all kept. Nothing
+// changes if any step fails.
+func (ts *ThresholdStore) Update(ctx context.Context, patch ThresholdPatch, reason string) (*ThresholdRecord, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	previous := ts.engine.Thresholds()
+	thresholds := patch.Apply(previous)
+	if err := thresholds.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidThresholds, err)
+	}
+
+	record, err := ts.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		record = &ThresholdRecord{}
+	}
+
+	now := time.Now()
+	record.History = append(record.History, ThresholdChange{
+		Time:     now,
+		Previous: previous,
+		Current:  thresholds,
+		Reason:   reason,
+	})
+	if len(record.History) > MaxThresholdHistory {
+		record.History = record.History[len(record.History)-MaxThresholdHistory:]
+	}
+	record.Thresholds = thresholds
+	record.UpdatedAt = &now
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := ts.store.Set(ctx, ThresholdsKey, data); err != nil {
+		return nil, err
+	}
+	if err := ts.engine.SetThresholds(thresholds); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
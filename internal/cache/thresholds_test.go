@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+)
+
+func newTestEngine() *semantic.SemanticEngine {
+	return semantic.NewSemanticEngine(nil, nil, nil, &semantic.Config{HighThreshold: 0.70, LowThreshold: 0.30, EnableGrayZoneVerifier: true})
+}
+
+// setAll returns a patch setting every field to thresholds
+func setAll(thresholds semantic.Thresholds) ThresholdPatch {
+	return ThresholdPatch{High: &thresholds.High, Low: &thresholds.Low, GrayZoneVerifier: &thresholds.GrayZoneVerifier}
+}
+
+func TestThresholdStore(t *testing.T) {
+	store := NewMockStorage()
+	engine := newTestEngine()
+	ts := NewThresholdStore(store, engine)
+	ctx := context.Background()
+
+	if loaded, err := ts.Load(ctx); loaded || err != nil {
+		t.Fatalf("Expected nothing to load, got (%v, %v)", loaded, err)
+	}
+	record, _ := ts.Record(ctx)
+	if record.Thresholds.High != 0.70 || record.UpdatedAt != nil || len(record.History) != 0 {
+		t.Errorf("Expected the startup thresholds without history, got %+v", record)
+	}
+
+	invalid := []semantic.Thresholds{
+		{High: 0.5, Low: 0.5},
+		{High: 0.4, Low: 0.6},
+		{High: 1.2, Low: 0.5},
+		{High: 0.8, Low: 0},
+	}
+	for _, thresholds := range invalid {
+		if _, err := ts.Update(ctx, setAll(thresholds), ""); !errors.Is(err, ErrInvalidThresholds) {
+			t.Errorf("Expected %+v to be rejected, got %v", thresholds, err)
+		}
+	}
+	// A partial update is validated against the thresholds in effect
+	low := float32(0.75)
+	if _, err := ts.Update(ctx, ThresholdPatch{Low: &low}, ""); !errors.Is(err, ErrInvalidThresholds) {
+		t.Errorf("Expected a low threshold above the high one to be rejected, got %v", err)
+	}
+	if engine.Thresholds().High != 0.70 || len(store.data) != 0 {
+		t.Error("Expected rejected updates to change nothing")
+	}
+
+	for i := 0; i < MaxThresholdHistory+5; i++ {
+		high := 0.80 + float32(i%10)/100
+		if _, err := ts.Update(ctx, setAll(semantic.Thresholds{High: high, Low: 0.40}), fmt.Sprint("change ", i)); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+	record, err := ts.Update(ctx, setAll(semantic.Thresholds{High: 0.90, Low: 0.50, GrayZoneVerifier: true}), "last")
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got := engine.Thresholds(); got != (semantic.Thresholds{High: 0.90, Low: 0.50, GrayZoneVerifier: true}) {
+		t.Errorf("Expected the engine to use the update, got %+v", got)
+	}
+	if len(record.History) != MaxThresholdHistory {
+		t.Fatalf("Expected %d changes kept, got %d", MaxThresholdHistory, len(record.History))
+	}
+	last := record.History[len(record.History)-1]
+	if last.Reason != "last" || last.Previous.Low != 0.40 || last.Current.High != 0.90 {
+		t.Errorf("Unexpected last change: %+v", last)
+	}
+
+	// A restart keeps the persisted thresholds over the startup ones
+	restarted := newTestEngine()
+	if loaded, err := NewThresholdStore(store, restarted).Load(ctx); !loaded || err != nil {
+		t.Fatalf("Expected the thresholds to load, got (%v, %v)", loaded, err)
+	}
+	if restarted.Thresholds() != engine.Thresholds() {
+		t.Errorf("Expected %+v after a restart, got %+v", engine.Thresholds(), restarted.Thresholds())
+	}
+}
+
+func TestThresholdStore_ConcurrentPatches(t *testing.T) {
+	store := NewMockStorage()
+	engine := newTestEngine()
+	ts := NewThresholdStore(store, engine)
+	ctx := context.Background()
+
+	// Each update sets one field; none may undo the other
+	for i := 0; i < 20; i++ {
+		high, low := 0.90+float32(i%5)/100, 0.40+float32(i%5)/100
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := ts.Update(ctx, ThresholdPatch{High: &high}, "high"); err != nil {
+				t.Errorf("Update failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := ts.Update(ctx, ThresholdPatch{Low: &low}, "low"); err != nil {
+				t.Errorf("Update failed: %v", err)
+			}
+		}()
+		wg.Wait()
+
+		if got := engine.Thresholds(); got.High != high || got.Low != low {
+			t.Fatalf("Expected both updates to be kept, got %+v", got)
+		}
+	}
+
+	record, _ := ts.Record(ctx)
+	if record.Thresholds != engine.Thresholds() || len(record.History) != 40 {
+		t.Errorf("Expected the record to hold every change, got %+v with %d changes", record.Thresholds, len(record.History))
+	}
+}
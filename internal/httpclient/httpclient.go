@@ -0,0 +1,180 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config holds the settings of the outbound HTTP client shared by the
+// providers and the upstream call
+type Config struct {
+	ConnectTimeout        time.Duration // Dial and TLS handshake timeout
+	ResponseHeaderTimeout time.Duration // Wait for the response headers once the request is sent; 0 means no limit
+	Timeout               time.Duration // Total timeout of one provider request, including reading the body
+	UpstreamTimeout       time.Duration // Deadline of the upstream chat completion call; 0 means no limit
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int    // 0 means unlimited
+	CABundle              string // PEM file of extra CAs trusted besides the system pool
+	ClientCert            string // PEM certificate presented to servers requiring mTLS
+	ClientKey             string
+}
+
+// LoadConfig loads the HTTP client configuration from environment variables.
+// Proxies are configured with the standard HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY variables.
+func LoadConfig() *Config {
+	config := &Config{
+		ConnectTimeout:      5 * time.Second,
+		Timeout:             120 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+	}
+
+	loadDuration("HTTP_CONNECT_TIMEOUT", &config.ConnectTimeout)
+	loadDuration("HTTP_RESPONSE_HEADER_TIMEOUT", &config.ResponseHeaderTimeout)
+	loadDuration("HTTP_TIMEOUT", &config.Timeout)
+	loadDuration("HTTP_UPSTREAM_TIMEOUT", &config.UpstreamTimeout)
+	loadDuration("HTTP_IDLE_CONN_TIMEOUT", &config.IdleConnTimeout)
+	loadInt("HTTP_MAX_IDLE_CONNS", &config.MaxIdleConns)
+	loadInt("HTTP_MAX_IDLE_CONNS_PER_HOST", &config.MaxIdleConnsPerHost)
+	loadInt("HTTP_MAX_CONNS_PER_HOST", &config.MaxConnsPerHost)
+
+	config.CABundle = os.Getenv("HTTP_CA_BUNDLE")
+	config.ClientCert = os.Getenv("HTTP_CLIENT_CERT")
+	config.ClientKey = os.Getenv("HTTP_CLIENT_KEY")
+
+	return config
+}
+
+func loadDuration(env string, dst *time.Duration) {
+	if val := os.Getenv(env); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			*dst = d
+		}
+	}
+}
+
+func loadInt(env string, dst *int) {
+	if val := os.Getenv(env); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			*dst = n
+		}
+	}
+}
+
+// New creates the HTTP client of the providers from config. Every request is
+// bounded by config.Timeout; see WithoutTimeout for the upstream call.
+func New(config *Config) (*http.Client, error) {
+	tlsConfig, err := tlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ForceAttemptHTTP2:     true,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+	}, nil
+}
+
+// WithoutTimeout returns a client sharing the transport and connection pool
+// of client but without its total timeout, for calls bounded by their context
+// instead: the upstream call, whose response may stream for longer than
+// HTTP_TIMEOUT
+func WithoutTimeout(client *http.Client) *http.Client {
+	upstream := *client
+	upstream.Timeout = 0
+	return &upstream
+}
+
+// tlsConfig adds the extra CA bundle and client certificate, if any
+func tlsConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.CABundle != "" {
+		pem, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCert != "" || config.ClientKey != "" {
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return nil, fmt.Errorf("HTTP_CLIENT_CERT and HTTP_CLIENT_KEY must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+var (
+	mu sync.RWMutex
+	// Until SetDefault is called, calls are only bounded by their context
+	shared = &http.Client{}
+)
+
+// Default returns the shared client. Providers created afterwards use it for
+// every call.
+func Default() *http.Client {
+	mu.RLock()
+	defer mu.RUnlock()
+	return shared
+}
+
+// SetDefault replaces the shared client. It must be called before providers
+// are created.
+func SetDefault(client *http.Client) {
+	mu.Lock()
+	shared = client
+	mu.Unlock()
+}
+
+// WithTimeout returns ctx with a deadline of timeout, or ctx unchanged when
+// timeout is zero
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,171 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("HTTP_CONNECT_TIMEOUT", "2s")
+	t.Setenv("HTTP_TIMEOUT", "45s")
+	t.Setenv("HTTP_UPSTREAM_TIMEOUT", "invalid")
+	t.Setenv("HTTP_MAX_IDLE_CONNS_PER_HOST", "32")
+	t.Setenv("HTTP_MAX_CONNS_PER_HOST", "-1")
+	t.Setenv("HTTP_CA_BUNDLE", "/etc/ssl/internal-ca.pem")
+
+	config := LoadConfig()
+
+	if config.ConnectTimeout != 2*time.Second {
+		t.Errorf("Expected connect timeout 2s, got %s", config.ConnectTimeout)
+	}
+	if config.Timeout != 45*time.Second {
+		t.Errorf("Expected timeout 45s, got %s", config.Timeout)
+	}
+	if config.UpstreamTimeout != 0 {
+		t.Errorf("Expected invalid upstream timeout to be ignored, got %s", config.UpstreamTimeout)
+	}
+	if config.MaxIdleConnsPerHost != 32 {
+		t.Errorf("Expected 32 idle conns per host, got %d", config.MaxIdleConnsPerHost)
+	}
+	if config.MaxConnsPerHost != 0 {
+		t.Errorf("Expected negative max conns to be ignored, got %d", config.MaxConnsPerHost)
+	}
+	if config.CABundle != "/etc/ssl/internal-ca.pem" {
+		t.Errorf("Expected CA bundle path, got '%s'", config.CABundle)
+	}
+}
+
+func TestNew_CABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Without the server's CA the handshake fails
+	client, err := New(LoadConfig())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("Expected untrusted certificate to be rejected")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := LoadConfig()
+	config.CABundle = caFile
+	client, err = New(config)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected CA bundle to be trusted, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNew_Errors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "missing CA bundle", config: Config{CABundle: "/nonexistent/ca.pem"}},
+		{name: "CA bundle without certificates", config: Config{CABundle: empty}},
+		{name: "cert without key", config: Config{ClientCert: "/nonexistent/cert.pem"}},
+		{name: "missing key pair", config: Config{ClientCert: "/nonexistent/cert.pem", ClientKey: "/nonexistent/key.pem"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(&tt.config); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestNew_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := LoadConfig()
+	config.Timeout = 50 * time.Millisecond
+	client, err := New(config)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("Expected the total timeout to abort a hung request")
+	}
+}
+
+func TestWithoutTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A streamed response that outlasts the total timeout
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	config := LoadConfig()
+	config.Timeout = 50 * time.Millisecond
+	client, err := New(config)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	upstream := WithoutTimeout(client)
+	if upstream.Transport != client.Transport {
+		t.Error("Expected the connection pool to be shared")
+	}
+	resp, err := upstream.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "done" {
+		t.Errorf("Expected the whole response, got %q, %v", body, err)
+	}
+	if client.Timeout != 50*time.Millisecond {
+		t.Errorf("Expected the provider client to keep its timeout, got %s", client.Timeout)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := WithTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline for a zero timeout")
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("Expected a deadline")
+	}
+}
@@ -0,0 +1,303 @@
+package savings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// keyPrefix prefixes the stored savings record of each day
+const keyPrefix = "stats:savings:"
+
+// dayFormat is the layout of the days records are grouped by (UTC)
+const dayFormat = "2006-01-02"
+
+// Store is the part of the storage the tracker persists its records in
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Update(ctx context.Context, key string, fn storage.UpdateFunc) error
+}
+
+// Price is what a model costs in USD per 1,000 tokens
+type Price struct {
+	PromptPer1K     float64 `json:"prompt_per_1k"`
+	CompletionPer1K float64 `json:"completion_per_1k"`
+}
+
+// PriceTable maps model names to prices. A model is priced by the longest
+// entry it starts with, so "gpt-4o-mini" also prices "gpt-4o-mini-2024-07-18".
+type PriceTable map[string]Price
+
+// DefaultPrices holds list prices of common upstream models
+var DefaultPrices = PriceTable{
+	"gpt-4o":        {PromptPer1K: 0.0025, CompletionPer1K: 0.01},
+	"gpt-4o-mini":   {PromptPer1K: 0.00015, CompletionPer1K: 0.0006},
+	"gpt-4.1":       {PromptPer1K: 0.002, CompletionPer1K: 0.008},
+	"gpt-4.1-mini":  {PromptPer1K: 0.0004, CompletionPer1K: 0.0016},
+	"gpt-4-turbo":   {PromptPer1K: 0.01, CompletionPer1K: 0.03},
+	"gpt-3.5-turbo": {PromptPer1K: 0.0005, CompletionPer1K: 0.0015},
+}
+
+// LoadPrices returns the default prices merged with the MODEL_PRICES JSON
+// environment variable
+func LoadPrices() (PriceTable, error) {
+	prices := make(PriceTable, len(DefaultPrices))
+	for model, price := range DefaultPrices {
+		prices[model] = price
+	}
+
+	if val := os.Getenv("MODEL_PRICES"); val != "" {
+		var custom PriceTable
+		if err := json.Unmarshal([]byte(val), &custom); err != nil {
+			return nil, fmt.Errorf("invalid MODEL_PRICES: %w", err)
+		}
+		for model, price := range custom {
+			prices[strings.ToLower(model)] = price
+		}
+	}
+
+	return prices, nil
+}
+
+// Lookup returns the price of model and whether it is known
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	model = strings.ToLower(model)
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+
+	best := ""
+	for name := range t {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return t[best], true
+}
+
+// Cost returns what usage costs with model, or 0 if the model has no price
+func (t PriceTable) Cost(model string, usage Usage) float64 {
+	price, ok := t.Lookup(model)
+	if !ok {
+		return 0
+	}
+	return float64(usage.PromptTokens)/1000*price.PromptPer1K +
+		float64(usage.CompletionTokens)/1000*price.CompletionPer1K
+}
+
+// Usage is the usage block of an upstream chat completion response
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// ParseResponse extracts the model and usage of a chat completion response
+func ParseResponse(response []byte) (string, Usage, error) {
+	var body struct {
+		Model string `json:"model"`
+		Usage Usage  `json:"usage"`
+	}
+	if err := json.Unmarshal(response, &body); err != nil {
+		return "", Usage{}, err
+	}
+	return body.Model, body.Usage, nil
+}
+
+// Record aggregates the savings of one model and namespace on one day
+type Record struct {
+	Day              string  `json:"day"`
+	Model            string  `json:"model"`
+	Namespace        string  `json:"namespace"`
+	Lookups          int64   `json:"lookups"`
+	Hits             int64   `json:"hits"`
+	PromptTokens     int64   `json:"prompt_tokens"`     // Upstream prompt tokens avoided
+	CompletionTokens int64   `json:"completion_tokens"` // Upstream completion tokens avoided
+	GrossSavings     float64 `json:"gross_savings"`     // USD of avoided upstream calls
+	Overhead         float64 `json:"overhead"`          // USD spent on embedding and verification
+	NetSavings       float64 `json:"net_savings"`
+}
+
+func (r *Record) add(other Record) {
+	r.Lookups += other.Lookups
+	r.Hits += other.Hits
+	r.PromptTokens += other.PromptTokens
+	r.CompletionTokens += other.CompletionTokens
+	r.GrossSavings += other.GrossSavings
+	r.Overhead += other.Overhead
+	r.NetSavings = r.GrossSavings - r.Overhead
+}
+
+// Event is one request served or forwarded by the proxy
+type Event struct {
+	Model     string  // Requested model, used when the response does not name one
+	Namespace string  // Embedding namespace the lookup ran in
+	Hit       bool    // A cached response was served
+	Response  []byte  // The cached response served on a hit
+	Overhead  float64 // USD spent on provider calls for this request
+}
+
+type recordKey struct {
+	model     string
+	namespace string
+}
+
+// Tracker accumulates savings per model, namespace and day and persists every
+// day as one record in the store. Each event is added to the stored record
+// atomically, so replicas sharing a store all count.
+type Tracker struct {
+	store  Store
+	prices PriceTable
+	now    func() time.Time
+}
+
+// NewTracker creates a tracker persisting to store
+func NewTracker(store Store, prices PriceTable) *Tracker {
+	if prices == nil {
+		prices = DefaultPrices
+	}
+	return &Tracker{
+		store:  store,
+		prices: prices,
+		now:    time.Now,
+	}
+}
+
+// Record adds event to today's totals and persists them
+func (t *Tracker) Record(ctx context.Context, event Event) error {
+	rec := Record{Model: event.Model, Namespace: event.Namespace, Lookups: 1, Overhead: event.Overhead}
+
+	if event.Hit {
+		model, usage, err := ParseResponse(event.Response)
+		if err != nil {
+			return fmt.Errorf("failed to parse cached response: %w", err)
+		}
+		if rec.Model == "" {
+			rec.Model = model
+		}
+		rec.Hits = 1
+		rec.PromptTokens = usage.PromptTokens
+		rec.CompletionTokens = usage.CompletionTokens
+
+		// Price by the model that actually answered, e.g. a dated snapshot
+		if model == "" {
+			model = rec.Model
+		}
+		rec.GrossSavings = t.prices.Cost(model, usage)
+	}
+	if rec.Model == "" {
+		rec.Model = "unknown"
+	}
+
+	day := t.now().UTC().Format(dayFormat)
+	return t.store.Update(ctx, keyPrefix+day, func(data []byte) ([]byte, error) {
+		records, err := parseDay(day, data)
+		if err != nil {
+			return nil, err
+		}
+
+		added := false
+		for i := range records {
+			if records[i].Model == rec.Model && records[i].Namespace == rec.Namespace {
+				records[i].add(rec)
+				added = true
+				break
+			}
+		}
+		if !added {
+			total := Record{Day: day, Model: rec.Model, Namespace: rec.Namespace}
+			total.add(rec)
+			records = append(records, total)
+		}
+		sortRecords(records)
+		return json.Marshal(records)
+	})
+}
+
+func (t *Tracker) read(ctx context.Context, day string) ([]Record, error) {
+	data, err := t.store.Get(ctx, keyPrefix+day)
+	if err != nil {
+		return nil, err
+	}
+	return parseDay(day, data)
+}
+
+// parseDay parses the stored records of day; nil data holds no records
+func parseDay(day string, data []byte) ([]Record, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("corrupt savings record for %s: %w", day, err)
+	}
+	return records, nil
+}
+
+// Report is the savings over a range of days
+type Report struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Totals  Record   `json:"totals"`
+	Models  []Record `json:"models"` // Per model and namespace over the whole range
+	Records []Record `json:"records"`
+}
+
+// Report returns the savings recorded from the day of from to the day of to,
+// both inclusive
+func (t *Tracker) Report(ctx context.Context, from, to time.Time) (*Report, error) {
+	// Whole days are read, whatever the time of day of the bounds
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	report := &Report{
+		From:    from.Format(dayFormat),
+		To:      to.Format(dayFormat),
+		Models:  []Record{},
+		Records: []Record{},
+	}
+
+	models := make(map[recordKey]*Record)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		records, err := t.read(ctx, day.Format(dayFormat))
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			report.Records = append(report.Records, rec)
+			report.Totals.add(rec)
+
+			key := recordKey{model: rec.Model, namespace: rec.Namespace}
+			total, ok := models[key]
+			if !ok {
+				total = &Record{Model: rec.Model, Namespace: rec.Namespace}
+				models[key] = total
+			}
+			total.add(rec)
+		}
+	}
+
+	for _, total := range models {
+		report.Models = append(report.Models, *total)
+	}
+	sortRecords(report.Models)
+	return report, nil
+}
+
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Day != records[j].Day {
+			return records[i].Day < records[j].Day
+		}
+		if records[i].Model != records[j].Model {
+			return records[i].Model < records[j].Model
+		}
+		return records[i].Namespace < records[j].Namespace
+	})
+}
@@ -0,0 +1,200 @@
+package savings
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// MemoryStore is a Store that keeps what is written to it
+type MemoryStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.data[key], nil
+}
+
+func (m *MemoryStore) Update(ctx context.Context, key string, fn storage.UpdateFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, err := fn(m.data[key])
+	if err != nil {
+		return err
+	}
+	m.data[key] = value
+	return nil
+}
+
+const cachedResponse = `{"model": "gpt-4o-mini-2024-07-18", "usage": {"prompt_tokens": 1000, "completion_tokens": 2000}}`
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPriceTable_Lookup(t *testing.T) {
+	tests := []struct {
+		model string
+		price Price
+		found bool
+	}{
+		{model: "gpt-4o", price: DefaultPrices["gpt-4o"], found: true},
+		{model: "gpt-4o-mini-2024-07-18", price: DefaultPrices["gpt-4o-mini"], found: true},
+		{model: "GPT-4o-2024-08-06", price: DefaultPrices["gpt-4o"], found: true},
+		{model: "llama-3", found: false},
+	}
+
+	for _, tt := range tests {
+		price, found := DefaultPrices.Lookup(tt.model)
+		if found != tt.found || price != tt.price {
+			t.Errorf("Lookup(%q) = (%+v, %v), want (%+v, %v)", tt.model, price, found, tt.price, tt.found)
+		}
+	}
+}
+
+func TestLoadPrices(t *testing.T) {
+	t.Setenv("MODEL_PRICES", `{"Internal-Model": {"prompt_per_1k": 0.001, "completion_per_1k": 0.002}}`)
+
+	prices, err := LoadPrices()
+	if err != nil {
+		t.Fatalf("LoadPrices failed: %v", err)
+	}
+	if _, ok := prices.Lookup("internal-model"); !ok {
+		t.Error("Expected custom model to be priced")
+	}
+	if _, ok := prices.Lookup("gpt-4o"); !ok {
+		t.Error("Expected defaults to be kept")
+	}
+
+	t.Setenv("MODEL_PRICES", "{")
+	if _, err := LoadPrices(); err == nil {
+		t.Error("Expected invalid MODEL_PRICES to fail")
+	}
+}
+
+func TestTracker_Record(t *testing.T) {
+	store := &MemoryStore{data: map[string][]byte{}}
+	tracker := NewTracker(store, DefaultPrices)
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	events := []Event{
+		{Model: "gpt-4o-mini", Namespace: "openai", Hit: true, Response: []byte(cachedResponse), Overhead: 0.0001},
+		{Model: "gpt-4o-mini", Namespace: "openai", Overhead: 0.0002},
+		{Model: "gpt-4o", Namespace: "mistral", Overhead: 0.0003},
+	}
+	for _, event := range events {
+		if err := tracker.Record(ctx, event); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	if _, ok := store.data["stats:savings:2026-03-01"]; !ok {
+		t.Fatal("Expected the day to be persisted")
+	}
+
+	// A new tracker reads the persisted totals back
+	tracker = NewTracker(store, DefaultPrices)
+	report, err := tracker.Report(ctx, now.AddDate(0, 0, -1), now)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+
+	if len(report.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v", report.Records)
+	}
+	mini := report.Records[1]
+	if mini.Model != "gpt-4o-mini" || mini.Lookups != 2 || mini.Hits != 1 {
+		t.Errorf("Unexpected record: %+v", mini)
+	}
+	if mini.PromptTokens != 1000 || mini.CompletionTokens != 2000 {
+		t.Errorf("Expected avoided tokens from the cached usage, got %+v", mini)
+	}
+
+	gross := 0.00015 + 2*0.0006
+	if !almostEqual(mini.GrossSavings, gross) || !almostEqual(mini.NetSavings, gross-0.0003) {
+		t.Errorf("Expected gross %f and net %f, got %+v", gross, gross-0.0003, mini)
+	}
+	if !almostEqual(report.Totals.Overhead, 0.0006) || report.Totals.Lookups != 3 {
+		t.Errorf("Unexpected totals: %+v", report.Totals)
+	}
+	if len(report.Models) != 2 || report.From != "2026-02-28" || report.To != "2026-03-01" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestTracker_RecordInvalidResponse(t *testing.T) {
+	tracker := NewTracker(&MemoryStore{data: map[string][]byte{}}, DefaultPrices)
+	if err := tracker.Record(context.Background(), Event{Hit: true, Response: []byte("not json")}); err == nil {
+		t.Error("Expected an unparseable cached response to fail")
+	}
+}
+
+func TestTracker_NewDay(t *testing.T) {
+	store := &MemoryStore{data: map[string][]byte{}}
+	tracker := NewTracker(store, DefaultPrices)
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	tracker.Record(ctx, Event{Model: "gpt-4o", Namespace: "openai"})
+	now = now.Add(2 * time.Minute)
+	tracker.Record(ctx, Event{Model: "gpt-4o", Namespace: "openai"})
+
+	report, err := tracker.Report(ctx, now.AddDate(0, 0, -1), now)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(report.Records) != 2 || report.Records[0].Day != "2026-03-01" || report.Records[1].Day != "2026-03-02" {
+		t.Errorf("Expected one record per day, got %+v", report.Records)
+	}
+	if report.Models[0].Lookups != 2 {
+		t.Errorf("Expected model totals across days, got %+v", report.Models)
+	}
+
+	// Bounds cover their whole day, whatever their time
+	report, err = tracker.Report(ctx, now.Add(-90*time.Second), now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(report.Records) != 2 {
+		t.Errorf("Expected both days to be read, got %+v", report.Records)
+	}
+}
+
+func TestTracker_SharedStore(t *testing.T) {
+	store := &MemoryStore{data: map[string][]byte{}}
+	ctx := context.Background()
+
+	// Replicas sharing a store each add to the stored totals
+	var wg sync.WaitGroup
+	for replica := 0; replica < 4; replica++ {
+		tracker := NewTracker(store, DefaultPrices)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if err := tracker.Record(ctx, Event{Model: "gpt-4o", Namespace: "openai", Hit: i%5 == 0, Response: []byte(cachedResponse)}); err != nil {
+					t.Errorf("Record failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	report, err := NewTracker(store, DefaultPrices).Report(ctx, time.Now(), time.Now())
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if report.Totals.Lookups != 100 || report.Totals.Hits != 20 {
+		t.Errorf("Expected 100 lookups and 20 hits, got %+v", report.Totals)
+	}
+}
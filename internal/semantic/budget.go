@@ -0,0 +1,304 @@
+package semantic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CallType distinguishes the provider calls the semantic engine makes
+type CallType string
+
+const (
+	CallEmbed  CallType = "embed"
+	CallVerify CallType = "verify"
+)
+
+// ExhaustedPolicy decides how a lookup degrades when a call it needs is
+// refused by a budget. It applies per request: lookups that need no refused
+// call, such as high-zone hits while only the verify budget is exhausted,
+// are served as usual, and an exhausted embed budget first fails over to the
+// next provider of the chain.
+type ExhaustedPolicy string
+
+const (
+	// PolicySkipVerification treats gray zone matches as misses when the
+	// verify budget is exhausted, and falls back to exact matching when the
+	// embed budget of every provider is exhausted
+	PolicySkipVerification ExhaustedPolicy = "skip_verification"
+
+	// PolicyExactMatch serves only an exact prompt match to a lookup whose
+	// embedding or verification was refused
+	PolicyExactMatch ExhaustedPolicy = "exact_match"
+
+	// PolicyPassThrough bypasses the cache for a lookup whose embedding or
+	// verification was refused
+	PolicyPassThrough ExhaustedPolicy = "pass_through"
+)
+
+// wildcardProvider holds limits applied to providers without their own entry
+const wildcardProvider = "*"
+
+// ErrBudgetExhausted is matched by every BudgetError
+var ErrBudgetExhausted = errors.New("provider budget exhausted")
+
+// BudgetError reports a provider call refused by a rate limit or budget
+type BudgetError struct {
+	Provider string
+	Call     CallType
+	Reason   string
+	Policy   ExhaustedPolicy
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s %s budget exhausted: %s", e.Provider, e.Call, e.Reason)
+}
+
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExhausted
+}
+
+// Limit caps one call type of one provider. Zero values mean unlimited.
+type Limit struct {
+	RatePerSecond    float64 `json:"rate_per_second,omitempty"`
+	Burst            int     `json:"burst,omitempty"`
+	DailyTokens      int64   `json:"daily_tokens,omitempty"`
+	DailySpend       float64 `json:"daily_spend,omitempty"`         // USD
+	PricePer1KTokens float64 `json:"price_per_1k_tokens,omitempty"` // USD, used to compute spend
+}
+
+// BudgetConfig holds the limits per provider and call type. The "*"
+// provider applies to providers without their own entry.
+type BudgetConfig struct {
+	Limits map[string]map[CallType]Limit
+	Policy ExhaustedPolicy
+}
+
+// LoadBudgetConfig loads limits from the PROVIDER_BUDGETS JSON environment
+// variable and the exhaustion policy from BUDGET_EXHAUSTED_POLICY
+func LoadBudgetConfig() (*BudgetConfig, error) {
+	config := &BudgetConfig{
+		Limits: make(map[string]map[CallType]Limit),
+		Policy: PolicySkipVerification,
+	}
+
+	if val := os.Getenv("PROVIDER_BUDGETS"); val != "" {
+		if err := json.Unmarshal([]byte(val), &config.Limits); err != nil {
+			return nil, fmt.Errorf("invalid PROVIDER_BUDGETS: %w", err)
+		}
+		// Provider names are case-insensitive everywhere else
+		normalized := make(map[string]map[CallType]Limit, len(config.Limits))
+		for provider, limits := range config.Limits {
+			for call := range limits {
+				if call != CallEmbed && call != CallVerify {
+					return nil, fmt.Errorf("invalid PROVIDER_BUDGETS: unknown call type %q", call)
+				}
+			}
+			normalized[strings.ToLower(provider)] = limits
+		}
+		config.Limits = normalized
+	}
+
+	if val := os.Getenv("BUDGET_EXHAUSTED_POLICY"); val != "" {
+		switch policy := ExhaustedPolicy(strings.ToLower(val)); policy {
+		case PolicySkipVerification, PolicyExactMatch, PolicyPassThrough:
+			config.Policy = policy
+		default:
+			return nil, fmt.Errorf("invalid BUDGET_EXHAUSTED_POLICY: %s (supported: skip_verification, exact_match, pass_through)", val)
+		}
+	}
+
+	return config, nil
+}
+
+// EstimateTokens approximates the token count of text at four characters per token
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+type budgetKey struct {
+	provider string
+	call     CallType
+}
+
+// tokenBucket is a classic token bucket refilled continuously at rate per second
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type dailyUsage struct {
+	day      string
+	requests int64
+	refused  int64
+	tokens   int64
+	spend    float64
+}
+
+// BudgetUsage reports today's usage of one provider call type
+type BudgetUsage struct {
+	Provider  string   `json:"provider"`
+	Call      CallType `json:"call"`
+	Day       string   `json:"day"`
+	Requests  int64    `json:"requests"`
+	Refused   int64    `json:"refused"`
+	Tokens    int64    `json:"tokens"`
+	Spend     float64  `json:"spend"`
+	Limit     Limit    `json:"limit"`
+	Exhausted bool     `json:"exhausted"`
+}
+
+// Budgets enforces rate limits and daily budgets on provider calls and
+// records what every call cost
+type Budgets struct {
+	mu      sync.Mutex
+	config  *BudgetConfig
+	buckets map[budgetKey]*tokenBucket
+	usage   map[budgetKey]*dailyUsage
+	now     func() time.Time
+}
+
+// NewBudgets creates a Budgets enforcing config
+func NewBudgets(config *BudgetConfig) *Budgets {
+	if config == nil {
+		config = &BudgetConfig{Policy: PolicySkipVerification}
+	}
+	if config.Policy == "" {
+		config.Policy = PolicySkipVerification
+	}
+	return &Budgets{
+		config:  config,
+		buckets: make(map[budgetKey]*tokenBucket),
+		usage:   make(map[budgetKey]*dailyUsage),
+		now:     time.Now,
+	}
+}
+
+// Policy returns the configured exhaustion policy
+func (b *Budgets) Policy() ExhaustedPolicy {
+	return b.config.Policy
+}
+
+func (b *Budgets) limit(provider string, call CallType) Limit {
+	if limits, ok := b.config.Limits[provider]; ok {
+		if limit, ok := limits[call]; ok {
+			return limit
+		}
+	}
+	return b.config.Limits[wildcardProvider][call]
+}
+
+// today returns the usage record of key, reset when the UTC day changes
+func (b *Budgets) today(key budgetKey, now time.Time) *dailyUsage {
+	day := now.UTC().Format("2006-01-02")
+	usage, ok := b.usage[key]
+	if !ok || usage.day != day {
+		usage = &dailyUsage{day: day}
+		b.usage[key] = usage
+	}
+	return usage
+}
+
+// Reserve admits a call of about tokens tokens, or returns a *BudgetError if
+// the rate limit or a daily budget would be exceeded. Admitted calls are
+// charged immediately.
+func (b *Budgets) Reserve(provider string, call CallType, tokens int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	key := budgetKey{provider: provider, call: call}
+	limit := b.limit(provider, call)
+	usage := b.today(key, now)
+	cost := float64(tokens) / 1000 * limit.PricePer1KTokens
+
+	refuse := func(reason string) error {
+		usage.refused++
+		return &BudgetError{Provider: provider, Call: call, Reason: reason, Policy: b.config.Policy}
+	}
+
+	if limit.DailyTokens > 0 && usage.tokens+int64(tokens) > limit.DailyTokens {
+		return refuse("daily token budget reached")
+	}
+	if limit.DailySpend > 0 && usage.spend+cost > limit.DailySpend {
+		return refuse("daily spend budget reached")
+	}
+
+	if limit.RatePerSecond > 0 {
+		bucket, ok := b.buckets[key]
+		if !ok {
+			capacity := float64(limit.Burst)
+			if capacity < 1 {
+				capacity = math.Max(1, math.Ceil(limit.RatePerSecond))
+			}
+			bucket = &tokenBucket{rate: limit.RatePerSecond, capacity: capacity, tokens: capacity, last: now}
+			b.buckets[key] = bucket
+		}
+		if !bucket.allow(now) {
+			return refuse("rate limit exceeded")
+		}
+	}
+
+	usage.requests++
+	usage.tokens += int64(tokens)
+	usage.spend += cost
+	return nil
+}
+
+// Cost returns what a call of tokens tokens costs at the configured price
+func (b *Budgets) Cost(provider string, call CallType, tokens int) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return float64(tokens) / 1000 * b.limit(provider, call).PricePer1KTokens
+}
+
+// Usage reports today's usage of every provider call type seen so far
+func (b *Budgets) Usage() []BudgetUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	report := make([]BudgetUsage, 0, len(b.usage))
+	for key := range b.usage {
+		usage := b.today(key, now)
+		limit := b.limit(key.provider, key.call)
+		report = append(report, BudgetUsage{
+			Provider: key.provider,
+			Call:     key.call,
+			Day:      usage.day,
+			Requests: usage.requests,
+			Refused:  usage.refused,
+			Tokens:   usage.tokens,
+			Spend:    usage.spend,
+			Limit:    limit,
+			Exhausted: (limit.DailyTokens > 0 && usage.tokens >= limit.DailyTokens) ||
+				(limit.DailySpend > 0 && usage.spend >= limit.DailySpend),
+		})
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Provider != report[j].Provider {
+			return report[i].Provider < report[j].Provider
+		}
+		return report[i].Call < report[j].Call
+	})
+	return report
+}
@@ -0,0 +1,232 @@
+package semantic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadBudgetConfig(t *testing.T) {
+	t.Setenv("PROVIDER_BUDGETS", `{"OpenAI": {"embed": {"rate_per_second": 5, "burst": 10}}, "*": {"verify": {"daily_tokens": 1000}}}`)
+	t.Setenv("BUDGET_EXHAUSTED_POLICY", "exact_match")
+
+	config, err := LoadBudgetConfig()
+	if err != nil {
+		t.Fatalf("LoadBudgetConfig failed: %v", err)
+	}
+	if config.Policy != PolicyExactMatch {
+		t.Errorf("Expected policy exact_match, got %s", config.Policy)
+	}
+	if config.Limits["openai"][CallEmbed].Burst != 10 {
+		t.Errorf("Expected openai embed burst 10, got %+v", config.Limits["openai"])
+	}
+	if config.Limits["*"][CallVerify].DailyTokens != 1000 {
+		t.Errorf("Expected wildcard verify budget 1000, got %+v", config.Limits["*"])
+	}
+}
+
+func TestLoadBudgetConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		budgets string
+		policy  string
+	}{
+		{name: "bad json", budgets: "{"},
+		{name: "unknown call", budgets: `{"openai": {"chat": {}}}`},
+		{name: "unknown policy", policy: "drop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PROVIDER_BUDGETS", tt.budgets)
+			t.Setenv("BUDGET_EXHAUSTED_POLICY", tt.policy)
+			if _, err := LoadBudgetConfig(); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestBudgets_RateLimit(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	budgets := NewBudgets(&BudgetConfig{Limits: map[string]map[CallType]Limit{
+		"openai": {CallEmbed: {RatePerSecond: 1, Burst: 2}},
+	}})
+	budgets.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := budgets.Reserve("openai", CallEmbed, 10); err != nil {
+			t.Fatalf("Expected call %d within burst, got %v", i, err)
+		}
+	}
+	err := budgets.Reserve("openai", CallEmbed, 10)
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("Expected rate limit error, got %v", err)
+	}
+
+	// Other providers and call types are not limited
+	if err := budgets.Reserve("mistral", CallEmbed, 10); err != nil {
+		t.Errorf("Expected unlimited provider to pass, got %v", err)
+	}
+	if err := budgets.Reserve("openai", CallVerify, 10); err != nil {
+		t.Errorf("Expected unlimited call type to pass, got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if err := budgets.Reserve("openai", CallEmbed, 10); err != nil {
+		t.Errorf("Expected bucket to refill, got %v", err)
+	}
+}
+
+func TestBudgets_Daily(t *testing.T) {
+	now := time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)
+	budgets := NewBudgets(&BudgetConfig{Limits: map[string]map[CallType]Limit{
+		"*":      {CallEmbed: {DailyTokens: 100}},
+		"claude": {CallVerify: {DailySpend: 0.01, PricePer1KTokens: 1}},
+	}})
+	budgets.now = func() time.Time { return now }
+
+	if err := budgets.Reserve("openai", CallEmbed, 80); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if err := budgets.Reserve("openai", CallEmbed, 30); err == nil {
+		t.Error("Expected daily token budget to be reached")
+	}
+	if err := budgets.Reserve("claude", CallVerify, 8); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if err := budgets.Reserve("claude", CallVerify, 8); err == nil {
+		t.Error("Expected daily spend budget to be reached")
+	}
+
+	usage := budgets.Usage()
+	if len(usage) != 2 || usage[0].Provider != "claude" || usage[1].Provider != "openai" {
+		t.Fatalf("Unexpected usage: %+v", usage)
+	}
+	if usage[1].Tokens != 80 || usage[1].Requests != 1 || usage[1].Refused != 1 {
+		t.Errorf("Unexpected openai usage: %+v", usage[1])
+	}
+
+	// Budgets reset at midnight UTC
+	now = now.Add(2 * time.Hour)
+	if err := budgets.Reserve("openai", CallEmbed, 30); err != nil {
+		t.Errorf("Expected budget reset on a new day, got %v", err)
+	}
+}
+
+func TestLookup_BudgetExhausted(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+	t.Setenv("VERIFIER_PROVIDER", "")
+
+	grayVec := Float32ToBytes([]float32{0.85, 0.5, 0.1})
+	noVerify := map[string]map[CallType]Limit{"*": {CallVerify: {DailyTokens: 1}}}
+	noEmbed := map[string]map[CallType]Limit{"*": {CallEmbed: {DailyTokens: 1}}}
+
+	tests := []struct {
+		name    string
+		limits  map[string]map[CallType]Limit
+		policy  ExhaustedPolicy
+		wantErr bool
+		reason  string
+	}{
+		{name: "skip verification", limits: noVerify, policy: PolicySkipVerification, reason: ReasonBudgetExhausted},
+		{name: "exact match", limits: noVerify, policy: PolicyExactMatch, wantErr: true, reason: ReasonBudgetExhausted},
+		{name: "embed exhausted", limits: noEmbed, policy: PolicySkipVerification, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &MockStorage{embeddings: map[string][]byte{"emb:candidate": grayVec}}
+			verifier := &MockVerifier{match: true}
+			config := &Config{HighThreshold: 0.95, LowThreshold: 0.80, EnableGrayZoneVerifier: true}
+			engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, store, verifier, config)
+			engine.SetBudgets(NewBudgets(&BudgetConfig{Limits: tt.limits, Policy: tt.policy}))
+
+			decision, err := engine.Lookup(context.Background(), "query")
+
+			var budgetErr *BudgetError
+			if errors.As(err, &budgetErr) != tt.wantErr {
+				t.Fatalf("Expected budget error=%v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr && budgetErr.Policy != tt.policy {
+				t.Errorf("Expected policy %s on error, got %s", tt.policy, budgetErr.Policy)
+			}
+			if decision != nil {
+				if decision.Hit() {
+					t.Error("Expected a miss without verification")
+				}
+				if decision.Reason != tt.reason {
+					t.Errorf("Expected reason %s, got %s", tt.reason, decision.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify_Budget(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+	t.Setenv("VERIFIER_PROVIDER", "")
+
+	engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, &MockStorage{}, &MockVerifier{match: true}, &Config{HighThreshold: 0.95, LowThreshold: 0.80})
+	if match, err := engine.Verify(context.Background(), "a", "b"); err != nil || !match {
+		t.Fatalf("Expected a match, got %v, %v", match, err)
+	}
+
+	engine.SetBudgets(NewBudgets(&BudgetConfig{Limits: map[string]map[CallType]Limit{"*": {CallVerify: {DailyTokens: 1}}}}))
+	if _, err := engine.Verify(context.Background(), "a", "b"); !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("Expected the verify budget to be enforced, got %v", err)
+	}
+}
+
+func TestFailover_BudgetSkipsProvider(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+
+	primary := &FailingProvider{embedding: []float32{1, 0, 0}}
+	fallback := &FailingProvider{embedding: []float32{0, 1}}
+	engine := NewSemanticEngine(primary, &MemoryStorage{data: map[string][]byte{}}, &MockVerifier{}, &Config{HighThreshold: 0.9, LowThreshold: 0.5})
+	engine.SetFallbacks([]NamedEmbedder{{Name: "mistral", Provider: fallback}})
+	engine.SetBudgets(NewBudgets(&BudgetConfig{Limits: map[string]map[CallType]Limit{
+		"openai": {CallEmbed: {DailyTokens: 1}},
+	}}))
+
+	_, namespace, err := engine.Embed(context.Background(), "a prompt over budget")
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if namespace != "mistral" {
+		t.Errorf("Expected fallback namespace, got '%s'", namespace)
+	}
+	if primary.calls != 0 {
+		t.Error("Expected the primary not to be called over budget")
+	}
+	if health := engine.ProviderHealth(); !health[0].Healthy {
+		t.Error("Expected an exhausted budget not to mark the provider down")
+	}
+}
+
+func TestLookup_Cost(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+	t.Setenv("VERIFIER_PROVIDER", "")
+
+	store := &MockStorage{embeddings: map[string][]byte{"emb:candidate": Float32ToBytes([]float32{0.85, 0.5, 0.1})}}
+	config := &Config{HighThreshold: 0.95, LowThreshold: 0.80, EnableGrayZoneVerifier: true}
+	engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, store, &MockVerifier{match: true}, config)
+	engine.SetBudgets(NewBudgets(&BudgetConfig{Limits: map[string]map[CallType]Limit{
+		"openai": {CallEmbed: {PricePer1KTokens: 1}, CallVerify: {PricePer1KTokens: 10}},
+	}}))
+
+	decision, err := engine.Lookup(context.Background(), "12345678")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+
+	// 2 query tokens embedded, 2 + 4 ("original prompt") + overhead tokens verified
+	want := 2.0/1000*1 + float64(2+4+verifierOverheadTokens)/1000*10
+	if decision.Namespace != "openai" {
+		t.Errorf("Expected namespace 'openai', got '%s'", decision.Namespace)
+	}
+	if decision.Cost < want-1e-9 || decision.Cost > want+1e-9 {
+		t.Errorf("Expected cost %f, got %f", want, decision.Cost)
+	}
+}
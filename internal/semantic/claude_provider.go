@@ -8,17 +8,52 @@ import (
 	"io"
 	"net/http"
 	"os"
+
+	"github.com/messkan/PromptCache/internal/httpclient"
+)
+
+const (
+	// claudeEmbeddingModel is the default Voyage AI model used for embeddings
+	claudeEmbeddingModel = "voyage-3"
+
+	// claudeVerifierModel is the default chat model used for gray zone verification
+	claudeVerifierModel = "claude-3-haiku-20240307"
 )
 
+func init() {
+	Register(ProviderSpec{
+		Name:        "claude",
+		Description: "Voyage AI embeddings and Anthropic verification",
+		Schema: []ConfigField{
+			{Name: "api_key", Description: "Anthropic API key", Env: "ANTHROPIC_API_KEY", Secret: true},
+			{Name: "voyage_api_key", Description: "Voyage AI API key for embeddings", Env: "VOYAGE_API_KEY", Secret: true},
+			{Name: "embedding_model", Description: "Voyage AI embedding model", Default: claudeEmbeddingModel},
+			{Name: "verifier_model", Description: "Chat model used for verification", Default: claudeVerifierModel},
+		},
+		Capabilities: Capabilities{Embed: true, Verify: true, Batch: true, Dimensions: 1024},
+		Factory: func(cfg ProviderConfig) (Provider, error) {
+			p := NewClaudeProvider()
+			p.apiKey = cfg["api_key"]
+			p.voyageAPIKey = cfg["voyage_api_key"]
+			p.embeddingModel = cfg["embedding_model"]
+			p.verifier.Model = cfg["verifier_model"]
+			return p, nil
+		},
+	})
+}
+
 type ClaudeProvider struct {
-	apiKey string
-	client *http.Client
+	apiKey         string
+	voyageAPIKey   string // Falls back to VOYAGE_API_KEY when empty
+	client         *http.Client
+	embeddingModel string
+	verifier       VerifierOptions
 }
 
 func NewClaudeProvider() *ClaudeProvider {
 	return &ClaudeProvider{
 		apiKey: os.Getenv("ANTHROPIC_API_KEY"),
-		client: &http.Client{},
+		client: httpclient.Default(),
 	}
 }
 
@@ -36,15 +71,27 @@ type VoyageEmbeddingResponse struct {
 }
 
 func (p *ClaudeProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	res, err := p.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// EmbedBatch embeds several texts in a single API call
+func (p *ClaudeProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
 	// Using Voyage AI for embeddings (recommended by Anthropic)
-	voyageAPIKey := os.Getenv("VOYAGE_API_KEY")
+	voyageAPIKey := p.voyageAPIKey
+	if voyageAPIKey == "" {
+		voyageAPIKey = os.Getenv("VOYAGE_API_KEY")
+	}
 	if voyageAPIKey == "" {
 		return nil, fmt.Errorf("VOYAGE_API_KEY not set - required for Claude provider embeddings")
 	}
 
 	reqBody := VoyageEmbeddingRequest{
-		Input: []string{text},
-		Model: "voyage-3",
+		Input: texts,
+		Model: p.EmbeddingModel(),
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
@@ -75,14 +122,17 @@ func (p *ClaudeProvider) Embed(ctx context.Context, text string) ([]float32, err
 		return nil, err
 	}
 
-	if len(embeddingResp.Data) == 0 {
+	if len(embeddingResp.Data) != len(texts) {
 		return nil, fmt.Errorf("no embedding data returned")
 	}
 
 	// Convert float64 to float32
-	res := make([]float32, len(embeddingResp.Data[0].Embedding))
-	for i, v := range embeddingResp.Data[0].Embedding {
-		res[i] = float32(v)
+	res := make([][]float32, len(embeddingResp.Data))
+	for i, d := range embeddingResp.Data {
+		res[i] = make([]float32, len(d.Embedding))
+		for j, v := range d.Embedding {
+			res[i][j] = float32(v)
+		}
 	}
 
 	return res, nil
@@ -107,11 +157,12 @@ type ClaudeChatResponse struct {
 }
 
 func (p *ClaudeProvider) CheckSimilarity(ctx context.Context, prompt1, prompt2 string) (bool, error) {
-	systemPrompt := "You are a semantic judge. Determine if the two user prompts have the exact same intent and meaning. Answer only with 'YES' or 'NO'."
-	userPrompt := fmt.Sprintf("Prompt 1: %s\nPrompt 2: %s", prompt1, prompt2)
+	opts := p.VerifierOptions()
+	systemPrompt := opts.SystemPrompt
+	userPrompt := opts.render(prompt1, prompt2)
 
 	reqBody := ClaudeChatRequest{
-		Model:     "claude-3-haiku-20240307",
+		Model:     opts.Model,
 		MaxTokens: 10,
 		System:    systemPrompt,
 		Messages: []ClaudeMessage{
@@ -156,3 +207,24 @@ func (p *ClaudeProvider) CheckSimilarity(ctx context.Context, prompt1, prompt2 s
 	content := chatResp.Content[0].Text
 	return content == "YES", nil
 }
+
+// VerifierOptions returns the effective verification options
+func (p *ClaudeProvider) VerifierOptions() VerifierOptions {
+	return p.verifier.withDefaults(claudeVerifierModel)
+}
+
+// EmbeddingModel returns the model used for embeddings
+func (p *ClaudeProvider) EmbeddingModel() string {
+	if p.embeddingModel == "" {
+		return claudeEmbeddingModel
+	}
+	return p.embeddingModel
+}
+
+// setVerifierOptions applies options on top of the instance's configured model
+func (p *ClaudeProvider) setVerifierOptions(opts VerifierOptions) {
+	if opts.Model == "" {
+		opts.Model = p.verifier.Model
+	}
+	p.verifier = opts
+}
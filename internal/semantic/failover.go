@@ -0,0 +1,387 @@
+package semantic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/httpclient"
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// NamedEmbedder is an embedding provider in the failover chain. Its name is
+// also the namespace its vectors are stored under.
+type NamedEmbedder struct {
+	Name     string
+	Provider EmbeddingProvider
+}
+
+// ProviderHealth reports the failover state of one embedding provider
+type ProviderHealth struct {
+	Name                string     `json:"name"`
+	Primary             bool       `json:"primary"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	DownUntil           *time.Time `json:"down_until,omitempty"`
+}
+
+type healthState struct {
+	failures  int
+	lastError string
+	downUntil time.Time
+}
+
+// healthTracker marks a provider down for a cooldown period once it has
+// failed threshold times in a row
+type healthTracker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	states    map[string]*healthState
+}
+
+func newHealthTracker(threshold int, cooldown time.Duration) *healthTracker {
+	if threshold <= 0 {
+		threshold = 3
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &healthTracker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		states:    make(map[string]*healthState),
+	}
+}
+
+func (h *healthTracker) state(name string) *healthState {
+	st, ok := h.states[name]
+	if !ok {
+		st = &healthState{}
+		h.states[name] = st
+	}
+	return st
+}
+
+func (h *healthTracker) available(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Now().After(h.state(name).downUntil)
+}
+
+func (h *healthTracker) recordSuccess(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.state(name)
+	st.failures = 0
+	st.downUntil = time.Time{}
+}
+
+func (h *healthTracker) recordFailure(name string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.state(name)
+	st.failures++
+	st.lastError = err.Error()
+	if st.failures >= h.threshold {
+		st.downUntil = time.Now().Add(h.cooldown)
+		log.Printf("Embedding provider %s marked down for %s after %d failures: %v", name, h.cooldown, st.failures, err)
+	}
+}
+
+func (h *healthTracker) report(name string) ProviderHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.state(name)
+	health := ProviderHealth{
+		Name:                name,
+		Healthy:             time.Now().After(st.downUntil),
+		ConsecutiveFailures: st.failures,
+		LastError:           st.lastError,
+	}
+	if !health.Healthy {
+		downUntil := st.downUntil
+		health.DownUntil = &downUntil
+	}
+	return health
+}
+
+// SetFallbacks sets the ordered list of providers tried when the primary
+// embedding provider fails or is marked down
+func (se *SemanticEngine) SetFallbacks(fallbacks []NamedEmbedder) {
+	se.mu.Lock()
+	se.fallbacks = fallbacks
+	se.mu.Unlock()
+}
+
+// chain returns the primary provider followed by the fallbacks
+func (se *SemanticEngine) chain() []NamedEmbedder {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+
+	chain := make([]NamedEmbedder, 0, len(se.fallbacks)+1)
+	chain = append(chain, NamedEmbedder{Name: se.currentProviderName, Provider: se.Provider})
+	for _, fb := range se.fallbacks {
+		if fb.Name != se.currentProviderName {
+			chain = append(chain, fb)
+		}
+	}
+	return chain
+}
+
+// Embed embeds text with the first healthy provider of the failover chain and
+// returns the namespace of the provider that produced the vector. Providers
+// whose embed budget is exhausted are skipped; if none is left a *BudgetError
+// is returned.
+func (se *SemanticEngine) Embed(ctx context.Context, text string) ([]float32, string, error) {
+	chain := se.chain()
+	budgets := se.Budgets()
+	tokens := EstimateTokens(text)
+
+	var lastErr, budgetErr error
+	var skipped []NamedEmbedder
+
+	for _, member := range chain {
+		if !se.health.available(member.Name) {
+			skipped = append(skipped, member)
+			continue
+		}
+		if err := budgets.Reserve(member.Name, CallEmbed, tokens); err != nil {
+			budgetErr = err
+			continue
+		}
+		vec, err := se.embedWith(ctx, member.Provider, text)
+		if err != nil {
+			se.health.recordFailure(member.Name, err)
+			lastErr = err
+			continue
+		}
+		se.health.recordSuccess(member.Name)
+		return vec, member.Name, nil
+	}
+
+	// Every healthy provider failed; probe the ones in cooldown rather than
+	// giving up without trying
+	for _, member := range skipped {
+		if err := budgets.Reserve(member.Name, CallEmbed, tokens); err != nil {
+			budgetErr = err
+			continue
+		}
+		vec, err := se.embedWith(ctx, member.Provider, text)
+		if err != nil {
+			se.health.recordFailure(member.Name, err)
+			lastErr = err
+			continue
+		}
+		se.health.recordSuccess(member.Name)
+		return vec, member.Name, nil
+	}
+
+	// Running out of budget degrades the lookup according to the policy,
+	// which is preferable to failing it
+	if budgetErr != nil {
+		return nil, "", budgetErr
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no embedding provider available")
+	}
+	return nil, "", lastErr
+}
+
+// embedWith calls provider within the embedding deadline, so a hung provider
+// fails over instead of blocking the request
+func (se *SemanticEngine) embedWith(ctx context.Context, provider EmbeddingProvider, text string) ([]float32, error) {
+	ctx, cancel := httpclient.WithTimeout(ctx, se.embedTimeout)
+	defer cancel()
+	return provider.Embed(ctx, text)
+}
+
+// StoreEmbedding embeds prompt and stores the vector for hash in the
+// namespace of the provider that produced it. The vector expires after ttl,
+// together with the response it belongs to; 0 never expires. It returns the
+// key the vector was stored under.
+func (se *SemanticEngine) StoreEmbedding(ctx context.Context, hash, prompt string, ttl time.Duration) (string, error) {
+	key, vec, err := se.EmbedEntry(ctx, hash, prompt)
+	if err != nil {
+		return "", err
+	}
+	if err := se.Store.SetWithTTL(ctx, key, vec, ttl); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// EmbedEntry embeds prompt like StoreEmbedding but returns the key and the
+// encoded vector instead of storing them, so the caller can write them
+// together with the rest of the entry
+func (se *SemanticEngine) EmbedEntry(ctx context.Context, hash, prompt string) (string, []byte, error) {
+	vec, namespace, err := se.Embed(ctx, prompt)
+	if err != nil {
+		return "", nil, err
+	}
+	return EmbeddingKey(namespace, hash), Float32ToBytes(vec), nil
+}
+
+// Namespaces returns the namespace of every provider in the failover chain,
+// primary first
+func (se *SemanticEngine) Namespaces() []string {
+	chain := se.chain()
+	names := make([]string, len(chain))
+	for i, member := range chain {
+		names[i] = member.Name
+	}
+	return names
+}
+
+// EmbeddingModel returns the model used by the provider of a namespace, or ""
+// if the provider does not report one
+func (se *SemanticEngine) EmbeddingModel(namespace string) string {
+	for _, member := range se.chain() {
+		if member.Name != namespace {
+			continue
+		}
+		if p, ok := member.Provider.(interface{ EmbeddingModel() string }); ok {
+			return p.EmbeddingModel()
+		}
+	}
+	return ""
+}
+
+// ProviderHealth reports the health of every provider in the failover chain
+func (se *SemanticEngine) ProviderHealth() []ProviderHealth {
+	chain := se.chain()
+	report := make([]ProviderHealth, 0, len(chain))
+	for i, member := range chain {
+		health := se.health.report(member.Name)
+		health.Primary = i == 0
+		report = append(report, health)
+	}
+	return report
+}
+
+// backfillBatch is the number of vectors read per scan while backfilling
+const backfillBatch = 500
+
+// Backfill embeds, with the primary provider, up to limit entries that so far
+// only have vectors from a fallback provider. It returns how many entries
+// were backfilled and does nothing while the primary is down. The vectors of
+// each fallback namespace are read a page at a time.
+func (se *SemanticEngine) Backfill(ctx context.Context, limit int) (int, error) {
+	chain := se.chain()
+	primary := chain[0]
+	if !se.health.available(primary.Name) {
+		return 0, nil
+	}
+	store, ok := se.Store.(ScanStorage)
+	if !ok {
+		return 0, errors.New("backfill needs a store that can be scanned")
+	}
+
+	done := 0
+	seen := make(map[string]bool)
+	for _, fallback := range chain[1:] {
+		prefix := EmbeddingKey(fallback.Name, "")
+		cursor := ""
+		for {
+			items, next, err := store.Scan(ctx, prefix, cursor, backfillBatch)
+			if err != nil {
+				return done, err
+			}
+
+			for _, kv := range items {
+				if limit > 0 && done >= limit {
+					return done, nil
+				}
+				_, hash := ParseEmbeddingKey(kv.Key)
+				if seen[hash] {
+					continue
+				}
+				seen[hash] = true
+
+				// Vectors stored without a namespace belong to the primary
+				missing, err := se.missingPrimary(ctx, store, primary.Name, hash)
+				if err != nil {
+					return done, err
+				}
+				if !missing {
+					continue
+				}
+
+				added, err := se.backfillEntry(ctx, primary, hash)
+				if errors.Is(err, ErrBudgetExhausted) {
+					return done, nil
+				}
+				if err != nil {
+					return done, err
+				}
+				if added {
+					done++
+				}
+			}
+
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+
+	return done, nil
+}
+
+// missingPrimary reports whether the entry under hash has no vector for the
+// primary provider
+func (se *SemanticEngine) missingPrimary(ctx context.Context, store ScanStorage, primary, hash string) (bool, error) {
+	for _, key := range []string{EmbeddingKey(primary, hash), EmbeddingKey("", hash)} {
+		vec, err := store.Get(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		if vec != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// backfillEntry embeds the prompt of the entry under hash with primary and
+// adds the vector to the entry. It reports false for entries that cannot be
+// backfilled, such as expired ones, and fails with ErrBudgetExhausted once
+// the primary's budget is spent.
+func (se *SemanticEngine) backfillEntry(ctx context.Context, primary NamedEmbedder, hash string) (bool, error) {
+	prompt, err := se.Store.GetPrompt(ctx, hash)
+	if err != nil {
+		// Without the prompt the entry cannot be re-embedded
+		return false, nil
+	}
+
+	// Backfilling is never worth exceeding the budget; try again next pass
+	if err := se.Budgets().Reserve(primary.Name, CallEmbed, EstimateTokens(prompt)); err != nil {
+		return false, err
+	}
+
+	vec, err := se.embedWith(ctx, primary.Provider, prompt)
+	if err != nil {
+		se.health.recordFailure(primary.Name, err)
+		return false, err
+	}
+	se.health.recordSuccess(primary.Name)
+
+	// The vector expires with its entry and is listed in its metadata;
+	// stores without entries only keep the vector
+	key := EmbeddingKey(primary.Name, hash)
+	if entries, ok := se.Store.(storage.Storage); ok {
+		err = storage.AddEmbedding(ctx, entries, hash, key, Float32ToBytes(vec))
+	} else {
+		err = se.Store.Set(ctx, key, Float32ToBytes(vec))
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		// The entry expired meanwhile
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,266 @@
+package semantic
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// FailingProvider fails while down is set and counts its calls
+type FailingProvider struct {
+	embedding []float32
+	down      bool
+	calls     int
+}
+
+func (f *FailingProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	f.calls++
+	if f.down {
+		return nil, errors.New("provider unavailable")
+	}
+	return f.embedding, nil
+}
+
+// MemoryStorage is a Storage that keeps what is written to it
+type MemoryStorage struct {
+	data map[string][]byte
+}
+
+func (m *MemoryStorage) Set(ctx context.Context, key string, value []byte) error {
+	m.data[key] = value
+	return nil
+}
+
+func (m *MemoryStorage) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.data[key] = value
+	return nil
+}
+
+func (m *MemoryStorage) GetAllEmbeddings(ctx context.Context) (map[string][]byte, error) {
+	res := make(map[string][]byte)
+	for k, v := range m.data {
+		if len(k) > len(storage.EmbeddingPrefix) && k[:len(storage.EmbeddingPrefix)] == storage.EmbeddingPrefix {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
+func (m *MemoryStorage) GetPrompt(ctx context.Context, key string) (string, error) {
+	v, ok := m.data[storage.PromptPrefix+key]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return string(v), nil
+}
+
+func (m *MemoryStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	return m.data[key], nil
+}
+
+func (m *MemoryStorage) Scan(ctx context.Context, prefix, cursor string, limit int) ([]storage.KV, string, error) {
+	var keys []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) && k > cursor {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	items := make([]storage.KV, len(keys))
+	for i, k := range keys {
+		items[i] = storage.KV{Key: k, Value: m.data[k]}
+	}
+	return items, next, nil
+}
+
+func TestParseEmbeddingKey(t *testing.T) {
+	tests := []struct {
+		key       string
+		namespace string
+		hash      string
+	}{
+		{key: "emb:openai:abc", namespace: "openai", hash: "abc"},
+		{key: "emb:abc", namespace: "", hash: "abc"},
+		{key: EmbeddingKey("mistral", "def"), namespace: "mistral", hash: "def"},
+	}
+
+	for _, tt := range tests {
+		namespace, hash := ParseEmbeddingKey(tt.key)
+		if namespace != tt.namespace || hash != tt.hash {
+			t.Errorf("ParseEmbeddingKey(%q) = (%q, %q), want (%q, %q)", tt.key, namespace, hash, tt.namespace, tt.hash)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+
+	primary := &FailingProvider{embedding: []float32{1, 0, 0}, down: true}
+	fallback := &FailingProvider{embedding: []float32{0, 1}}
+	store := &MemoryStorage{data: map[string][]byte{
+		storage.PromptPrefix + "hash1": []byte("what is go"),
+	}}
+
+	config := &Config{HighThreshold: 0.90, LowThreshold: 0.50, FailureThreshold: 2, FailoverCooldown: time.Minute}
+	engine := NewSemanticEngine(primary, store, &MockVerifier{}, config)
+	engine.SetFallbacks([]NamedEmbedder{{Name: "mistral", Provider: fallback}})
+
+	// The store path writes into the fallback's namespace while the primary is down
+	embKey, err := engine.StoreEmbedding(context.Background(), "hash1", "what is go", 0)
+	if err != nil {
+		t.Fatalf("StoreEmbedding failed: %v", err)
+	}
+	if _, ok := store.data[embKey]; !ok || embKey != "emb:mistral:hash1" {
+		t.Fatal("Expected embedding in the fallback namespace")
+	}
+
+	// Lookups use the fallback and search its namespace
+	decision, err := engine.Lookup(context.Background(), "what is go")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if decision.Key != "emb:mistral:hash1" {
+		t.Errorf("Expected hit on fallback namespace, got '%s'", decision.Key)
+	}
+
+	// After two failures the primary is skipped entirely
+	calls := primary.calls
+	if _, _, err := engine.Embed(context.Background(), "again"); err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if primary.calls != calls {
+		t.Error("Expected primary to be skipped while marked down")
+	}
+
+	health := engine.ProviderHealth()
+	if health[0].Healthy || !health[1].Healthy {
+		t.Errorf("Expected primary down and fallback healthy, got %+v", health)
+	}
+
+	// Backfill waits for the primary to recover
+	if n, _ := engine.Backfill(context.Background(), 10); n != 0 {
+		t.Errorf("Expected no backfill while primary is down, got %d", n)
+	}
+
+	primary.down = false
+	engine.health.recordSuccess("openai")
+
+	n, err := engine.Backfill(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 backfilled entry, got %d", n)
+	}
+	if _, ok := store.data["emb:openai:hash1"]; !ok {
+		t.Error("Expected embedding backfilled into the primary namespace")
+	}
+}
+
+func TestBackfill_JoinsEntry(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+	ctx := context.Background()
+
+	store, err := storage.NewMemoryStore(storage.MemoryOptions{})
+	if err != nil {
+		t.Fatalf("NewMemoryStore failed: %v", err)
+	}
+	defer store.Close()
+	fallbackKey := EmbeddingKey("mistral", "hash1")
+	store.PutEntry(ctx, &storage.Entry{
+		Key:        "hash1",
+		Value:      []byte("response"),
+		Prompt:     []byte("what is go"),
+		Embeddings: map[string][]byte{fallbackKey: Float32ToBytes([]float32{0, 1})},
+		Metadata:   storage.EntryMetadata{TTL: time.Hour, CreatedAt: time.Now().Add(-30 * time.Minute)},
+	})
+
+	engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, store, &MockVerifier{}, &Config{HighThreshold: 0.9, LowThreshold: 0.5})
+	engine.SetFallbacks([]NamedEmbedder{{Name: "mistral", Provider: &MockProvider{embedding: []float32{0, 1}}}})
+	if n, err := engine.Backfill(ctx, 10); err != nil || n != 1 {
+		t.Fatalf("Expected 1 backfilled entry, got %d, %v", n, err)
+	}
+
+	// The vector is part of the entry and expires with it
+	primaryKey := EmbeddingKey("openai", "hash1")
+	entry, err := store.GetEntry(ctx, "hash1")
+	if err != nil {
+		t.Fatalf("GetEntry failed: %v", err)
+	}
+	if entry.Embeddings[primaryKey] == nil || entry.Embeddings[fallbackKey] == nil {
+		t.Errorf("Expected both vectors in the entry, got %v", entry.Metadata.EmbeddingKeys)
+	}
+	ttl, err := store.TTL(ctx, primaryKey)
+	if err != nil {
+		t.Fatalf("TTL failed: %v", err)
+	}
+	if ttl <= 0 || ttl > 30*time.Minute {
+		t.Errorf("Expected the remaining TTL of the entry, got %v", ttl)
+	}
+}
+
+func TestRank_SkipsOtherNamespaces(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+
+	store := &MockStorage{
+		embeddings: map[string][]byte{
+			"emb:legacy":         Float32ToBytes([]float32{0.9, 0.1, 0}),
+			"emb:openai:current": Float32ToBytes([]float32{1, 0, 0}),
+			"emb:mistral:other":  Float32ToBytes([]float32{1, 0, 0}),
+			"emb:openai:short":   Float32ToBytes([]float32{1, 0}),
+		},
+	}
+	engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, store, &MockVerifier{}, &Config{HighThreshold: 0.95, LowThreshold: 0.5})
+
+	candidates, err := engine.rank(context.Background(), []float32{1, 0, 0}, "openai", 0, engine.Thresholds())
+	if err != nil {
+		t.Fatalf("rank failed: %v", err)
+	}
+
+	// Legacy keys count as the primary namespace; other namespaces and
+	// mismatched dimensions are skipped
+	if len(candidates) != 2 {
+		t.Fatalf("Expected 2 candidates, got %d: %+v", len(candidates), candidates)
+	}
+	if candidates[0].Key != "emb:openai:current" || candidates[1].Key != "emb:legacy" {
+		t.Errorf("Unexpected candidates: %+v", candidates)
+	}
+}
+
+// HangingProvider blocks until the context is done
+type HangingProvider struct{}
+
+func (h *HangingProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestFailover_EmbedTimeout(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "openai")
+
+	config := &Config{HighThreshold: 0.9, LowThreshold: 0.5, EmbedTimeout: 20 * time.Millisecond}
+	engine := NewSemanticEngine(&HangingProvider{}, &MemoryStorage{data: map[string][]byte{}}, &MockVerifier{}, config)
+	engine.SetFallbacks([]NamedEmbedder{{Name: "mistral", Provider: &FailingProvider{embedding: []float32{0, 1}}}})
+
+	_, namespace, err := engine.Embed(context.Background(), "query")
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if namespace != "mistral" {
+		t.Errorf("Expected a hung primary to fail over, got namespace '%s'", namespace)
+	}
+	if health := engine.ProviderHealth(); health[0].ConsecutiveFailures != 1 {
+		t.Errorf("Expected the timeout to count as a failure, got %+v", health[0])
+	}
+}
@@ -0,0 +1,32 @@
+package semantic
+
+import (
+	"strings"
+
+	"github.com/messkan/PromptCache/internal/storage"
+)
+
+// EmbeddingKey returns the storage key of the embedding of hash in a
+// provider namespace, e.g. "emb:openai:<hash>"
+func EmbeddingKey(namespace, hash string) string {
+	if namespace == "" {
+		return storage.EmbeddingPrefix + hash
+	}
+	return storage.EmbeddingPrefix + namespace + ":" + hash
+}
+
+// ParseEmbeddingKey splits an embedding key into its namespace and hash.
+// Legacy keys written before namespacing ("emb:<hash>") have an empty namespace.
+func ParseEmbeddingKey(key string) (namespace, hash string) {
+	rest := strings.TrimPrefix(key, storage.EmbeddingPrefix)
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		return rest[:i], rest[i+1:]
+	}
+	return "", rest
+}
+
+// HashFromKey returns the cache key (prompt hash) an embedding key refers to
+func HashFromKey(key string) string {
+	_, hash := ParseEmbeddingKey(key)
+	return hash
+}
@@ -8,17 +8,49 @@ import (
 	"io"
 	"net/http"
 	"os"
+
+	"github.com/messkan/PromptCache/internal/httpclient"
+)
+
+const (
+	// mistralEmbeddingModel is the default model used for embeddings
+	mistralEmbeddingModel = "mistral-embed"
+
+	// mistralVerifierModel is the default chat model used for gray zone verification
+	mistralVerifierModel = "mistral-small-latest"
 )
 
+func init() {
+	Register(ProviderSpec{
+		Name:        "mistral",
+		Description: "Mistral AI embeddings and verification",
+		Schema: []ConfigField{
+			{Name: "api_key", Description: "Mistral API key", Env: "MISTRAL_API_KEY", Secret: true},
+			{Name: "embedding_model", Description: "Embedding model", Default: mistralEmbeddingModel},
+			{Name: "verifier_model", Description: "Chat model used for verification", Default: mistralVerifierModel},
+		},
+		Capabilities: Capabilities{Embed: true, Verify: true, Batch: true, Dimensions: 1024},
+		Factory: func(cfg ProviderConfig) (Provider, error) {
+			p := NewMistralProvider()
+			p.apiKey = cfg["api_key"]
+			p.embeddingModel = cfg["embedding_model"]
+			p.verifier.Model = cfg["verifier_model"]
+			return p, nil
+		},
+	})
+}
+
 type MistralProvider struct {
-	apiKey string
-	client *http.Client
+	apiKey         string
+	client         *http.Client
+	embeddingModel string
+	verifier       VerifierOptions
 }
 
 func NewMistralProvider() *MistralProvider {
 	return &MistralProvider{
 		apiKey: os.Getenv("MISTRAL_API_KEY"),
-		client: &http.Client{},
+		client: httpclient.Default(),
 	}
 }
 
@@ -35,9 +67,18 @@ type MistralEmbeddingResponse struct {
 }
 
 func (p *MistralProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	res, err := p.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// EmbedBatch embeds several texts in a single API call
+func (p *MistralProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
 	reqBody := MistralEmbeddingRequest{
-		Input:          []string{text},
-		Model:          "mistral-embed",
+		Input:          texts,
+		Model:          p.EmbeddingModel(),
 		EncodingFormat: "float",
 	}
 	jsonBody, err := json.Marshal(reqBody)
@@ -69,14 +110,17 @@ func (p *MistralProvider) Embed(ctx context.Context, text string) ([]float32, er
 		return nil, err
 	}
 
-	if len(embeddingResp.Data) == 0 {
+	if len(embeddingResp.Data) != len(texts) {
 		return nil, fmt.Errorf("no embedding data returned")
 	}
 
 	// Convert float64 to float32
-	res := make([]float32, len(embeddingResp.Data[0].Embedding))
-	for i, v := range embeddingResp.Data[0].Embedding {
-		res[i] = float32(v)
+	res := make([][]float32, len(embeddingResp.Data))
+	for i, d := range embeddingResp.Data {
+		res[i] = make([]float32, len(d.Embedding))
+		for j, v := range d.Embedding {
+			res[i][j] = float32(v)
+		}
 	}
 
 	return res, nil
@@ -99,11 +143,12 @@ type MistralChatResponse struct {
 }
 
 func (p *MistralProvider) CheckSimilarity(ctx context.Context, prompt1, prompt2 string) (bool, error) {
-	systemPrompt := "You are a semantic judge. Determine if the two user prompts have the exact same intent and meaning. Answer only with 'YES' or 'NO'."
-	userPrompt := fmt.Sprintf("Prompt 1: %s\nPrompt 2: %s", prompt1, prompt2)
+	opts := p.VerifierOptions()
+	systemPrompt := opts.SystemPrompt
+	userPrompt := opts.render(prompt1, prompt2)
 
 	reqBody := MistralChatRequest{
-		Model: "mistral-small-latest",
+		Model: opts.Model,
 		Messages: []MistralMessage{
 			{Role: "system", Content: systemPrompt},
 			{Role: "user", Content: userPrompt},
@@ -146,3 +191,24 @@ func (p *MistralProvider) CheckSimilarity(ctx context.Context, prompt1, prompt2
 	content := chatResp.Choices[0].Message.Content
 	return content == "YES", nil
 }
+
+// VerifierOptions returns the effective verification options
+func (p *MistralProvider) VerifierOptions() VerifierOptions {
+	return p.verifier.withDefaults(mistralVerifierModel)
+}
+
+// EmbeddingModel returns the model used for embeddings
+func (p *MistralProvider) EmbeddingModel() string {
+	if p.embeddingModel == "" {
+		return mistralEmbeddingModel
+	}
+	return p.embeddingModel
+}
+
+// setVerifierOptions applies options on top of the instance's configured model
+func (p *MistralProvider) setVerifierOptions(opts VerifierOptions) {
+	if opts.Model == "" {
+		opts.Model = p.verifier.Model
+	}
+	p.verifier = opts
+}
@@ -8,23 +8,55 @@ import (
 	"io"
 	"net/http"
 	"os"
+
+	"github.com/messkan/PromptCache/internal/httpclient"
+)
+
+const (
+	// openAIEmbeddingModel is the default model used for embeddings
+	openAIEmbeddingModel = "text-embedding-3-small"
+
+	// openAIVerifierModel is the default chat model used for gray zone verification
+	openAIVerifierModel = "gpt-4o-mini"
 )
 
+func init() {
+	Register(ProviderSpec{
+		Name:        "openai",
+		Description: "OpenAI embeddings and verification",
+		Schema: []ConfigField{
+			{Name: "api_key", Description: "OpenAI API key", Env: "OPENAI_API_KEY", Secret: true},
+			{Name: "embedding_model", Description: "Embedding model", Default: openAIEmbeddingModel},
+			{Name: "verifier_model", Description: "Chat model used for verification", Default: openAIVerifierModel},
+		},
+		Capabilities: Capabilities{Embed: true, Verify: true, Batch: true, Dimensions: 1536},
+		Factory: func(cfg ProviderConfig) (Provider, error) {
+			p := NewOpenAIProvider()
+			p.apiKey = cfg["api_key"]
+			p.embeddingModel = cfg["embedding_model"]
+			p.verifier.Model = cfg["verifier_model"]
+			return p, nil
+		},
+	})
+}
+
 type OpenAIProvider struct {
-	apiKey string
-	client *http.Client
+	apiKey         string
+	client         *http.Client
+	embeddingModel string
+	verifier       VerifierOptions
 }
 
 func NewOpenAIProvider() *OpenAIProvider {
 	return &OpenAIProvider{
 		apiKey: os.Getenv("OPENAI_API_KEY"),
-		client: &http.Client{},
+		client: httpclient.Default(),
 	}
 }
 
 type EmbeddingRequest struct {
-	Input string `json:"input"`
-	Model string `json:"model"`
+	Input []string `json:"input"`
+	Model string   `json:"model"`
 }
 
 type EmbeddingResponse struct {
@@ -34,9 +66,18 @@ type EmbeddingResponse struct {
 }
 
 func (p *OpenAIProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	res, err := p.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// EmbedBatch embeds several texts in a single API call
+func (p *OpenAIProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
 	reqBody := EmbeddingRequest{
-		Input: text,
-		Model: "text-embedding-3-small",
+		Input: texts,
+		Model: p.EmbeddingModel(),
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
@@ -67,14 +108,17 @@ func (p *OpenAIProvider) Embed(ctx context.Context, text string) ([]float32, err
 		return nil, err
 	}
 
-	if len(embeddingResp.Data) == 0 {
+	if len(embeddingResp.Data) != len(texts) {
 		return nil, fmt.Errorf("no embedding data returned")
 	}
 
 	// Convert float64 to float32
-	res := make([]float32, len(embeddingResp.Data[0].Embedding))
-	for i, v := range embeddingResp.Data[0].Embedding {
-		res[i] = float32(v)
+	res := make([][]float32, len(embeddingResp.Data))
+	for i, d := range embeddingResp.Data {
+		res[i] = make([]float32, len(d.Embedding))
+		for j, v := range d.Embedding {
+			res[i][j] = float32(v)
+		}
 	}
 
 	return res, nil
@@ -97,11 +141,12 @@ type VerificationResponse struct {
 }
 
 func (p *OpenAIProvider) CheckSimilarity(ctx context.Context, prompt1, prompt2 string) (bool, error) {
-	systemPrompt := "You are a semantic judge. Determine if the two user prompts have the exact same intent and meaning. Answer only with 'YES' or 'NO'."
-	userPrompt := fmt.Sprintf("Prompt 1: %s\nPrompt 2: %s", prompt1, prompt2)
+	opts := p.VerifierOptions()
+	systemPrompt := opts.SystemPrompt
+	userPrompt := opts.render(prompt1, prompt2)
 
 	reqBody := VerificationRequest{
-		Model: "gpt-4o-mini",
+		Model: opts.Model,
 		Messages: []Message{
 			{Role: "system", Content: systemPrompt},
 			{Role: "user", Content: userPrompt},
@@ -144,3 +189,24 @@ func (p *OpenAIProvider) CheckSimilarity(ctx context.Context, prompt1, prompt2 s
 	content := verResp.Choices[0].Message.Content
 	return content == "YES", nil
 }
+
+// VerifierOptions returns the effective verification options
+func (p *OpenAIProvider) VerifierOptions() VerifierOptions {
+	return p.verifier.withDefaults(openAIVerifierModel)
+}
+
+// EmbeddingModel returns the model used for embeddings
+func (p *OpenAIProvider) EmbeddingModel() string {
+	if p.embeddingModel == "" {
+		return openAIEmbeddingModel
+	}
+	return p.embeddingModel
+}
+
+// setVerifierOptions applies options on top of the instance's configured model
+func (p *OpenAIProvider) setVerifierOptions(opts VerifierOptions) {
+	if opts.Model == "" {
+		opts.Model = p.verifier.Model
+	}
+	p.verifier = opts
+}
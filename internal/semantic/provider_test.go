@@ -350,3 +350,64 @@ func TestOpenAIProvider_CheckSimilarity_NoMatch(t *testing.T) {
 		t.Errorf("Expected match=false, got true")
 	}
 }
+
+// RecordingRoundTripper captures the request body before responding
+type RecordingRoundTripper struct {
+	MockRoundTripper
+	Body []byte
+}
+
+func (r *RecordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.Body, _ = io.ReadAll(req.Body)
+	return r.MockRoundTripper.RoundTrip(req)
+}
+
+func TestOpenAIProvider_CheckSimilarity_CustomOptions(t *testing.T) {
+	mockResponse := VerificationResponse{
+		Choices: []struct {
+			Message Message `json:"message"`
+		}{
+			{Message: Message{Role: "assistant", Content: "YES"}},
+		},
+	}
+
+	jsonBytes, _ := json.Marshal(mockResponse)
+
+	transport := &RecordingRoundTripper{
+		MockRoundTripper: MockRoundTripper{
+			Response: &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader(jsonBytes)),
+			},
+		},
+	}
+
+	provider := &OpenAIProvider{
+		apiKey: "test-key",
+		client: &http.Client{Transport: transport},
+		verifier: VerifierOptions{
+			Model:          "gpt-4o",
+			SystemPrompt:   "Compare these.",
+			PromptTemplate: "A={{prompt1}} B={{prompt2}}",
+		},
+	}
+
+	if _, err := provider.CheckSimilarity(context.Background(), "first", "second"); err != nil {
+		t.Fatalf("CheckSimilarity failed: %v", err)
+	}
+
+	var sent VerificationRequest
+	if err := json.Unmarshal(transport.Body, &sent); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+
+	if sent.Model != "gpt-4o" {
+		t.Errorf("Expected model 'gpt-4o', got '%s'", sent.Model)
+	}
+	if sent.Messages[0].Content != "Compare these." {
+		t.Errorf("Expected custom system prompt, got '%s'", sent.Messages[0].Content)
+	}
+	if sent.Messages[1].Content != "A=first B=second" {
+		t.Errorf("Expected rendered template, got '%s'", sent.Messages[1].Content)
+	}
+}
@@ -0,0 +1,260 @@
+package semantic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Capabilities describes what a provider type supports
+type Capabilities struct {
+	Embed      bool `json:"embed"`
+	Verify     bool `json:"verify"`
+	Batch      bool `json:"batch"`
+	Dimensions int  `json:"dimensions,omitempty"` // Vector size of the default embedding model
+}
+
+// ConfigField describes one configuration option of a provider type
+type ConfigField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Env         string `json:"env,omitempty"`     // Environment variable used when the option is not set
+	Default     string `json:"default,omitempty"` // Value used when neither the option nor Env is set
+	Secret      bool   `json:"secret,omitempty"`  // Never reported back by the API
+}
+
+// ProviderConfig holds the option values of a provider instance
+type ProviderConfig map[string]string
+
+// ProviderFactory creates a provider from a fully resolved config
+type ProviderFactory func(cfg ProviderConfig) (Provider, error)
+
+// ProviderSpec registers a provider type
+type ProviderSpec struct {
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Schema       []ConfigField   `json:"schema"`
+	Capabilities Capabilities    `json:"capabilities"`
+	Factory      ProviderFactory `json:"-"`
+}
+
+// ProviderInstance is a named, configured instance of a provider type, e.g.
+// "openai-large" of type "openai" with a different embedding model
+type ProviderInstance struct {
+	Name   string         `json:"name"`
+	Type   string         `json:"type"`
+	Config ProviderConfig `json:"config,omitempty"`
+}
+
+// Registry maps provider names to factories
+type Registry struct {
+	mu        sync.RWMutex
+	specs     map[string]ProviderSpec
+	instances map[string]ProviderInstance
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		specs:     make(map[string]ProviderSpec),
+		instances: make(map[string]ProviderInstance),
+	}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Register adds a provider type to the default registry. It is meant to be
+// called from init and panics on duplicate names.
+func Register(spec ProviderSpec) {
+	if err := defaultRegistry.Register(spec); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterInstance adds a named provider instance to the default registry
+func RegisterInstance(inst ProviderInstance) error {
+	return defaultRegistry.RegisterInstance(inst)
+}
+
+// LoadInstances registers the provider instances defined as a JSON array in
+// the PROVIDER_INSTANCES environment variable
+func LoadInstances() error {
+	val := os.Getenv("PROVIDER_INSTANCES")
+	if val == "" {
+		return nil
+	}
+
+	var instances []ProviderInstance
+	if err := json.Unmarshal([]byte(val), &instances); err != nil {
+		return fmt.Errorf("invalid PROVIDER_INSTANCES: %w", err)
+	}
+
+	for _, inst := range instances {
+		if err := RegisterInstance(inst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AvailableProviders lists every provider type and instance name
+func AvailableProviders() []string {
+	return defaultRegistry.Names()
+}
+
+// ProviderSpecs lists the registered provider types
+func ProviderSpecs() []ProviderSpec {
+	return defaultRegistry.Specs()
+}
+
+// ProviderInstances lists the registered instances with secrets removed
+func ProviderInstances() []ProviderInstance {
+	return defaultRegistry.Instances()
+}
+
+// Register adds a provider type
+func (r *Registry) Register(spec ProviderSpec) error {
+	name := strings.ToLower(spec.Name)
+	if name == "" || spec.Factory == nil {
+		return fmt.Errorf("provider spec needs a name and a factory")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.specs[name]; exists {
+		return fmt.Errorf("provider %s already registered", name)
+	}
+	spec.Name = name
+	r.specs[name] = spec
+	return nil
+}
+
+// RegisterInstance adds a named instance of a registered provider type
+func (r *Registry) RegisterInstance(inst ProviderInstance) error {
+	inst.Name = strings.ToLower(inst.Name)
+	inst.Type = strings.ToLower(inst.Type)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spec, ok := r.specs[inst.Type]
+	if !ok {
+		return fmt.Errorf("provider instance %s: unknown type %s", inst.Name, inst.Type)
+	}
+	if inst.Name == "" || strings.Contains(inst.Name, ":") {
+		return fmt.Errorf("provider instance name %q is invalid", inst.Name)
+	}
+	if _, exists := r.specs[inst.Name]; exists {
+		return fmt.Errorf("provider instance %s shadows a provider type", inst.Name)
+	}
+	if _, exists := r.instances[inst.Name]; exists {
+		return fmt.Errorf("provider instance %s already registered", inst.Name)
+	}
+
+	known := make(map[string]bool, len(spec.Schema))
+	for _, field := range spec.Schema {
+		known[field.Name] = true
+	}
+	for key := range inst.Config {
+		if !known[key] {
+			return fmt.Errorf("provider instance %s: unknown option %s for type %s", inst.Name, key, inst.Type)
+		}
+	}
+
+	r.instances[inst.Name] = inst
+	return nil
+}
+
+// New creates the provider registered under name, which is either an
+// instance name or a provider type using its default config
+func (r *Registry) New(name string) (Provider, error) {
+	name = strings.ToLower(name)
+
+	r.mu.RLock()
+	inst, isInstance := r.instances[name]
+	if !isInstance {
+		inst = ProviderInstance{Name: name, Type: name}
+	}
+	spec, ok := r.specs[inst.Type]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s (supported: %s)", name, strings.Join(r.Names(), ", "))
+	}
+
+	return spec.Factory(resolveConfig(spec.Schema, inst.Config))
+}
+
+// Names lists provider types and instance names in sorted order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.specs)+len(r.instances))
+	for name := range r.specs {
+		names = append(names, name)
+	}
+	for name := range r.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Specs lists the registered provider types sorted by name
+func (r *Registry) Specs() []ProviderSpec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	specs := make([]ProviderSpec, 0, len(r.specs))
+	for _, spec := range r.specs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// Instances lists the registered instances sorted by name, with secret
+// options removed
+func (r *Registry) Instances() []ProviderInstance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	instances := make([]ProviderInstance, 0, len(r.instances))
+	for _, inst := range r.instances {
+		secret := make(map[string]bool)
+		for _, field := range r.specs[inst.Type].Schema {
+			secret[field.Name] = field.Secret
+		}
+		cfg := make(ProviderConfig, len(inst.Config))
+		for key, val := range inst.Config {
+			if !secret[key] {
+				cfg[key] = val
+			}
+		}
+		instances = append(instances, ProviderInstance{Name: inst.Name, Type: inst.Type, Config: cfg})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	return instances
+}
+
+// resolveConfig fills every schema field from the instance config, then its
+// environment variable, then its default
+func resolveConfig(schema []ConfigField, cfg ProviderConfig) ProviderConfig {
+	resolved := make(ProviderConfig, len(schema))
+	for _, field := range schema {
+		switch {
+		case cfg[field.Name] != "":
+			resolved[field.Name] = cfg[field.Name]
+		case field.Env != "" && os.Getenv(field.Env) != "":
+			resolved[field.Name] = os.Getenv(field.Env)
+		default:
+			resolved[field.Name] = field.Default
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,129 @@
+package semantic
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// ConfiguredProvider records the config it was created with
+type ConfiguredProvider struct {
+	MockProvider
+	MockVerifier
+	cfg ProviderConfig
+}
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	err := r.Register(ProviderSpec{
+		Name: "fake",
+		Schema: []ConfigField{
+			{Name: "api_key", Env: "FAKE_API_KEY", Secret: true},
+			{Name: "embedding_model", Default: "fake-small"},
+		},
+		Capabilities: Capabilities{Embed: true, Verify: true, Dimensions: 3},
+		Factory: func(cfg ProviderConfig) (Provider, error) {
+			return &ConfiguredProvider{MockProvider: MockProvider{embedding: []float32{1, 0, 0}}, cfg: cfg}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	return r
+}
+
+func TestRegistry_New(t *testing.T) {
+	t.Setenv("FAKE_API_KEY", "env-key")
+	r := newTestRegistry(t)
+
+	if err := r.RegisterInstance(ProviderInstance{
+		Name:   "Fake-Large",
+		Type:   "fake",
+		Config: ProviderConfig{"embedding_model": "fake-large", "api_key": "instance-key"},
+	}); err != nil {
+		t.Fatalf("RegisterInstance failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want ProviderConfig
+	}{
+		{name: "fake", want: ProviderConfig{"api_key": "env-key", "embedding_model": "fake-small"}},
+		{name: "FAKE", want: ProviderConfig{"api_key": "env-key", "embedding_model": "fake-small"}},
+		{name: "fake-large", want: ProviderConfig{"api_key": "instance-key", "embedding_model": "fake-large"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := r.New(tt.name)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			got := p.(*ConfiguredProvider).cfg
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected config %v, got %v", tt.want, got)
+			}
+			if _, err := p.Embed(context.Background(), "text"); err != nil {
+				t.Errorf("Embed failed: %v", err)
+			}
+		})
+	}
+
+	if _, err := r.New("missing"); err == nil {
+		t.Error("Expected error for unknown provider")
+	}
+
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"fake", "fake-large"}) {
+		t.Errorf("Unexpected names: %v", names)
+	}
+
+	// Secrets are never listed
+	instances := r.Instances()
+	if _, ok := instances[0].Config["api_key"]; ok {
+		t.Error("Expected api_key to be hidden from instance listing")
+	}
+}
+
+func TestRegistry_RegisterInstanceErrors(t *testing.T) {
+	r := newTestRegistry(t)
+
+	tests := []struct {
+		name string
+		inst ProviderInstance
+	}{
+		{name: "unknown type", inst: ProviderInstance{Name: "a", Type: "missing"}},
+		{name: "unknown option", inst: ProviderInstance{Name: "a", Type: "fake", Config: ProviderConfig{"region": "eu"}}},
+		{name: "shadows type", inst: ProviderInstance{Name: "fake", Type: "fake"}},
+		{name: "namespace separator", inst: ProviderInstance{Name: "a:b", Type: "fake"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.RegisterInstance(tt.inst); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+
+	if err := r.Register(ProviderSpec{Name: "fake", Factory: func(ProviderConfig) (Provider, error) { return nil, nil }}); err == nil {
+		t.Error("Expected error for duplicate provider type")
+	}
+}
+
+func TestBuiltinProvidersRegistered(t *testing.T) {
+	for _, name := range []string{"openai", "mistral", "claude"} {
+		found := false
+		for _, spec := range ProviderSpecs() {
+			if spec.Name == name {
+				found = true
+				if !spec.Capabilities.Embed || !spec.Capabilities.Verify {
+					t.Errorf("Expected %s to support embed and verify", name)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Expected built-in provider %s to be registered", name)
+		}
+	}
+}
@@ -2,11 +2,17 @@ package semantic
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/messkan/PromptCache/internal/httpclient"
+	"github.com/messkan/PromptCache/internal/storage"
 )
 
 type EmbeddingProvider interface {
@@ -14,10 +20,25 @@ type EmbeddingProvider interface {
 }
 
 type Storage interface {
+	Set(ctx context.Context, key string, value []byte) error
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
 	GetAllEmbeddings(ctx context.Context) (map[string][]byte, error)
 	GetPrompt(ctx context.Context, key string) (string, error)
 }
 
+// VectorStorage is implemented by stores that keep the vectors decoded in
+// memory, so ranking does not decode every vector on every lookup
+type VectorStorage interface {
+	GetAllVectors(ctx context.Context) (map[string][]float32, error)
+}
+
+// ScanStorage is implemented by stores that list their records a page at a
+// time, so background jobs do not load every vector at once
+type ScanStorage interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Scan(ctx context.Context, prefix, cursor string, limit int) ([]storage.KV, string, error)
+}
+
 type Verifier interface {
 	CheckSimilarity(ctx context.Context, prompt1, prompt2 string) (bool, error)
 }
@@ -33,6 +54,11 @@ type Config struct {
 	HighThreshold          float32
 	LowThreshold           float32
 	EnableGrayZoneVerifier bool
+	FallbackProviders      []string      // Ordered embedding providers tried when the primary fails
+	FailureThreshold       int           // Consecutive failures before a provider is marked down
+	FailoverCooldown       time.Duration // How long a failed provider is skipped
+	EmbedTimeout           time.Duration // Deadline of one embedding call, 0 for none
+	VerifyTimeout          time.Duration // Deadline of one verification call, 0 for none
 }
 
 // LoadConfig loads configuration from environment variables with sensible defaults
@@ -41,6 +67,8 @@ func LoadConfig() *Config {
 		HighThreshold:          0.70, // Default: 70% similarity for direct cache hit
 		LowThreshold:           0.30, // Default: below 30% is a clear miss
 		EnableGrayZoneVerifier: true, // Default: enable smart verification
+		EmbedTimeout:           10 * time.Second,
+		VerifyTimeout:          30 * time.Second,
 	}
 
 	// Load high threshold
@@ -62,6 +90,40 @@ func LoadConfig() *Config {
 		config.EnableGrayZoneVerifier = val == "true" || val == "1" || val == "yes"
 	}
 
+	// Load embedding failover chain
+	if val := os.Getenv("EMBEDDING_FALLBACK_PROVIDERS"); val != "" {
+		for _, name := range strings.Split(val, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				config.FallbackProviders = append(config.FallbackProviders, name)
+			}
+		}
+	}
+
+	if val := os.Getenv("EMBEDDING_FAILURE_THRESHOLD"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			config.FailureThreshold = n
+		}
+	}
+
+	if val := os.Getenv("EMBEDDING_FAILOVER_COOLDOWN"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			config.FailoverCooldown = d
+		}
+	}
+
+	// Load per-call deadlines; a hung provider call must not block a request
+	if val := os.Getenv("HTTP_EMBED_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			config.EmbedTimeout = d
+		}
+	}
+
+	if val := os.Getenv("HTTP_VERIFY_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			config.VerifyTimeout = d
+		}
+	}
+
 	// Ensure high threshold is greater than low threshold
 	if config.HighThreshold <= config.LowThreshold {
 		config.HighThreshold = 0.70
@@ -72,21 +134,27 @@ func LoadConfig() *Config {
 }
 
 type SemanticEngine struct {
-	Provider               EmbeddingProvider
-	Store                  Storage
-	Verifier               Verifier
-	HighThreshold          float32
-	LowThreshold           float32
-	EnableGrayZoneVerifier bool
-	mu                     sync.RWMutex // Protects Provider and Verifier
-	currentProviderName    string       // Tracks the current provider name
+	Provider            EmbeddingProvider
+	Store               Storage
+	Verifier            Verifier
+	thresholds          atomic.Pointer[Thresholds]
+	mu                  sync.RWMutex    // Protects Provider and Verifier
+	currentProviderName string          // Tracks the current provider name
+	currentVerifierName string          // Tracks the current verifier provider name
+	verifierOptions     VerifierOptions // Options requested for the verifier
+	verifierFollows     bool            // Verifier switches along with the embedding provider
+	fallbacks           []NamedEmbedder // Failover chain after the primary provider
+	health              *healthTracker
+	budgets             *Budgets
+	embedTimeout        time.Duration
+	verifyTimeout       time.Duration
 }
 
 func NewSemanticEngine(p EmbeddingProvider, s Storage, v Verifier, config *Config) *SemanticEngine {
 	if config == nil {
 		config = LoadConfig()
 	}
-	
+
 	// Detect provider name
 	providerName := "unknown"
 	if val := os.Getenv("EMBEDDING_PROVIDER"); val != "" {
@@ -94,63 +162,108 @@ func NewSemanticEngine(p EmbeddingProvider, s Storage, v Verifier, config *Confi
 	} else {
 		providerName = "openai" // default
 	}
-	
-	return &SemanticEngine{
-		Provider:               p,
-		Store:                  s,
-		Verifier:               v,
-		HighThreshold:          config.HighThreshold,
-		LowThreshold:           config.LowThreshold,
-		EnableGrayZoneVerifier: config.EnableGrayZoneVerifier,
-		currentProviderName:    providerName,
+
+	// Detect verifier name; without an explicit VERIFIER_PROVIDER the verifier
+	// follows the embedding provider
+	verifierName, explicit := verifierProviderName()
+
+	se := &SemanticEngine{
+		Provider:            p,
+		Store:               s,
+		Verifier:            v,
+		currentProviderName: providerName,
+		currentVerifierName: verifierName,
+		verifierOptions:     LoadVerifierOptions(),
+		verifierFollows:     !explicit,
+		health:              newHealthTracker(config.FailureThreshold, config.FailoverCooldown),
+		budgets:             NewBudgets(nil),
+		embedTimeout:        config.EmbedTimeout,
+		verifyTimeout:       config.VerifyTimeout,
 	}
+	se.thresholds.Store(&Thresholds{
+		High:             config.HighThreshold,
+		Low:              config.LowThreshold,
+		GrayZoneVerifier: config.EnableGrayZoneVerifier,
+	})
+	return se
 }
 
 // NewProvider creates an embedding provider based on the EMBEDDING_PROVIDER environment variable
-// Supported providers: openai (default), mistral, claude
+// Supported providers are every registered provider type and instance; openai is the default
 func NewProvider() (Provider, error) {
 	provider := os.Getenv("EMBEDDING_PROVIDER")
 	if provider == "" {
 		provider = "openai"
 	}
 
-	switch strings.ToLower(provider) {
-	case "openai":
-		return NewOpenAIProvider(), nil
-	case "mistral":
-		return NewMistralProvider(), nil
-	case "claude":
-		return NewClaudeProvider(), nil
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s (supported: openai, mistral, claude)", provider)
-	}
+	return NewProviderByName(provider)
+}
+
+// NewProviderByName creates the named provider type or instance from the registry
+func NewProviderByName(name string) (Provider, error) {
+	return defaultRegistry.New(name)
 }
 
-// SetProvider dynamically changes the embedding provider at runtime
+// SetProvider dynamically changes the embedding provider at runtime. The
+// verifier switches too unless it was configured independently.
 func (se *SemanticEngine) SetProvider(providerName string) error {
 	providerName = strings.ToLower(providerName)
-	
-	var newProvider Provider
-	var err error
-	
-	switch providerName {
-	case "openai":
-		newProvider = NewOpenAIProvider()
-	case "mistral":
-		newProvider = NewMistralProvider()
-	case "claude":
-		newProvider = NewClaudeProvider()
-	default:
-		return fmt.Errorf("unsupported provider: %s (supported: openai, mistral, claude)", providerName)
-	}
-	
+
+	newProvider, err := NewProviderByName(providerName)
+	if err != nil {
+		return err
+	}
+
 	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	if se.verifierFollows {
+		verifier, err := NewVerifier(providerName, se.verifierOptions)
+		if err != nil {
+			return err
+		}
+		se.Verifier = verifier
+		se.currentVerifierName = providerName
+	}
+
 	se.Provider = newProvider
-	se.Verifier = newProvider
 	se.currentProviderName = providerName
+
+	return nil
+}
+
+// SetVerifier changes the verifier provider and its options at runtime,
+// independently of the embedding provider
+func (se *SemanticEngine) SetVerifier(providerName string, opts VerifierOptions) error {
+	providerName = strings.ToLower(providerName)
+
+	verifier, err := NewVerifier(providerName, opts)
+	if err != nil {
+		return err
+	}
+
+	se.mu.Lock()
+	se.Verifier = verifier
+	se.currentVerifierName = providerName
+	se.verifierOptions = opts
+	se.verifierFollows = false
+	se.mu.Unlock()
+
+	return nil
+}
+
+// SetBudgets replaces the rate limits and budgets enforced on provider calls
+func (se *SemanticEngine) SetBudgets(budgets *Budgets) {
+	se.mu.Lock()
+	se.budgets = budgets
 	se.mu.Unlock()
-	
-	return err
+}
+
+// Budgets returns the rate limits and budgets enforced on provider calls
+func (se *SemanticEngine) Budgets() *Budgets {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.budgets
 }
 
 // GetCurrentProvider returns the name of the currently active provider
@@ -160,6 +273,24 @@ func (se *SemanticEngine) GetCurrentProvider() string {
 	return se.currentProviderName
 }
 
+// VerifierStatus describes the active verifier
+type VerifierStatus struct {
+	Provider string `json:"provider"`
+	VerifierOptions
+}
+
+// GetCurrentVerifier returns the active verifier provider and its effective options
+func (se *SemanticEngine) GetCurrentVerifier() VerifierStatus {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+
+	status := VerifierStatus{Provider: se.currentVerifierName, VerifierOptions: se.verifierOptions}
+	if cv, ok := se.Verifier.(ConfiguredVerifier); ok {
+		status.VerifierOptions = cv.VerifierOptions()
+	}
+	return status
+}
+
 // GetProvider returns the current provider instance (thread-safe)
 func (se *SemanticEngine) GetProvider() EmbeddingProvider {
 	se.mu.RLock()
@@ -167,68 +298,353 @@ func (se *SemanticEngine) GetProvider() EmbeddingProvider {
 	return se.Provider
 }
 
-func (se *SemanticEngine) FindSimilar(ctx context.Context, text string) (string, float32, error) {
+// Verify asks the current verifier whether text1 and text2 are equivalent,
+// within the verification deadline. The call is charged to the verifier's
+// budget; a *BudgetError is returned once it is exhausted.
+func (se *SemanticEngine) Verify(ctx context.Context, text1, text2 string) (bool, error) {
 	se.mu.RLock()
-	provider := se.Provider
 	verifier := se.Verifier
+	verifierName := se.currentVerifierName
 	se.mu.RUnlock()
-	
-	queryEmb, err := provider.Embed(ctx, text)
+	if verifier == nil {
+		return false, errors.New("no verifier configured")
+	}
+
+	tokens := EstimateTokens(text1) + EstimateTokens(text2) + verifierOverheadTokens
+	if err := se.Budgets().Reserve(verifierName, CallVerify, tokens); err != nil {
+		return false, err
+	}
+
+	verifyCtx, cancel := httpclient.WithTimeout(ctx, se.verifyTimeout)
+	defer cancel()
+	return verifier.CheckSimilarity(verifyCtx, text1, text2)
+}
+
+// Zone describes which similarity band a score fell into
+type Zone string
+
+const (
+	ZoneNone Zone = "none" // No stored embeddings to compare against
+	ZoneHigh Zone = "high" // Score at or above the high threshold
+	ZoneGray Zone = "gray" // Score between the low and high thresholds
+	ZoneLow  Zone = "low"  // Score below the low threshold
+)
+
+// Reasons recorded on a Decision for the path the lookup took
+const (
+	ReasonNoCandidates     = "no_candidates"
+	ReasonHighScore        = "high_score"
+	ReasonLowScore         = "low_score"
+	ReasonVerifierDisabled = "verifier_disabled"
+	ReasonVerifierSkipped  = "verifier_skipped"
+	ReasonPromptMissing    = "prompt_missing"
+	ReasonVerifierAccepted = "verifier_accepted"
+	ReasonVerifierRejected = "verifier_rejected"
+	ReasonVerifierError    = "verifier_error"
+	ReasonBudgetExhausted  = "budget_exhausted"
+)
+
+// verifierOverheadTokens approximates the system prompt and template tokens
+// of a verification call
+const verifierOverheadTokens = 60
+
+// Decision is the full outcome of a semantic lookup
+type Decision struct {
+	Key       string  `json:"key"`      // Matched embedding key, empty on a miss
+	BestKey   string  `json:"best_key"` // Closest stored key, set even when the lookup missed
+	Score     float32 `json:"score"`    // Similarity of BestKey to the query
+	Zone      Zone    `json:"zone"`
+	Reason    string  `json:"reason"`
+	Verified  *bool   `json:"verified,omitempty"` // Verifier outcome, nil if the verifier was not consulted
+	Namespace string  `json:"namespace"`          // Namespace of the provider that embedded the query
+	Cost      float64 `json:"cost"`               // USD spent on embedding and verification calls
+}
+
+// Hit reports whether the decision serves a cached response
+func (d *Decision) Hit() bool {
+	return d.Key != ""
+}
+
+// Candidate is a stored embedding scored against a query
+type Candidate struct {
+	Key    string  `json:"key"`
+	Prompt string  `json:"prompt,omitempty"`
+	Score  float32 `json:"score"`
+	Zone   Zone    `json:"zone"`
+}
+
+// Explanation lists the closest candidates for a query and the decision the
+// lookup reached for them
+type Explanation struct {
+	Candidates []Candidate `json:"candidates"`
+	Decision   *Decision   `json:"decision"`
+}
+
+func (se *SemanticEngine) FindSimilar(ctx context.Context, text string) (string, float32, error) {
+	decision, err := se.Lookup(ctx, text)
 	if err != nil {
+		if decision != nil {
+			return "", decision.Score, err
+		}
 		return "", 0, err
 	}
+	return decision.Key, decision.Score, nil
+}
 
-	stored, err := se.Store.GetAllEmbeddings(ctx)
+// Lookup runs the dual-threshold decision for text and reports the closest
+// candidate along with the path taken, whether or not it is a hit
+func (se *SemanticEngine) Lookup(ctx context.Context, text string) (*Decision, error) {
+	thresholds := se.Thresholds()
+	se.mu.RLock()
+	verifier := se.Verifier
+	verifierName := se.currentVerifierName
+	se.mu.RUnlock()
+
+	queryEmb, namespace, err := se.Embed(ctx, text)
 	if err != nil {
-		return "", 0, err
+		return nil, err
+	}
+
+	candidates, err := se.rank(ctx, queryEmb, namespace, 1, thresholds)
+	if err != nil {
+		return nil, err
+	}
+
+	decision, err := se.decide(ctx, text, candidates, thresholds, verifier, verifierName)
+	se.account(decision, text, namespace)
+	return decision, err
+}
+
+// Explain returns the topK closest candidates for text with their stored
+// prompts, and the decision Lookup would reach. The verifier is only called
+// when verify is true; nothing is written to the store.
+func (se *SemanticEngine) Explain(ctx context.Context, text string, topK int, verify bool) (*Explanation, error) {
+	thresholds := se.Thresholds()
+	se.mu.RLock()
+	verifier := se.Verifier
+	verifierName := se.currentVerifierName
+	se.mu.RUnlock()
+
+	queryEmb, namespace, err := se.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := se.rank(ctx, queryEmb, namespace, topK, thresholds)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		if prompt, err := se.Store.GetPrompt(ctx, HashFromKey(candidates[i].Key)); err == nil {
+			candidates[i].Prompt = prompt
+		}
+	}
+
+	if !verify {
+		verifier = nil
+	}
+
+	decision, err := se.decide(ctx, text, candidates, thresholds, verifier, verifierName)
+	se.account(decision, text, namespace)
+	if err != nil && decision == nil {
+		return nil, err
+	}
+
+	return &Explanation{Candidates: candidates, Decision: decision}, nil
+}
+
+// Neighborhood returns every stored vector scoring at least threshold
+// against a query, best first, using the same search as Lookup. The query
+// is queryEmb in namespace, or an empty namespace for the primary provider;
+// without queryEmb, text is embedded like a lookup would. It also returns
+// the namespace searched.
+func (se *SemanticEngine) Neighborhood(ctx context.Context, text string, queryEmb []float32, namespace string, threshold float32) ([]Candidate, string, error) {
+	if queryEmb == nil {
+		var err error
+		if queryEmb, namespace, err = se.Embed(ctx, text); err != nil {
+			return nil, "", err
+		}
+	} else if namespace == "" {
+		namespace = se.GetCurrentProvider()
+	}
+
+	candidates, err := se.rank(ctx, queryEmb, namespace, 0, se.Thresholds())
+	if err != nil {
+		return nil, namespace, err
+	}
+	// Candidates are sorted, so the neighborhood is a prefix
+	n := sort.Search(len(candidates), func(i int) bool { return candidates[i].Score < threshold })
+	return candidates[:n], namespace, nil
+}
+
+// account records the query namespace and the embedding cost on decision
+func (se *SemanticEngine) account(decision *Decision, text, namespace string) {
+	if decision == nil {
+		return
+	}
+	decision.Namespace = namespace
+	decision.Cost += se.Budgets().Cost(namespace, CallEmbed, EstimateTokens(text))
+}
+
+// vectors returns the stored vectors of namespace by embedding key. Legacy
+// keys without a namespace belong to the primary provider.
+func (se *SemanticEngine) vectors(ctx context.Context, namespace string) (map[string][]float32, error) {
+	primary := se.GetCurrentProvider()
+	inNamespace := func(key string) bool {
+		keyNamespace, _ := ParseEmbeddingKey(key)
+		if keyNamespace == "" {
+			keyNamespace = primary
+		}
+		return keyNamespace == namespace
 	}
 
-	bestKey := ""
-	bestSim := float32(0)
+	if vs, ok := se.Store.(VectorStorage); ok {
+		all, err := vs.GetAllVectors(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for key := range all {
+			if !inNamespace(key) {
+				delete(all, key)
+			}
+		}
+		return all, nil
+	}
 
+	stored, err := se.Store.GetAllEmbeddings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make(map[string][]float32)
 	for key, embBytes := range stored {
-		embVec := BytesToFloat32(embBytes)
+		if inNamespace(key) {
+			vectors[key] = BytesToFloat32(embBytes)
+		}
+	}
+	return vectors, nil
+}
+
+// rank scores the stored embeddings of namespace against queryEmb and
+// returns the topK best candidates in descending order, zoned by thresholds.
+// topK <= 0 returns all of them.
+func (se *SemanticEngine) rank(ctx context.Context, queryEmb []float32, namespace string, topK int, thresholds Thresholds) ([]Candidate, error) {
+	stored, err := se.vectors(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]Candidate, 0, len(stored))
+	for key, embVec := range stored {
+		if len(embVec) != len(queryEmb) {
+			// Vectors of another model cannot be compared
+			continue
+		}
 		sim := CosineSimilarity(queryEmb, embVec)
 
-		if sim > bestSim {
-			bestSim = sim
-			bestKey = key
+		// The common case only needs the single best candidate
+		if topK == 1 {
+			if len(candidates) == 0 {
+				candidates = append(candidates, Candidate{Key: key, Score: sim})
+			} else if sim > candidates[0].Score {
+				candidates[0] = Candidate{Key: key, Score: sim}
+			}
+			continue
 		}
+		candidates = append(candidates, Candidate{Key: key, Score: sim})
 	}
 
-	// 1. Clear Match
-	if bestSim >= se.HighThreshold {
-		return bestKey, bestSim, nil
+	if topK != 1 {
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Score > candidates[j].Score
+		})
+		if topK > 0 && len(candidates) > topK {
+			candidates = candidates[:topK]
+		}
+	}
+
+	for i := range candidates {
+		candidates[i].Zone = thresholds.zone(candidates[i].Score)
+	}
+
+	return candidates, nil
+}
+
+// decide applies the dual-threshold decision to the best ranked candidate.
+// A nil verifier skips gray zone verification.
+func (se *SemanticEngine) decide(ctx context.Context, text string, candidates []Candidate, thresholds Thresholds, verifier Verifier, verifierName string) (*Decision, error) {
+	if len(candidates) == 0 {
+		return &Decision{Zone: ZoneNone, Reason: ReasonNoCandidates}, nil
 	}
 
+	best := candidates[0]
+	decision := &Decision{
+		BestKey: best.Key,
+		Score:   best.Score,
+		Zone:    best.Zone,
+	}
+
+	switch best.Zone {
+	// 1. Clear Match
+	case ZoneHigh:
+		decision.Key = best.Key
+		decision.Reason = ReasonHighScore
+		return decision, nil
+
 	// 2. Clear Mismatch
-	if bestSim < se.LowThreshold {
-		return "", bestSim, nil
+	case ZoneLow:
+		decision.Reason = ReasonLowScore
+		return decision, nil
 	}
 
 	// 3. Gray Zone -> Smart Verification (if enabled)
-	if !se.EnableGrayZoneVerifier {
+	if !thresholds.GrayZoneVerifier {
 		// Gray zone verification disabled, treat as miss
-		return "", bestSim, nil
+		decision.Reason = ReasonVerifierDisabled
+		return decision, nil
 	}
 
-	// The key in storage has "emb:" prefix, we need to strip it to get the hash
-	hashKey := strings.TrimPrefix(bestKey, "emb:")
+	// The key in storage has the "emb:<namespace>:" prefix, we need to strip it to get the hash
+	hashKey := HashFromKey(best.Key)
 
 	originalPrompt, err := se.Store.GetPrompt(ctx, hashKey)
 	if err != nil {
 		// If we can't find the prompt, we can't verify, so we assume miss to be safe
-		return "", bestSim, nil
+		decision.Reason = ReasonPromptMissing
+		return decision, nil
+	}
+
+	if verifier == nil {
+		decision.Reason = ReasonVerifierSkipped
+		return decision, nil
 	}
 
-	isMatch, err := verifier.CheckSimilarity(ctx, text, originalPrompt)
+	budgets := se.Budgets()
+	tokens := EstimateTokens(text) + EstimateTokens(originalPrompt) + verifierOverheadTokens
+	if err := budgets.Reserve(verifierName, CallVerify, tokens); err != nil {
+		decision.Reason = ReasonBudgetExhausted
+		if budgets.Policy() == PolicySkipVerification {
+			return decision, nil
+		}
+		return decision, err
+	}
+
+	verifyCtx, cancel := httpclient.WithTimeout(ctx, se.verifyTimeout)
+	isMatch, err := verifier.CheckSimilarity(verifyCtx, text, originalPrompt)
+	cancel()
+	decision.Cost += budgets.Cost(verifierName, CallVerify, tokens)
 	if err != nil {
-		return "", bestSim, err
+		decision.Reason = ReasonVerifierError
+		return decision, err
 	}
+	decision.Verified = &isMatch
 
 	if isMatch {
-		return bestKey, bestSim, nil
+		decision.Key = best.Key
+		decision.Reason = ReasonVerifierAccepted
+	} else {
+		decision.Reason = ReasonVerifierRejected
 	}
 
-	return "", bestSim, nil
+	return decision, nil
 }
@@ -2,8 +2,10 @@ package semantic
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 )
 
 // MockProvider implements EmbeddingProvider
@@ -29,6 +31,9 @@ func (m *MockStorage) GetPrompt(ctx context.Context, key string) (string, error)
 }
 
 func (m *MockStorage) Set(ctx context.Context, key string, value []byte) error { return nil }
+func (m *MockStorage) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return nil
+}
 func (m *MockStorage) Get(ctx context.Context, key string) ([]byte, error)     { return nil, nil }
 func (m *MockStorage) Delete(ctx context.Context, key string) error            { return nil }
 func (m *MockStorage) Close()                                                  {}
@@ -423,3 +428,246 @@ func TestSetProvider_ThreadSafety(t *testing.T) {
 
 
 
+func TestLookup_Zones(t *testing.T) {
+	queryVec := []float32{1, 0, 0}
+
+	tests := []struct {
+		name     string
+		stored   []float32
+		match    bool
+		zone     Zone
+		hit      bool
+		verified bool
+	}{
+		{name: "high", stored: []float32{0.99, 0.01, 0}, zone: ZoneHigh, hit: true},
+		{name: "gray verified", stored: []float32{0.85, 0.5, 0.1}, match: true, zone: ZoneGray, hit: true, verified: true},
+		{name: "gray rejected", stored: []float32{0.85, 0.5, 0.1}, match: false, zone: ZoneGray, hit: false, verified: true},
+		{name: "low", stored: []float32{0, 1, 0}, zone: ZoneLow, hit: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &MockStorage{
+				embeddings: map[string][]byte{"emb:candidate": Float32ToBytes(tt.stored)},
+			}
+			config := &Config{HighThreshold: 0.95, LowThreshold: 0.80, EnableGrayZoneVerifier: true}
+			engine := NewSemanticEngine(&MockProvider{embedding: queryVec}, store, &MockVerifier{match: tt.match}, config)
+
+			decision, err := engine.Lookup(context.Background(), "query")
+			if err != nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+			if decision.Zone != tt.zone {
+				t.Errorf("Expected zone %s, got %s", tt.zone, decision.Zone)
+			}
+			if decision.Hit() != tt.hit {
+				t.Errorf("Expected hit %v, got %v", tt.hit, decision.Hit())
+			}
+			if decision.BestKey != "emb:candidate" {
+				t.Errorf("Expected best key 'emb:candidate' even on a miss, got '%s'", decision.BestKey)
+			}
+			if (decision.Verified != nil) != tt.verified {
+				t.Errorf("Expected verifier consulted=%v", tt.verified)
+			}
+		})
+	}
+}
+
+func TestSetThresholds(t *testing.T) {
+	store := &MockStorage{
+		embeddings: map[string][]byte{"emb:candidate": Float32ToBytes([]float32{0.85, 0.5, 0.1})},
+	}
+	config := &Config{HighThreshold: 0.95, LowThreshold: 0.80, EnableGrayZoneVerifier: true}
+	engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, store, &MockVerifier{match: true}, config)
+
+	if err := engine.SetThresholds(Thresholds{High: 0.5, Low: 0.9}); err == nil {
+		t.Error("Expected a high threshold below the low one to be rejected")
+	}
+	if got := engine.Thresholds(); got != (Thresholds{High: 0.95, Low: 0.80, GrayZoneVerifier: true}) {
+		t.Errorf("Expected the thresholds to be kept, got %+v", got)
+	}
+
+	// Lookups after an update use the new thresholds
+	if err := engine.SetThresholds(Thresholds{High: 0.95, Low: 0.90}); err != nil {
+		t.Fatalf("SetThresholds failed: %v", err)
+	}
+	decision, err := engine.Lookup(context.Background(), "query")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if decision.Zone != ZoneLow || decision.Hit() {
+		t.Errorf("Expected a low zone miss, got %+v", decision)
+	}
+
+	if err := engine.SetThresholds(Thresholds{High: 0.85, Low: 0.80}); err != nil {
+		t.Fatalf("SetThresholds failed: %v", err)
+	}
+	if decision, _ := engine.Lookup(context.Background(), "query"); decision.Zone != ZoneHigh || !decision.Hit() {
+		t.Errorf("Expected a high zone hit, got %+v", decision)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	queryVec := []float32{1, 0, 0}
+	store := &MockStorage{
+		embeddings: map[string][]byte{
+			"emb:gray": Float32ToBytes([]float32{0.85, 0.5, 0.1}),
+			"emb:low":  Float32ToBytes([]float32{0.2, 1, 0}),
+			"emb:diff": Float32ToBytes([]float32{0, 1, 0}),
+		},
+	}
+	config := &Config{HighThreshold: 0.95, LowThreshold: 0.10, EnableGrayZoneVerifier: true}
+	engine := NewSemanticEngine(&MockProvider{embedding: queryVec}, store, &MockVerifier{match: true}, config)
+
+	explanation, err := engine.Explain(context.Background(), "query", 2, false)
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+
+	if len(explanation.Candidates) != 2 {
+		t.Fatalf("Expected 2 candidates, got %d", len(explanation.Candidates))
+	}
+	if explanation.Candidates[0].Key != "emb:gray" || explanation.Candidates[1].Key != "emb:low" {
+		t.Errorf("Expected candidates ordered by score, got %s, %s", explanation.Candidates[0].Key, explanation.Candidates[1].Key)
+	}
+	if explanation.Candidates[0].Prompt != "original prompt" {
+		t.Errorf("Expected stored prompt, got '%s'", explanation.Candidates[0].Prompt)
+	}
+	if explanation.Candidates[0].Zone != ZoneGray {
+		t.Errorf("Expected gray zone, got %s", explanation.Candidates[0].Zone)
+	}
+
+	// With verification off, the gray zone candidate is reported but not served
+	if explanation.Decision.Hit() {
+		t.Error("Expected miss when verification is skipped")
+	}
+	if explanation.Decision.Reason != ReasonVerifierSkipped {
+		t.Errorf("Expected reason %s, got %s", ReasonVerifierSkipped, explanation.Decision.Reason)
+	}
+}
+
+func TestSetVerifier(t *testing.T) {
+	t.Setenv("EMBEDDING_PROVIDER", "")
+	t.Setenv("VERIFIER_PROVIDER", "")
+
+	store := &MockStorage{embeddings: make(map[string][]byte)}
+	provider := NewOpenAIProvider()
+	engine := NewSemanticEngine(provider, store, provider, &Config{HighThreshold: 0.70, LowThreshold: 0.30})
+
+	// Without an explicit verifier, switching the provider switches both
+	if err := engine.SetProvider("mistral"); err != nil {
+		t.Fatalf("Failed to switch to mistral: %v", err)
+	}
+	if got := engine.GetCurrentVerifier(); got.Provider != "mistral" || got.Model != mistralVerifierModel {
+		t.Errorf("Expected verifier to follow provider, got %+v", got)
+	}
+
+	// An explicit verifier is kept across provider switches
+	opts := VerifierOptions{Model: "claude-3-5-haiku-latest"}
+	if err := engine.SetVerifier("CLAUDE", opts); err != nil {
+		t.Fatalf("Failed to set verifier: %v", err)
+	}
+	if err := engine.SetProvider("openai"); err != nil {
+		t.Fatalf("Failed to switch to openai: %v", err)
+	}
+
+	got := engine.GetCurrentVerifier()
+	if got.Provider != "claude" {
+		t.Errorf("Expected verifier 'claude', got '%s'", got.Provider)
+	}
+	if got.Model != "claude-3-5-haiku-latest" {
+		t.Errorf("Expected custom model, got '%s'", got.Model)
+	}
+	if got.SystemPrompt != DefaultVerifierSystemPrompt {
+		t.Errorf("Expected default system prompt, got '%s'", got.SystemPrompt)
+	}
+	if engine.GetCurrentProvider() != "openai" {
+		t.Errorf("Expected provider 'openai', got '%s'", engine.GetCurrentProvider())
+	}
+
+	// Invalid templates are rejected and leave the verifier unchanged
+	if err := engine.SetVerifier("openai", VerifierOptions{PromptTemplate: "only {{prompt1}}"}); err == nil {
+		t.Error("Expected error for template without {{prompt2}}")
+	}
+	if err := engine.SetVerifier("invalid", VerifierOptions{}); err == nil {
+		t.Error("Expected error for invalid verifier provider")
+	}
+	if engine.GetCurrentVerifier().Provider != "claude" {
+		t.Errorf("Expected verifier to remain 'claude', got '%s'", engine.GetCurrentVerifier().Provider)
+	}
+}
+
+// vectorStorage serves decoded vectors, like a store with an L1
+type vectorStorage struct {
+	MockStorage
+	vectors map[string][]float32
+}
+
+func (m *vectorStorage) GetAllVectors(ctx context.Context) (map[string][]float32, error) {
+	vectors := make(map[string][]float32, len(m.vectors))
+	for key, vec := range m.vectors {
+		vectors[key] = vec
+	}
+	return vectors, nil
+}
+
+func TestFindSimilar_DecodedVectors(t *testing.T) {
+	store := &vectorStorage{vectors: map[string][]float32{
+		"emb:match":       {0.99, 0.01, 0},
+		"emb:other:match": {1, 0, 0}, // Another namespace
+		"emb:diff":        {0, 1, 0},
+	}}
+	config := &Config{HighThreshold: 0.95, LowThreshold: 0.80}
+	engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, store, nil, config)
+
+	candidates, err := engine.rank(context.Background(), []float32{1, 0, 0}, engine.GetCurrentProvider(), 0, engine.Thresholds())
+	if err != nil {
+		t.Fatalf("rank failed: %v", err)
+	}
+	if len(candidates) != 2 || candidates[0].Key != "emb:match" {
+		t.Errorf("Expected the decoded vectors of the primary namespace, got %+v", candidates)
+	}
+}
+
+func TestNeighborhood(t *testing.T) {
+	store := &MockStorage{
+		embeddings: map[string][]byte{
+			"emb:same":        Float32ToBytes([]float32{1, 0, 0}),
+			"emb:close":       Float32ToBytes([]float32{0.95, 0.3, 0}),
+			"emb:far":         Float32ToBytes([]float32{0, 1, 0}),
+			"emb:other:close": Float32ToBytes([]float32{1, 0, 0}),
+		},
+	}
+	config := &Config{HighThreshold: 0.95, LowThreshold: 0.80}
+	engine := NewSemanticEngine(&MockProvider{embedding: []float32{1, 0, 0}}, store, nil, config)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		embedding []float32
+		namespace string
+		threshold float32
+		want      []string
+	}{
+		{name: "prompt", threshold: 0.9, want: []string{"emb:same", "emb:close"}},
+		{name: "strict", threshold: 0.99, want: []string{"emb:same"}},
+		{name: "embedding", embedding: []float32{0, 1, 0}, threshold: 0.9, want: []string{"emb:far"}},
+		{name: "namespace", embedding: []float32{1, 0, 0}, namespace: "other", threshold: 0.9, want: []string{"emb:other:close"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, _, err := engine.Neighborhood(ctx, "query", tt.embedding, tt.namespace, tt.threshold)
+			if err != nil {
+				t.Fatalf("Neighborhood failed: %v", err)
+			}
+			var keys []string
+			for _, c := range candidates {
+				keys = append(keys, c.Key)
+			}
+			if fmt.Sprint(keys) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, keys)
+			}
+		})
+	}
+}
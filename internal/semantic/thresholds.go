@@ -0,0 +1,54 @@
+package semantic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Thresholds configure the dual-threshold decision. A snapshot is swapped as
+// a whole, so a lookup never mixes values from two updates.
+type Thresholds struct {
+	High             float32 `json:"high_threshold"`     // Scores at or above are a hit
+	Low              float32 `json:"low_threshold"`      // Scores below are a miss
+	GrayZoneVerifier bool    `json:"gray_zone_verifier"` // Verify scores in between, or treat them as a miss
+}
+
+// Validate checks that both thresholds are in (0, 1] and high is above low
+func (t Thresholds) Validate() error {
+	if t.High <= 0 || t.High > 1 {
+		return fmt.Errorf("high_threshold must be greater than 0 and at most 1, got %g", t.High)
+	}
+	if t.Low <= 0 || t.Low > 1 {
+		return fmt.Errorf("low_threshold must be greater than 0 and at most 1, got %g", t.Low)
+	}
+	if t.High <= t.Low {
+		return errors.New("high_threshold must be greater than low_threshold")
+	}
+	return nil
+}
+
+func (t Thresholds) zone(score float32) Zone {
+	switch {
+	case score >= t.High:
+		return ZoneHigh
+	case score < t.Low:
+		return ZoneLow
+	default:
+		return ZoneGray
+	}
+}
+
+// Thresholds returns the thresholds in effect
+func (se *SemanticEngine) Thresholds() Thresholds {
+	return *se.thresholds.Load()
+}
+
+// SetThresholds replaces the thresholds at runtime. Lookups in flight keep
+// the snapshot they started with.
+func (se *SemanticEngine) SetThresholds(t Thresholds) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	se.thresholds.Store(&t)
+	return nil
+}
@@ -0,0 +1,111 @@
+package semantic
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultVerifierSystemPrompt instructs the judge model how to compare prompts
+	DefaultVerifierSystemPrompt = "You are a semantic judge. Determine if the two user prompts have the exact same intent and meaning. Answer only with 'YES' or 'NO'."
+
+	// DefaultVerifierPromptTemplate formats the two prompts for the judge model.
+	// {{prompt1}} and {{prompt2}} are replaced with the prompts being compared.
+	DefaultVerifierPromptTemplate = "Prompt 1: {{prompt1}}\nPrompt 2: {{prompt2}}"
+)
+
+// VerifierOptions configures the chat model used for gray zone verification.
+// Empty fields fall back to the provider's defaults.
+type VerifierOptions struct {
+	Model          string `json:"model,omitempty"`
+	SystemPrompt   string `json:"system_prompt,omitempty"`
+	PromptTemplate string `json:"prompt_template,omitempty"`
+}
+
+// ConfiguredVerifier is implemented by verifiers that can report the
+// effective options they run with
+type ConfiguredVerifier interface {
+	VerifierOptions() VerifierOptions
+}
+
+// LoadVerifierOptions loads verifier options from environment variables
+func LoadVerifierOptions() VerifierOptions {
+	return VerifierOptions{
+		Model:          os.Getenv("VERIFIER_MODEL"),
+		SystemPrompt:   os.Getenv("VERIFIER_SYSTEM_PROMPT"),
+		PromptTemplate: os.Getenv("VERIFIER_PROMPT_TEMPLATE"),
+	}
+}
+
+// withDefaults fills empty options with the defaults for a provider
+func (o VerifierOptions) withDefaults(model string) VerifierOptions {
+	if o.Model == "" {
+		o.Model = model
+	}
+	if o.SystemPrompt == "" {
+		o.SystemPrompt = DefaultVerifierSystemPrompt
+	}
+	if o.PromptTemplate == "" {
+		o.PromptTemplate = DefaultVerifierPromptTemplate
+	}
+	return o
+}
+
+// render builds the user message sent to the judge model
+func (o VerifierOptions) render(prompt1, prompt2 string) string {
+	return strings.NewReplacer("{{prompt1}}", prompt1, "{{prompt2}}", prompt2).Replace(o.PromptTemplate)
+}
+
+// validate checks that a prompt template references both prompts
+func (o VerifierOptions) validate() error {
+	if o.PromptTemplate == "" {
+		return nil
+	}
+	if !strings.Contains(o.PromptTemplate, "{{prompt1}}") || !strings.Contains(o.PromptTemplate, "{{prompt2}}") {
+		return fmt.Errorf("prompt template must contain {{prompt1}} and {{prompt2}}")
+	}
+	return nil
+}
+
+// verifierProviderName returns the provider used for verification, which
+// follows EMBEDDING_PROVIDER unless VERIFIER_PROVIDER is set
+func verifierProviderName() (string, bool) {
+	if val := os.Getenv("VERIFIER_PROVIDER"); val != "" {
+		return strings.ToLower(val), true
+	}
+	if val := os.Getenv("EMBEDDING_PROVIDER"); val != "" {
+		return strings.ToLower(val), false
+	}
+	return "openai", false
+}
+
+// verifierConfigurable is implemented by providers whose verification
+// options can be changed
+type verifierConfigurable interface {
+	setVerifierOptions(opts VerifierOptions)
+}
+
+// NewVerifier creates a verifier for the named provider with the given options
+func NewVerifier(providerName string, opts VerifierOptions) (Verifier, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	provider, err := NewProviderByName(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	if vc, ok := provider.(verifierConfigurable); ok {
+		vc.setVerifierOptions(opts)
+	}
+	return provider, nil
+}
+
+// NewVerifierProvider creates the verifier configured by the VERIFIER_*
+// environment variables
+func NewVerifierProvider() (Verifier, error) {
+	name, _ := verifierProviderName()
+	return NewVerifier(name, LoadVerifierOptions())
+}
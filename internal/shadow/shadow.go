@@ -0,0 +1,348 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+)
+
+// Config holds configuration for shadow evaluation
+type Config struct {
+	Enabled         bool
+	EnableJudge     bool
+	AnswerThreshold float32 // Minimum answer similarity to count two answers as equivalent
+	BandWidth       float32 // Width of the score bands used for aggregation
+	MaxConcurrent   int     // Evaluations running at once; requests beyond it are not evaluated
+}
+
+// LoadConfig loads shadow mode configuration from environment variables
+func LoadConfig() *Config {
+	config := &Config{
+		Enabled:         false,
+		EnableJudge:     false,
+		AnswerThreshold: 0.90,
+		BandWidth:       0.05,
+		MaxConcurrent:   8,
+	}
+
+	if val := os.Getenv("SHADOW_MODE"); val != "" {
+		config.Enabled = val == "true" || val == "1" || val == "yes"
+	}
+
+	if val := os.Getenv("SHADOW_JUDGE"); val != "" {
+		config.EnableJudge = val == "true" || val == "1" || val == "yes"
+	}
+
+	if val := os.Getenv("SHADOW_ANSWER_THRESHOLD"); val != "" {
+		if f, err := strconv.ParseFloat(val, 32); err == nil && f > 0 && f <= 1.0 {
+			config.AnswerThreshold = float32(f)
+		}
+	}
+
+	if val := os.Getenv("SHADOW_BAND_WIDTH"); val != "" {
+		if f, err := strconv.ParseFloat(val, 32); err == nil && f > 0 && f <= 1.0 {
+			config.BandWidth = float32(f)
+		}
+	}
+
+	if val := os.Getenv("SHADOW_MAX_CONCURRENT"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			config.MaxConcurrent = n
+		}
+	}
+
+	return config
+}
+
+// Engine embeds and judges answers with the providers in effect when an
+// evaluation runs, charging the calls to their budgets.
+// *semantic.SemanticEngine implements it.
+type Engine interface {
+	// Embed returns the vector of text and the namespace it belongs to
+	Embed(ctx context.Context, text string) ([]float32, string, error)
+	Verify(ctx context.Context, text1, text2 string) (bool, error)
+}
+
+// Observation is the comparison of one fresh upstream response with the
+// cached candidate the semantic lookup selected
+type Observation struct {
+	Score            float32       `json:"score"`
+	Zone             semantic.Zone `json:"zone"`
+	WouldHit         bool          `json:"would_hit"`
+	ExactMatch       bool          `json:"exact_match"`
+	AnswerSimilarity *float32      `json:"answer_similarity,omitempty"`
+	JudgeMatch       *bool         `json:"judge_match,omitempty"`
+	Equivalent       bool          `json:"equivalent"`
+}
+
+// BandStats aggregates observations whose score fell into one band
+type BandStats struct {
+	Low             float32 `json:"low"`
+	High            float32 `json:"high"`
+	Requests        int64   `json:"requests"`
+	WouldHit        int64   `json:"would_hit"`
+	Equivalent      int64   `json:"equivalent"`
+	WrongAnswers    int64   `json:"wrong_answers"`
+	MissedHits      int64   `json:"missed_hits"`
+	HitRate         float64 `json:"hit_rate"`
+	WrongAnswerRate float64 `json:"wrong_answer_rate"`
+}
+
+// Report is a snapshot of all shadow observations so far
+type Report struct {
+	Requests        int64       `json:"requests"`
+	NoCandidate     int64       `json:"no_candidate"`
+	Dropped         int64       `json:"dropped"`          // Not evaluated while MaxConcurrent evaluations ran
+	BudgetExhausted int64       `json:"budget_exhausted"` // Not evaluated for lack of provider budget
+	WouldHit        int64       `json:"would_hit"`
+	WrongAnswers    int64       `json:"wrong_answers"`
+	HitRate         float64     `json:"hit_rate"`
+	WrongAnswerRate float64     `json:"wrong_answer_rate"`
+	Bands           []BandStats `json:"bands"`
+}
+
+// Evaluator compares cached candidates with fresh responses and aggregates
+// the results per threshold band
+type Evaluator struct {
+	engine Engine
+	config *Config
+	slots  chan struct{} // Holds a token per running evaluation
+	wg     sync.WaitGroup
+
+	mu              sync.Mutex
+	noCandidate     int64
+	dropped         int64
+	budgetExhausted int64
+	bands           map[int]*BandStats
+}
+
+// NewEvaluator creates an Evaluator. engine may be nil, in which case only
+// exact matches are detected.
+func NewEvaluator(engine Engine, config *Config) *Evaluator {
+	if config == nil {
+		config = LoadConfig()
+	}
+	return &Evaluator{
+		engine: engine,
+		config: config,
+		slots:  make(chan struct{}, max(config.MaxConcurrent, 1)),
+		bands:  make(map[int]*BandStats),
+	}
+}
+
+// Go runs the evaluation fn in the background unless MaxConcurrent
+// evaluations are running already, in which case it is dropped and counted.
+// It reports whether fn runs.
+func (e *Evaluator) Go(fn func()) bool {
+	select {
+	case e.slots <- struct{}{}:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+		return false
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer func() { <-e.slots }()
+		fn()
+	}()
+	return true
+}
+
+// Wait blocks until every evaluation started by Go has returned
+func (e *Evaluator) Wait() {
+	e.wg.Wait()
+}
+
+// Compare evaluates whether the cached response would have been an acceptable
+// answer in place of the fresh one. It returns an error matching
+// semantic.ErrBudgetExhausted when a provider budget left the answers
+// undecided; such comparisons should not be recorded.
+func (e *Evaluator) Compare(ctx context.Context, decision *semantic.Decision, cached, fresh []byte) (Observation, error) {
+	obs := Observation{
+		Score:    decision.Score,
+		Zone:     decision.Zone,
+		WouldHit: decision.Hit(),
+	}
+
+	if bytes.Equal(cached, fresh) {
+		obs.ExactMatch = true
+		obs.Equivalent = true
+		return obs, nil
+	}
+
+	cachedAnswer := ExtractAnswer(cached)
+	freshAnswer := ExtractAnswer(fresh)
+	if cachedAnswer == freshAnswer {
+		obs.ExactMatch = true
+		obs.Equivalent = true
+		return obs, nil
+	}
+
+	var budgetErr error
+	if e.engine != nil {
+		cachedEmb, cachedNamespace, err := e.engine.Embed(ctx, cachedAnswer)
+		if err == nil {
+			var freshEmb []float32
+			var freshNamespace string
+			freshEmb, freshNamespace, err = e.engine.Embed(ctx, freshAnswer)
+			// Vectors of different providers after a failover are not comparable
+			if err == nil && cachedNamespace == freshNamespace {
+				sim := semantic.CosineSimilarity(cachedEmb, freshEmb)
+				obs.AnswerSimilarity = &sim
+			}
+		}
+		if errors.Is(err, semantic.ErrBudgetExhausted) {
+			budgetErr = err
+		}
+
+		if e.config.EnableJudge {
+			match, err := e.engine.Verify(ctx, cachedAnswer, freshAnswer)
+			if err == nil {
+				obs.JudgeMatch = &match
+			} else if errors.Is(err, semantic.ErrBudgetExhausted) {
+				budgetErr = err
+			}
+		}
+	}
+
+	if obs.JudgeMatch == nil && obs.AnswerSimilarity == nil && budgetErr != nil {
+		return obs, budgetErr
+	}
+
+	// The judge has the final word when it answered, otherwise fall back to
+	// answer similarity
+	switch {
+	case obs.JudgeMatch != nil:
+		obs.Equivalent = *obs.JudgeMatch
+	case obs.AnswerSimilarity != nil:
+		obs.Equivalent = *obs.AnswerSimilarity >= e.config.AnswerThreshold
+	}
+
+	return obs, nil
+}
+
+// Record adds an observation to the aggregated statistics
+func (e *Evaluator) Record(obs Observation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	idx := e.bandIndex(obs.Score)
+	band, ok := e.bands[idx]
+	if !ok {
+		band = &BandStats{
+			Low:  float32(idx) * e.config.BandWidth,
+			High: float32(math.Min(float64(float32(idx+1)*e.config.BandWidth), 1.0)),
+		}
+		e.bands[idx] = band
+	}
+
+	band.Requests++
+	if obs.WouldHit {
+		band.WouldHit++
+	}
+	if obs.Equivalent {
+		band.Equivalent++
+	}
+	if obs.WouldHit && !obs.Equivalent {
+		band.WrongAnswers++
+	}
+	if !obs.WouldHit && obs.Equivalent {
+		band.MissedHits++
+	}
+}
+
+// RecordNoCandidate counts a request for which the store had nothing to compare
+func (e *Evaluator) RecordNoCandidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.noCandidate++
+}
+
+// RecordBudgetExhausted counts a request left unevaluated because a provider
+// budget was exhausted
+func (e *Evaluator) RecordBudgetExhausted() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.budgetExhausted++
+}
+
+// Report returns a snapshot of the aggregated statistics ordered by band
+func (e *Evaluator) Report() Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	report := Report{
+		NoCandidate:     e.noCandidate,
+		Dropped:         e.dropped,
+		BudgetExhausted: e.budgetExhausted,
+		Requests:        e.noCandidate,
+		Bands:           make([]BandStats, 0, len(e.bands)),
+	}
+
+	for _, band := range e.bands {
+		b := *band
+		if b.Requests > 0 {
+			b.HitRate = float64(b.WouldHit) / float64(b.Requests)
+		}
+		if b.WouldHit > 0 {
+			b.WrongAnswerRate = float64(b.WrongAnswers) / float64(b.WouldHit)
+		}
+		report.Requests += b.Requests
+		report.WouldHit += b.WouldHit
+		report.WrongAnswers += b.WrongAnswers
+		report.Bands = append(report.Bands, b)
+	}
+
+	sort.Slice(report.Bands, func(i, j int) bool {
+		return report.Bands[i].Low < report.Bands[j].Low
+	})
+
+	if report.Requests > 0 {
+		report.HitRate = float64(report.WouldHit) / float64(report.Requests)
+	}
+	if report.WouldHit > 0 {
+		report.WrongAnswerRate = float64(report.WrongAnswers) / float64(report.WouldHit)
+	}
+
+	return report
+}
+
+func (e *Evaluator) bandIndex(score float32) int {
+	if score < 0 {
+		score = 0
+	}
+	idx := int(score / e.config.BandWidth)
+	// A perfect score belongs to the last band rather than a band of its own
+	if last := int(math.Ceil(float64(1/e.config.BandWidth))) - 1; idx > last {
+		idx = last
+	}
+	return idx
+}
+
+// ExtractAnswer returns the assistant message of an OpenAI-style chat
+// completion, or the raw body if it cannot be parsed
+func ExtractAnswer(body []byte) string {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Choices) == 0 {
+		return string(body)
+	}
+	return resp.Choices[0].Message.Content
+}
@@ -0,0 +1,179 @@
+package shadow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/messkan/PromptCache/internal/semantic"
+)
+
+// MockEngine returns a fixed embedding per text and a fixed verdict
+type MockEngine struct {
+	embeddings map[string][]float32
+	match      bool
+	exhausted  bool // Every call is refused by the budget
+}
+
+func (m *MockEngine) Embed(ctx context.Context, text string) ([]float32, string, error) {
+	if m.exhausted {
+		return nil, "", &semantic.BudgetError{Provider: "openai", Call: semantic.CallEmbed}
+	}
+	return m.embeddings[text], "openai", nil
+}
+
+func (m *MockEngine) Verify(ctx context.Context, text1, text2 string) (bool, error) {
+	if m.exhausted {
+		return false, &semantic.BudgetError{Provider: "openai", Call: semantic.CallVerify}
+	}
+	return m.match, nil
+}
+
+func chatResponse(content string) []byte {
+	return []byte(`{"choices":[{"message":{"role":"assistant","content":"` + content + `"}}]}`)
+}
+
+func TestExtractAnswer(t *testing.T) {
+	if got := ExtractAnswer(chatResponse("hello")); got != "hello" {
+		t.Errorf("Expected 'hello', got '%s'", got)
+	}
+	if got := ExtractAnswer([]byte("not json")); got != "not json" {
+		t.Errorf("Expected raw body, got '%s'", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	embeddings := map[string][]float32{
+		"Paris":             {1, 0, 0},
+		"It is Paris":       {0.99, 0.05, 0},
+		"Berlin":            {0, 1, 0},
+		"The capital moved": {0, 0, 1},
+	}
+	config := &Config{AnswerThreshold: 0.90, BandWidth: 0.05}
+
+	tests := []struct {
+		name       string
+		match      bool
+		enable     bool
+		cached     string
+		fresh      string
+		exact      bool
+		equivalent bool
+	}{
+		{name: "exact match", cached: "Paris", fresh: "Paris", exact: true, equivalent: true},
+		{name: "similar answers", cached: "Paris", fresh: "It is Paris", equivalent: true},
+		{name: "different answers", cached: "Paris", fresh: "Berlin", equivalent: false},
+		{name: "judge overrides similarity", match: true, enable: true, cached: "Paris", fresh: "Berlin", equivalent: true},
+		{name: "judge ignored when disabled", match: true, enable: false, cached: "Paris", fresh: "Berlin", equivalent: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *config
+			cfg.EnableJudge = tt.enable
+			e := NewEvaluator(&MockEngine{embeddings: embeddings, match: tt.match}, &cfg)
+
+			decision := &semantic.Decision{Key: "emb:a", BestKey: "emb:a", Score: 0.95, Zone: semantic.ZoneHigh}
+			obs, err := e.Compare(context.Background(), decision, chatResponse(tt.cached), chatResponse(tt.fresh))
+			if err != nil {
+				t.Fatalf("Compare failed: %v", err)
+			}
+
+			if obs.ExactMatch != tt.exact {
+				t.Errorf("Expected ExactMatch %v, got %v", tt.exact, obs.ExactMatch)
+			}
+			if obs.Equivalent != tt.equivalent {
+				t.Errorf("Expected Equivalent %v, got %v", tt.equivalent, obs.Equivalent)
+			}
+			if !obs.WouldHit {
+				t.Error("Expected WouldHit for a decision with a key")
+			}
+		})
+	}
+}
+
+func TestCompare_BudgetExhausted(t *testing.T) {
+	e := NewEvaluator(&MockEngine{exhausted: true}, &Config{EnableJudge: true, AnswerThreshold: 0.90, BandWidth: 0.05})
+	decision := &semantic.Decision{BestKey: "emb:a", Score: 0.95, Zone: semantic.ZoneHigh}
+
+	// Exact matches need no provider call
+	if _, err := e.Compare(context.Background(), decision, chatResponse("Paris"), chatResponse("Paris")); err != nil {
+		t.Errorf("Expected an exact match despite the budget, got %v", err)
+	}
+
+	_, err := e.Compare(context.Background(), decision, chatResponse("Paris"), chatResponse("Berlin"))
+	if !errors.Is(err, semantic.ErrBudgetExhausted) {
+		t.Fatalf("Expected a budget error, got %v", err)
+	}
+	e.RecordBudgetExhausted()
+	if report := e.Report(); report.BudgetExhausted != 1 || report.Requests != 0 {
+		t.Errorf("Expected the comparison to be skipped, got %+v", report)
+	}
+}
+
+func TestEvaluator_Go(t *testing.T) {
+	e := NewEvaluator(nil, &Config{BandWidth: 0.05, MaxConcurrent: 2})
+
+	release := make(chan struct{})
+	var running sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		running.Add(1)
+		if !e.Go(func() { running.Done(); <-release }) {
+			t.Fatal("Expected the evaluation to start")
+		}
+	}
+	running.Wait()
+
+	if e.Go(func() {}) {
+		t.Error("Expected the evaluation to be dropped at the limit")
+	}
+	close(release)
+
+	// Wait returns once the running evaluations are done
+	e.Wait()
+	if !e.Go(func() {}) {
+		t.Error("Expected a free slot once the evaluations returned")
+	}
+	e.Wait()
+
+	if report := e.Report(); report.Dropped != 1 {
+		t.Errorf("Expected 1 dropped evaluation, got %d", report.Dropped)
+	}
+}
+
+func TestReport(t *testing.T) {
+	e := NewEvaluator(nil, &Config{AnswerThreshold: 0.90, BandWidth: 0.10})
+
+	e.Record(Observation{Score: 0.95, WouldHit: true, Equivalent: true})
+	e.Record(Observation{Score: 0.92, WouldHit: true, Equivalent: false})
+	e.Record(Observation{Score: 1.0, WouldHit: true, Equivalent: true})
+	e.Record(Observation{Score: 0.55, WouldHit: false, Equivalent: true})
+	e.RecordNoCandidate()
+
+	report := e.Report()
+
+	if report.Requests != 5 {
+		t.Errorf("Expected 5 requests, got %d", report.Requests)
+	}
+	if report.WouldHit != 3 {
+		t.Errorf("Expected 3 would-hits, got %d", report.WouldHit)
+	}
+	if report.WrongAnswers != 1 {
+		t.Errorf("Expected 1 wrong answer, got %d", report.WrongAnswers)
+	}
+	if len(report.Bands) != 2 {
+		t.Fatalf("Expected 2 bands, got %d", len(report.Bands))
+	}
+
+	low, high := report.Bands[0], report.Bands[1]
+	if low.MissedHits != 1 {
+		t.Errorf("Expected 1 missed hit in low band, got %d", low.MissedHits)
+	}
+	if high.Requests != 3 {
+		t.Errorf("Expected perfect score to share the top band, got %d requests", high.Requests)
+	}
+	if high.WrongAnswerRate < 0.33 || high.WrongAnswerRate > 0.34 {
+		t.Errorf("Expected wrong answer rate ~0.33, got %f", high.WrongAnswerRate)
+	}
+}
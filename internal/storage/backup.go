@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/dgraph-io/badger/v4/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Backuper is implemented by backends that can be backed up while serving
+type Backuper interface {
+	// Backup writes every record written after version since to w, all of
+	// them for 0, and returns the since of the next incremental backup
+	Backup(w io.Writer, since uint64) (uint64, error)
+
+	// Restore loads a backup written by Backup, overwriting existing keys
+	Restore(r io.Reader) error
+}
+
+// maxPendingRestoreWrites bounds the memory a restore uses for batching
+const maxPendingRestoreWrites = 256
+
+// Backup streams Badger's backup format, a consistent snapshot taken without
+// blocking writes. Badger skips versions up to and including since, so the
+// last version dumped is the next since.
+func (s *BadgerStore) Backup(w io.Writer, since uint64) (uint64, error) {
+	s.txnMu.RLock()
+	defer s.txnMu.RUnlock()
+
+	version, err := s.db.Backup(w, since)
+	if err != nil {
+		return 0, err
+	}
+	// Nothing newer was written; keep the position
+	return max(version, since), nil
+}
+
+// BackupValue returns the latest value of key in a backup written by
+// BadgerStore.Backup without loading it, or nil if the backup does not hold
+// the key or deletes it
+func BackupValue(r io.Reader, key string) ([]byte, error) {
+	br := bufio.NewReaderSize(r, 16<<10)
+	var value []byte
+	var buf bytes.Buffer
+	var version uint64
+	for {
+		// The format is a series of KV lists, each after its size
+		var size uint64
+		err := binary.Read(br, binary.LittleEndian, &size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup: %w", err)
+		}
+		if size > math.MaxInt64 {
+			return nil, fmt.Errorf("invalid backup: list of %d bytes", size)
+		}
+		// The buffer grows with the data read, so a corrupt size cannot
+		// allocate more than the backup holds
+		buf.Reset()
+		if _, err := io.CopyN(&buf, br, int64(size)); err != nil {
+			return nil, fmt.Errorf("invalid backup: %w", err)
+		}
+
+		var list pb.KVList
+		if err := proto.Unmarshal(buf.Bytes(), &list); err != nil {
+			return nil, fmt.Errorf("invalid backup: %w", err)
+		}
+		for _, kv := range list.Kv {
+			if string(kv.Key) == key && kv.Version >= version {
+				value, version = kv.Value, kv.Version
+			}
+		}
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// Restore blocks reads and writes until the backup is loaded
+func (s *BadgerStore) Restore(r io.Reader) error {
+	s.txnMu.Lock()
+	defer s.txnMu.Unlock()
+	return s.db.Load(r, maxPendingRestoreWrites)
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBadgerStore_BackupRestore(t *testing.T) {
+	source, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBadgerStore failed: %v", err)
+	}
+	defer source.Close()
+	ctx := context.Background()
+
+	source.PutEntry(ctx, &Entry{Key: "first", Value: []byte("response"), Prompt: []byte("prompt"), Metadata: EntryMetadata{TTL: time.Hour}})
+
+	var full bytes.Buffer
+	since, err := source.Backup(&full, 0)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	source.Set(ctx, "second", []byte("value"))
+	var incremental bytes.Buffer
+	if _, err := source.Backup(&incremental, since); err != nil {
+		t.Fatalf("Incremental backup failed: %v", err)
+	}
+
+	target, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBadgerStore failed: %v", err)
+	}
+	defer target.Close()
+
+	if err := target.Restore(&full); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	entry, err := target.GetEntry(ctx, "first")
+	if err != nil || string(entry.Prompt) != "prompt" || entry.Metadata.TTL != time.Hour {
+		t.Fatalf("Expected the entry to be restored, got %+v, %v", entry, err)
+	}
+	if got, _ := target.Get(ctx, "second"); got != nil {
+		t.Error("Expected the full backup to predate the second key")
+	}
+
+	if err := target.Restore(&incremental); err != nil {
+		t.Fatalf("Incremental restore failed: %v", err)
+	}
+	if got, _ := target.Get(ctx, "second"); string(got) != "value" {
+		t.Errorf("Expected the incremental backup to add the second key, got %q", got)
+	}
+}
+
+func TestBackupValue(t *testing.T) {
+	store, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBadgerStore failed: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	store.Set(ctx, "marker", []byte("old"))
+	store.Set(ctx, "marker", []byte("new"))
+	store.Set(ctx, "deleted", []byte("value"))
+	store.Delete(ctx, "deleted")
+	store.Set(ctx, "other", []byte("value"))
+
+	var backup bytes.Buffer
+	if _, err := store.Backup(&backup, 0); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "marker", want: "new"},
+		{key: "deleted", want: ""},
+		{key: "missing", want: ""},
+	}
+	for _, tt := range tests {
+		got, err := BackupValue(bytes.NewReader(backup.Bytes()), tt.key)
+		if err != nil {
+			t.Fatalf("BackupValue(%s) failed: %v", tt.key, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Expected %s to be %q, got %q", tt.key, tt.want, got)
+		}
+	}
+
+	if _, err := BackupValue(bytes.NewReader([]byte("not a backup")), "marker"); err == nil {
+		t.Error("Expected an error for an invalid backup")
+	}
+}
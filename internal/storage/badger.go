@@ -2,33 +2,172 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
 )
 
+// BadgerOptions configures a BadgerStore. Zero sizes keep Badger's defaults.
+type BadgerOptions struct {
+	Path           string // Data directory; ignored in memory
+	InMemory       bool   // Keep everything in memory; nothing survives a restart
+	SyncWrites     bool   // Sync every write to disk before acknowledging it
+	Compression    string // none, snappy or zstd
+	BlockCacheSize int64
+	IndexCacheSize int64
+	MemTableSize   int64
+	LogLevel       string        // debug, info, warning, error or off
+	GCInterval     time.Duration // How often value-log GC runs; 0 disables the loop
+	GCDiscardRatio float64       // Share of a value-log file that must be reclaimable to rewrite it
+}
+
+// DefaultBadgerOptions returns the options used for a data directory at path
+func DefaultBadgerOptions(path string) BadgerOptions {
+	return BadgerOptions{
+		Path:           path,
+		Compression:    "snappy",
+		LogLevel:       "warning",
+		GCInterval:     5 * time.Minute,
+		GCDiscardRatio: 0.5,
+	}
+}
+
+// badgerOptions translates opts into Badger's own options
+func badgerOptions(opts BadgerOptions) (badger.Options, error) {
+	bopts := badger.DefaultOptions(opts.Path)
+	if opts.InMemory {
+		bopts = badger.DefaultOptions("").WithInMemory(true)
+	}
+	bopts = bopts.WithSyncWrites(opts.SyncWrites)
+
+	switch strings.ToLower(opts.Compression) {
+	case "", "snappy":
+		bopts = bopts.WithCompression(options.Snappy)
+	case "none":
+		bopts = bopts.WithCompression(options.None)
+	case "zstd":
+		bopts = bopts.WithCompression(options.ZSTD)
+	default:
+		return bopts, fmt.Errorf("unsupported Badger compression: %s (supported: none, snappy, zstd)", opts.Compression)
+	}
+
+	if opts.BlockCacheSize > 0 {
+		bopts = bopts.WithBlockCacheSize(opts.BlockCacheSize)
+	}
+	if opts.IndexCacheSize > 0 {
+		bopts = bopts.WithIndexCacheSize(opts.IndexCacheSize)
+	}
+	if opts.MemTableSize > 0 {
+		bopts = bopts.WithMemTableSize(opts.MemTableSize)
+	}
+
+	switch strings.ToLower(opts.LogLevel) {
+	case "debug":
+		bopts = bopts.WithLoggingLevel(badger.DEBUG)
+	case "info":
+		bopts = bopts.WithLoggingLevel(badger.INFO)
+	case "", "warning":
+		bopts = bopts.WithLoggingLevel(badger.WARNING)
+	case "error":
+		bopts = bopts.WithLoggingLevel(badger.ERROR)
+	case "off":
+		bopts = bopts.WithLogger(nil)
+	default:
+		return bopts, fmt.Errorf("unsupported Badger log level: %s (supported: debug, info, warning, error, off)", opts.LogLevel)
+	}
+
+	return bopts, nil
+}
+
+// BadgerStats reports the on-disk size and value-log GC activity of a BadgerStore
+type BadgerStats struct {
+	LSMSize     int64      `json:"lsm_size"`  // Bytes of LSM tree tables
+	VLogSize    int64      `json:"vlog_size"` // Bytes of value-log files
+	GCRuns      int64      `json:"gc_runs"`
+	GCRewrites  int64      `json:"gc_rewrites"` // Value-log files rewritten to reclaim space
+	LastGC      *time.Time `json:"last_gc,omitempty"`
+	LastGCError string     `json:"last_gc_error,omitempty"`
+}
+
 type BadgerStore struct {
-	db *badger.DB
+	db   *badger.DB
+	opts BadgerOptions
+
+	// Restore holds txnMu exclusively, as Badger loads must not overlap
+	// other transactions
+	txnMu sync.RWMutex
+
+	mu    sync.Mutex
+	stats BadgerStats
+	stop  chan struct{}
+	done  chan struct{}
 }
 
+// NewBadgerStore opens a store in path with the default options
 func NewBadgerStore(path string) (*BadgerStore, error) {
-	opts := badger.DefaultOptions(path)
-	db, err := badger.Open(opts)
+	return NewBadgerStoreWithOptions(DefaultBadgerOptions(path))
+}
+
+// NewBadgerStoreWithOptions opens a store and starts its value-log GC loop
+func NewBadgerStoreWithOptions(opts BadgerOptions) (*BadgerStore, error) {
+	bopts, err := badgerOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	db, err := badger.Open(bopts)
 	if err != nil {
 		return nil, err
 	}
-	return &BadgerStore{db: db}, nil
+
+	// An in-memory database has no value log to collect
+	s := &BadgerStore{db: db, opts: opts}
+	if opts.GCInterval > 0 && !opts.InMemory {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.runGC(opts.GCInterval)
+	}
+	return s, nil
+}
+
+func (s *BadgerStore) update(fn func(txn *badger.Txn) error) error {
+	s.txnMu.RLock()
+	defer s.txnMu.RUnlock()
+	return s.db.Update(fn)
+}
+
+func (s *BadgerStore) view(fn func(txn *badger.Txn) error) error {
+	s.txnMu.RLock()
+	defer s.txnMu.RUnlock()
+	return s.db.View(fn)
 }
 
 func (s *BadgerStore) Set(ctx context.Context, key string, value []byte) error {
-	return s.db.Update(func(txn *badger.Txn) error {
+	return s.update(func(txn *badger.Txn) error {
 		return txn.Set([]byte(key), value)
 	})
 }
 
+func (s *BadgerStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return s.Set(ctx, key, value)
+	}
+	return s.update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry([]byte(key), value).WithTTL(ttl))
+	})
+}
+
 func (s *BadgerStore) Get(ctx context.Context, key string) ([]byte, error) {
 	var valCopy []byte
-	err := s.db.View(func(txn *badger.Txn) error {
+	err := s.view(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(key))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -39,40 +178,100 @@ func (s *BadgerStore) Get(ctx context.Context, key string) ([]byte, error) {
 }
 
 func (s *BadgerStore) Delete(ctx context.Context, key string) error {
-	return s.db.Update(func(txn *badger.Txn) error {
+	return s.update(func(txn *badger.Txn) error {
 		return txn.Delete([]byte(key))
 	})
 }
 
+func (s *BadgerStore) DeleteBatch(ctx context.Context, keys []string) error {
+	return s.update(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			if err := txn.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *BadgerStore) GetAllEmbeddings(ctx context.Context) (map[string][]byte, error) {
-	results := make(map[string][]byte)
-	err := s.db.View(func(txn *badger.Txn) error {
+	stored, err := s.GetAllEmbeddingsWithExpiry(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return withoutExpiry(stored), nil
+}
+
+// GetAllEmbeddingsWithExpiry reads the expiry Badger keeps with every item
+func (s *BadgerStore) GetAllEmbeddingsWithExpiry(ctx context.Context) (map[string]ExpiringValue, error) {
+	results := make(map[string]ExpiringValue)
+	err := s.view(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchSize = 10
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		prefix := []byte("emb:")
+		prefix := []byte(EmbeddingPrefix)
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
-			k := item.Key()
-			err := item.Value(func(v []byte) error {
-				results[string(k)] = append([]byte{}, v...)
-				return nil
-			})
+			var expiresAt time.Time
+			if at := item.ExpiresAt(); at > 0 {
+				expiresAt = time.Unix(int64(at), 0)
+			}
+			value, err := item.ValueCopy(nil)
 			if err != nil {
 				return err
 			}
+			results[string(item.Key())] = ExpiringValue{Value: value, ExpiresAt: expiresAt}
 		}
 		return nil
 	})
 	return results, err
 }
 
+func (s *BadgerStore) Scan(ctx context.Context, prefix, cursor string, limit int) ([]KV, string, error) {
+	limit = scanLimit(limit)
+	var items []KV
+	next := ""
+
+	err := s.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+		opts.PrefetchSize = min(limit, 100)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		start := prefix
+		if cursor != "" {
+			start = cursor
+		}
+		for it.Seek([]byte(start)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+			key := string(it.Item().Key())
+			if key == cursor {
+				continue
+			}
+			if len(items) == limit {
+				next = items[len(items)-1].Key
+				break
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			items = append(items, KV{Key: key, Value: value})
+		}
+		return nil
+	})
+	return items, next, err
+}
+
 func (s *BadgerStore) GetPrompt(ctx context.Context, key string) (string, error) {
 	var valCopy []byte
-	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("prompt:" + key))
+	err := s.view(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(PromptPrefix + key))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
 		if err != nil {
 			return err
 		}
@@ -82,6 +281,237 @@ func (s *BadgerStore) GetPrompt(ctx context.Context, key string) (string, error)
 	return string(valCopy), err
 }
 
+// TTL returns how long the record under key has left to live, or zero if it
+// never expires. Badger expiry has a resolution of one second.
+func (s *BadgerStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.view(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if expiresAt := item.ExpiresAt(); expiresAt > 0 {
+			ttl = max(time.Until(time.Unix(int64(expiresAt), 0)), time.Millisecond)
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+// PutEntry writes every record of entry in one transaction
+func (s *BadgerStore) PutEntry(ctx context.Context, entry *Entry) error {
+	records, err := entry.records()
+	if err != nil {
+		return err
+	}
+
+	ttl := entry.ttl(time.Now())
+	for {
+		err := s.update(func(txn *badger.Txn) error {
+			// Reading the previous metadata makes a concurrent rewrite conflict
+			var previous []byte
+			item, err := txn.Get([]byte(MetaPrefix + entry.Key))
+			switch {
+			case err == nil:
+				if previous, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+			case err != badger.ErrKeyNotFound:
+				return err
+			}
+			for _, key := range staleTagKeys(entry.Key, previous, entry.Metadata.Tags) {
+				if err := txn.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+
+			for key, value := range records {
+				e := badger.NewEntry([]byte(key), value)
+				if ttl > 0 {
+					e = e.WithTTL(ttl)
+				}
+				if err := txn.SetEntry(e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// GetEntry reads every record of the entry under key from one snapshot
+func (s *BadgerStore) GetEntry(ctx context.Context, key string) (*Entry, error) {
+	var entry *Entry
+	err := s.view(func(txn *badger.Txn) error {
+		get := func(key string) ([]byte, error) {
+			item, err := txn.Get([]byte(key))
+			if err == badger.ErrKeyNotFound {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return item.ValueCopy(nil)
+		}
+
+		var err error
+		entry, err = readEntry(key, get)
+		return err
+	})
+	return entry, err
+}
+
+// RecordHit increments the hit count in a transaction, retrying when a
+// concurrent hit on the same entry conflicts
+func (s *BadgerStore) RecordHit(ctx context.Context, key string) error {
+	for {
+		err := s.update(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte(MetaPrefix + key))
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			data, err = addHit(data)
+			if err != nil {
+				return err
+			}
+
+			e := badger.NewEntry([]byte(MetaPrefix+key), data)
+			e.ExpiresAt = item.ExpiresAt()
+			return txn.SetEntry(e)
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Update replaces the value under key with the result of fn in a
+// transaction, retrying when a concurrent update conflicts. The remaining TTL
+// is kept.
+func (s *BadgerStore) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	for {
+		err := s.update(func(txn *badger.Txn) error {
+			var value []byte
+			var expiresAt uint64
+			item, err := txn.Get([]byte(key))
+			switch {
+			case err == nil:
+				if value, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+				expiresAt = item.ExpiresAt()
+			case err != badger.ErrKeyNotFound:
+				return err
+			}
+
+			if value, err = fn(value); err != nil {
+				return err
+			}
+			e := badger.NewEntry([]byte(key), value)
+			e.ExpiresAt = expiresAt
+			return txn.SetEntry(e)
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// CollectGarbage rewrites value log files until no file has at least the
+// discard ratio of its space reclaimable, returning the space of deleted and
+// expired entries
+func (s *BadgerStore) CollectGarbage() error {
+	if s.opts.InMemory {
+		return nil
+	}
+
+	ratio := s.opts.GCDiscardRatio
+	if ratio <= 0 || ratio >= 1 {
+		ratio = 0.5
+	}
+
+	rewrites := int64(0)
+	var err error
+	for {
+		err = s.db.RunValueLogGC(ratio)
+		if err == badger.ErrNoRewrite || err == badger.ErrRejected {
+			err = nil
+			break
+		}
+		if err != nil {
+			break
+		}
+		rewrites++
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.stats.GCRuns++
+	s.stats.GCRewrites += rewrites
+	s.stats.LastGC = &now
+	s.stats.LastGCError = ""
+	if err != nil {
+		s.stats.LastGCError = err.Error()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// runGC collects garbage every interval until Close
+func (s *BadgerStore) runGC(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.CollectGarbage(); err != nil {
+				log.Printf("Badger value-log GC failed: %v", err)
+			}
+		}
+	}
+}
+
+// Stats returns the current LSM and value-log sizes and GC counters
+func (s *BadgerStore) Stats() BadgerStats {
+	s.mu.Lock()
+	stats := s.stats
+	s.mu.Unlock()
+
+	stats.LSMSize, stats.VLogSize = s.db.Size()
+	return stats
+}
+
+// Close stops the GC loop and closes the database
 func (s *BadgerStore) Close() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
 	s.db.Close()
 }
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBadgerStore_TTL(t *testing.T) {
+	store, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBadgerStore failed: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	if err := store.SetWithTTL(ctx, "emb:openai:short", []byte{1}, time.Second); err != nil {
+		t.Fatalf("SetWithTTL failed: %v", err)
+	}
+	store.SetWithTTL(ctx, "emb:openai:long", []byte{2}, time.Hour)
+	store.SetWithTTL(ctx, "prompt:short", []byte("prompt"), time.Second)
+
+	// Badger TTLs have a resolution of one second
+	time.Sleep(2100 * time.Millisecond)
+
+	if got, err := store.Get(ctx, "emb:openai:short"); got != nil || err != nil {
+		t.Errorf("Expected nil, nil for an expired key, got (%v, %v)", got, err)
+	}
+	if _, err := store.GetPrompt(ctx, "short"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired prompt, got %v", err)
+	}
+
+	embeddings, err := store.GetAllEmbeddings(ctx)
+	if err != nil {
+		t.Fatalf("GetAllEmbeddings failed: %v", err)
+	}
+	if len(embeddings) != 1 || embeddings["emb:openai:long"] == nil {
+		t.Errorf("Expected expired vectors to be dropped from the search set, got %v", embeddings)
+	}
+
+	if err := store.CollectGarbage(); err != nil {
+		t.Errorf("CollectGarbage failed: %v", err)
+	}
+}
+
+func TestBadgerOptions(t *testing.T) {
+	opts := DefaultBadgerOptions("/tmp/data")
+	opts.Compression = "zstd"
+	opts.MemTableSize = 16 << 20
+	opts.LogLevel = "off"
+
+	bopts, err := badgerOptions(opts)
+	if err != nil {
+		t.Fatalf("badgerOptions failed: %v", err)
+	}
+	if bopts.Dir != "/tmp/data" || bopts.MemTableSize != 16<<20 || bopts.Logger != nil {
+		t.Errorf("Unexpected options: dir=%s memtable=%d logger=%v", bopts.Dir, bopts.MemTableSize, bopts.Logger)
+	}
+
+	for _, bad := range []BadgerOptions{{Compression: "lz4"}, {LogLevel: "verbose"}} {
+		if _, err := badgerOptions(bad); err == nil {
+			t.Errorf("Expected %+v to fail", bad)
+		}
+	}
+}
+
+func TestBadgerStore_GCStats(t *testing.T) {
+	opts := DefaultBadgerOptions(t.TempDir())
+	opts.GCInterval = 10 * time.Millisecond
+	store, err := NewBadgerStoreWithOptions(opts)
+	if err != nil {
+		t.Fatalf("NewBadgerStoreWithOptions failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set(context.Background(), "key", []byte("value"))
+	time.Sleep(100 * time.Millisecond)
+
+	stats := store.Stats()
+	if stats.GCRuns == 0 || stats.LastGC == nil {
+		t.Errorf("Expected the GC loop to have run, got %+v", stats)
+	}
+	if stats.LastGCError != "" {
+		t.Errorf("Unexpected GC error: %s", stats.LastGCError)
+	}
+}
+
+func TestBadgerStore_InMemory(t *testing.T) {
+	store, err := NewBadgerStoreWithOptions(BadgerOptions{InMemory: true, GCInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewBadgerStoreWithOptions failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Set(context.Background(), "key", []byte("value"))
+	if got, _ := store.Get(context.Background(), "key"); string(got) != "value" {
+		t.Errorf("Expected value, got %q", got)
+	}
+	if err := store.CollectGarbage(); err != nil {
+		t.Errorf("Expected GC to be a no-op in memory, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config selects and configures the storage backend
+type Config struct {
+	Backend     string // badger, redis or memory
+	Badger      BadgerOptions
+	RedisURL    string
+	RedisPrefix string // Prefix of every key written to Redis
+	Memory      MemoryOptions
+	Encryption  EncryptionConfig
+	L1          L1Options
+}
+
+// EncryptionConfig enables encryption at rest when keys are configured
+type EncryptionConfig struct {
+	Keys             string        // version:base64 pairs, see ParseKeys
+	KeyFile          string        // File holding more keys in the same format
+	ActiveVersion    uint32        // Key new values are sealed with; 0 selects the highest
+	RotationInterval time.Duration // How often records under older keys are re-encrypted
+}
+
+// Enabled reports whether any key is configured
+func (c EncryptionConfig) Enabled() bool {
+	return c.Keys != "" || c.KeyFile != ""
+}
+
+// KeyRing parses the configured keys
+func (c EncryptionConfig) KeyRing() (*KeyRing, error) {
+	spec := c.Keys
+	if c.KeyFile != "" {
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption key file: %w", err)
+		}
+		spec += "\n" + string(data)
+	}
+
+	keys, err := ParseKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing(keys, c.ActiveVersion)
+}
+
+// LoadConfig loads the storage configuration from environment variables
+func LoadConfig() *Config {
+	config := &Config{
+		Backend:     "badger",
+		Badger:      DefaultBadgerOptions("./badger_data"),
+		RedisURL:    "redis://localhost:6379/0",
+		RedisPrefix: "promptcache:",
+		Encryption:  EncryptionConfig{RotationInterval: time.Hour},
+		L1:          L1Options{VectorRefresh: time.Minute},
+	}
+
+	if val := os.Getenv("STORAGE_BACKEND"); val != "" {
+		config.Backend = strings.ToLower(val)
+	}
+
+	if val := os.Getenv("STORAGE_PATH"); val != "" {
+		config.Badger.Path = val
+	}
+
+	if val := os.Getenv("BADGER_IN_MEMORY"); val != "" {
+		config.Badger.InMemory = val == "true" || val == "1"
+	}
+
+	if val := os.Getenv("BADGER_SYNC_WRITES"); val != "" {
+		config.Badger.SyncWrites = val == "true" || val == "1"
+	}
+
+	if val := os.Getenv("BADGER_COMPRESSION"); val != "" {
+		config.Badger.Compression = strings.ToLower(val)
+	}
+
+	for env, size := range map[string]*int64{
+		"BADGER_BLOCK_CACHE_SIZE": &config.Badger.BlockCacheSize,
+		"BADGER_INDEX_CACHE_SIZE": &config.Badger.IndexCacheSize,
+		"BADGER_MEMTABLE_SIZE":    &config.Badger.MemTableSize,
+	} {
+		if val := os.Getenv(env); val != "" {
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil && n >= 0 {
+				*size = n
+			}
+		}
+	}
+
+	if val := os.Getenv("BADGER_LOG_LEVEL"); val != "" {
+		config.Badger.LogLevel = strings.ToLower(val)
+	}
+
+	if val := os.Getenv("BADGER_GC_INTERVAL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			config.Badger.GCInterval = d
+		}
+	}
+
+	if val := os.Getenv("BADGER_GC_DISCARD_RATIO"); val != "" {
+		if r, err := strconv.ParseFloat(val, 64); err == nil && r > 0 && r < 1 {
+			config.Badger.GCDiscardRatio = r
+		}
+	}
+
+	if val := os.Getenv("REDIS_URL"); val != "" {
+		config.RedisURL = val
+	}
+
+	if val, ok := os.LookupEnv("REDIS_KEY_PREFIX"); ok {
+		config.RedisPrefix = val
+	}
+
+	if val := os.Getenv("MEMORY_MAX_ENTRIES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			config.Memory.MaxEntries = n
+		}
+	}
+
+	if val := os.Getenv("MEMORY_MAX_BYTES"); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && n >= 0 {
+			config.Memory.MaxBytes = n
+		}
+	}
+
+	config.Memory.SnapshotPath = os.Getenv("MEMORY_SNAPSHOT_PATH")
+
+	config.Encryption.Keys = os.Getenv("ENCRYPTION_KEYS")
+	config.Encryption.KeyFile = os.Getenv("ENCRYPTION_KEY_FILE")
+
+	if val := os.Getenv("ENCRYPTION_ACTIVE_KEY"); val != "" {
+		if n, err := strconv.ParseUint(val, 10, 32); err == nil {
+			config.Encryption.ActiveVersion = uint32(n)
+		}
+	}
+
+	if val := os.Getenv("ENCRYPTION_ROTATION_INTERVAL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			config.Encryption.RotationInterval = d
+		}
+	}
+
+	if val := os.Getenv("CACHE_L1_MAX_BYTES"); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && n >= 0 {
+			config.L1.MaxBytes = n
+		}
+	}
+
+	if val := os.Getenv("CACHE_L1_TTL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			config.L1.TTL = d
+		}
+	}
+
+	if val := os.Getenv("CACHE_L1_VECTOR_REFRESH"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			config.L1.VectorRefresh = d
+		}
+	}
+
+	return config
+}
+
+// Open opens the configured storage backend, wrapped in an EncryptedStore
+// when encryption keys are configured and in a TieredStore when the L1 is.
+// The memory backend is in process already, so it never gets an L1.
+func Open(config *Config) (Storage, error) {
+	// Invalid keys must fail before anything is written in plaintext
+	var ring *KeyRing
+	if config.Encryption.Enabled() {
+		var err error
+		if ring, err = config.Encryption.KeyRing(); err != nil {
+			return nil, fmt.Errorf("invalid encryption keys: %w", err)
+		}
+	}
+
+	store, err := openBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	if ring != nil {
+		store = NewEncryptedStore(store, ring)
+	}
+	if config.L1.MaxBytes > 0 && config.Backend != "memory" {
+		l1 := config.L1
+		if config.Backend == "badger" {
+			// Badger locks its directory, so no other process writes vectors
+			l1.VectorRefresh = 0
+		}
+		tiered, err := NewTieredStore(store, l1)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("invalid L1 cache options: %w", err)
+		}
+		return tiered, nil
+	}
+	return store, nil
+}
+
+func openBackend(config *Config) (Storage, error) {
+	// Avoid returning a typed nil inside the interface on failure
+	switch config.Backend {
+	case "badger":
+		store, err := NewBadgerStoreWithOptions(config.Badger)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "redis":
+		store, err := NewRedisStore(config.RedisURL, config.RedisPrefix)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "memory":
+		store, err := NewMemoryStore(config.Memory)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s (supported: badger, redis, memory)", config.Backend)
+	}
+}
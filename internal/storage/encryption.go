@@ -0,0 +1,589 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sealed values start with encryptedMagic, followed by the version of the key
+// that wrapped the value's data key, the wrapped data key and the value
+// sealed with the data key:
+//
+//	magic(4) | version(4) | nonce(12) | wrapped data key(48) | nonce(12) | ciphertext
+//
+// Rotating keys only rewraps the data key; the sealed value is kept.
+var encryptedMagic = []byte("\x00PCE")
+
+const (
+	dataKeySize    = 32
+	wrappedKeySize = 12 + dataKeySize + 16
+	sealedHeader   = 4 + 4 + wrappedKeySize
+
+	// rotateBatch is the number of records read per scan during rotation
+	rotateBatch = 500
+)
+
+// KeyRing holds versioned AES-256 key-encryption keys. New values are sealed
+// with the active key; values sealed with any key of the ring can be opened.
+type KeyRing struct {
+	keys   map[uint32]cipher.AEAD
+	active uint32
+}
+
+// NewKeyRing creates a key ring from 32-byte keys by version. An active
+// version of 0 selects the highest version.
+func NewKeyRing(keys map[uint32][]byte, active uint32) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key ring has no keys")
+	}
+
+	ring := &KeyRing{keys: make(map[uint32]cipher.AEAD, len(keys))}
+	for version, key := range keys {
+		if version == 0 {
+			return nil, fmt.Errorf("key versions start at 1")
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %d is %d bytes; AES-256 keys are 32 bytes", version, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[version] = aead
+		ring.active = max(ring.active, version)
+	}
+
+	if active != 0 {
+		if _, ok := ring.keys[active]; !ok {
+			return nil, fmt.Errorf("active key %d is not in the key ring", active)
+		}
+		ring.active = active
+	}
+	return ring, nil
+}
+
+// ParseKeys parses keys written as version:base64 pairs separated by commas
+// or newlines, e.g. "1:<key>,2:<key>". Blank lines and lines starting with #
+// are ignored, so a key file can be commented.
+func ParseKeys(spec string) (map[uint32][]byte, error) {
+	keys := make(map[uint32][]byte)
+	for _, line := range strings.Split(spec, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, pair := range strings.Split(line, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			versionStr, encoded, ok := strings.Cut(pair, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid key %q: expected version:base64", pair)
+			}
+			version, err := strconv.ParseUint(versionStr, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key version %q", versionStr)
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("key %d is not valid base64: %w", version, err)
+			}
+			if _, dup := keys[uint32(version)]; dup {
+				return nil, fmt.Errorf("key %d is defined twice", version)
+			}
+			keys[uint32(version)] = key
+		}
+	}
+	return keys, nil
+}
+
+// Active returns the version new values are sealed with
+func (r *KeyRing) Active() uint32 {
+	return r.active
+}
+
+// Versions returns every key version of the ring in ascending order
+func (r *KeyRing) Versions() []uint32 {
+	versions := make([]uint32, 0, len(r.keys))
+	for version := range r.keys {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealedVersion returns the key version of a sealed value, reporting false
+// for values written before encryption was enabled
+func sealedVersion(value []byte) (uint32, bool) {
+	if len(value) < sealedHeader || string(value[:4]) != string(encryptedMagic) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value[4:8]), true
+}
+
+// wrap seals dataKey with the active key into a value header
+func (r *KeyRing) wrap(dataKey []byte) ([]byte, error) {
+	header := make([]byte, 8, sealedHeader)
+	copy(header, encryptedMagic)
+	binary.BigEndian.PutUint32(header[4:8], r.active)
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The header is authenticated so a data key cannot be moved to another
+	// version; Seal does not allow it to overlap its output
+	aad := append([]byte{}, header...)
+	header = append(header, nonce...)
+	return r.keys[r.active].Seal(header, nonce, dataKey, aad), nil
+}
+
+// unwrap returns the data key of a sealed value
+func (r *KeyRing) unwrap(value []byte) ([]byte, error) {
+	version, _ := sealedVersion(value)
+	aead, ok := r.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("value is encrypted with key %d, which is not in the key ring", version)
+	}
+	dataKey, err := aead.Open(nil, value[8:20], value[20:sealedHeader], value[:8])
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// seal encrypts the value of the record under key with a fresh data key. The
+// record key is authenticated, so sealed values cannot be swapped between keys.
+func (r *KeyRing) seal(key string, value []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := r.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, value, []byte(key)), nil
+}
+
+// open decrypts the value of the record under key. Values written before
+// encryption was enabled are returned as they are.
+func (r *KeyRing) open(key string, value []byte) ([]byte, error) {
+	if _, ok := sealedVersion(value); !ok {
+		return value, nil
+	}
+	dataKey, err := r.unwrap(value)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body := value[sealedHeader:]
+	if len(body) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value is truncated")
+	}
+	plain, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	return plain, nil
+}
+
+// rotate returns value sealed with the active key, rewrapping the data key of
+// values sealed with an older key. It returns nil if value is up to date.
+func (r *KeyRing) rotate(key string, value []byte) ([]byte, error) {
+	version, sealed := sealedVersion(value)
+	if !sealed {
+		return r.seal(key, value)
+	}
+	if version == r.active {
+		return nil, nil
+	}
+
+	dataKey, err := r.unwrap(value)
+	if err != nil {
+		return nil, err
+	}
+	header, err := r.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, value[sealedHeader:]...), nil
+}
+
+// ttlReader is implemented by the backends, which report the remaining TTL
+// of a record so rotation can rewrite it without extending its life
+type ttlReader interface {
+	TTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// EncryptionStatus reports the keys in use and the progress of rotation
+type EncryptionStatus struct {
+	Enabled       bool       `json:"enabled"`
+	ActiveVersion uint32     `json:"active_version"`
+	Versions      []uint32   `json:"versions"`
+	Rotating      bool       `json:"rotating"`
+	LastRotation  *time.Time `json:"last_rotation,omitempty"`
+	Reencrypted   int64      `json:"reencrypted"` // Records re-encrypted by the last rotation
+	Failed        int64      `json:"failed"`      // Records the last rotation could not re-encrypt
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// EncryptedStore encrypts the responses and prompts of another store with
+// envelope encryption: every value is sealed with its own AES-256-GCM data
+// key, which is wrapped by the active key of the ring.
+type EncryptedStore struct {
+	store Storage
+	ring  *KeyRing
+
+	// Writes hold rotateMu shared; rotation holds it exclusively while it
+	// rewrites a record, so it never puts back a value that was just replaced
+	rotateMu sync.RWMutex
+
+	mu     sync.Mutex
+	status EncryptionStatus
+}
+
+// NewEncryptedStore wraps store so its responses and prompts are encrypted
+// with ring. Records written before encryption was enabled stay readable
+// until rotation encrypts them.
+func NewEncryptedStore(store Storage, ring *KeyRing) *EncryptedStore {
+	return &EncryptedStore{store: store, ring: ring}
+}
+
+// Unwrap returns the store the encrypted values are written to
+func (s *EncryptedStore) Unwrap() Storage {
+	return s.store
+}
+
+// Base returns the backend under any wrapping stores, e.g. to reach
+// backend features such as backups
+func Base(store Storage) Storage {
+	for {
+		wrapper, ok := store.(interface{ Unwrap() Storage })
+		if !ok {
+			return store
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// Layer returns the first store of type T among store and the stores it
+// wraps, e.g. to reach the EncryptedStore under an L1
+func Layer[T Storage](store Storage) (T, bool) {
+	for {
+		if layer, ok := store.(T); ok {
+			return layer, true
+		}
+		wrapper, ok := store.(interface{ Unwrap() Storage })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// seal encrypts user data. Vectors and metadata are stored in plaintext so
+// lookups and hit counting do not pay for decryption.
+func (s *EncryptedStore) seal(key string, value []byte) ([]byte, error) {
+	if !userDataKey(key) || value == nil {
+		return value, nil
+	}
+	return s.ring.seal(key, value)
+}
+
+func (s *EncryptedStore) open(key string, value []byte) ([]byte, error) {
+	if !userDataKey(key) || value == nil {
+		return value, nil
+	}
+	return s.ring.open(key, value)
+}
+
+func (s *EncryptedStore) Set(ctx context.Context, key string, value []byte) error {
+	return s.SetWithTTL(ctx, key, value, 0)
+}
+
+func (s *EncryptedStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	sealed, err := s.seal(key, value)
+	if err != nil {
+		return err
+	}
+	s.rotateMu.RLock()
+	defer s.rotateMu.RUnlock()
+	if ttl > 0 {
+		return s.store.SetWithTTL(ctx, key, sealed, ttl)
+	}
+	return s.store.Set(ctx, key, sealed)
+}
+
+func (s *EncryptedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(key, value)
+}
+
+func (s *EncryptedStore) Delete(ctx context.Context, key string) error {
+	s.rotateMu.RLock()
+	defer s.rotateMu.RUnlock()
+	return s.store.Delete(ctx, key)
+}
+
+func (s *EncryptedStore) DeleteBatch(ctx context.Context, keys []string) error {
+	s.rotateMu.RLock()
+	defer s.rotateMu.RUnlock()
+	return s.store.DeleteBatch(ctx, keys)
+}
+
+func (s *EncryptedStore) GetAllEmbeddings(ctx context.Context) (map[string][]byte, error) {
+	return s.store.GetAllEmbeddings(ctx)
+}
+
+func (s *EncryptedStore) GetPrompt(ctx context.Context, key string) (string, error) {
+	value, err := s.Get(ctx, PromptPrefix+key)
+	if err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "", ErrNotFound
+	}
+	return string(value), nil
+}
+
+func (s *EncryptedStore) PutEntry(ctx context.Context, entry *Entry) error {
+	sealed := *entry
+	var err error
+	if sealed.Value, err = s.seal(entry.Key, entry.Value); err != nil {
+		return err
+	}
+	if sealed.Prompt, err = s.seal(PromptPrefix+entry.Key, entry.Prompt); err != nil {
+		return err
+	}
+
+	s.rotateMu.RLock()
+	defer s.rotateMu.RUnlock()
+	return s.store.PutEntry(ctx, &sealed)
+}
+
+func (s *EncryptedStore) GetEntry(ctx context.Context, key string) (*Entry, error) {
+	entry, err := s.store.GetEntry(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Value, err = s.open(key, entry.Value); err != nil {
+		return nil, err
+	}
+	if entry.Prompt, err = s.open(PromptPrefix+key, entry.Prompt); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *EncryptedStore) RecordHit(ctx context.Context, key string) error {
+	return s.store.RecordHit(ctx, key)
+}
+
+func (s *EncryptedStore) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	s.rotateMu.RLock()
+	defer s.rotateMu.RUnlock()
+	return s.store.Update(ctx, key, func(value []byte) ([]byte, error) {
+		value, err := s.open(key, value)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = fn(value); err != nil {
+			return nil, err
+		}
+		return s.seal(key, value)
+	})
+}
+
+func (s *EncryptedStore) Scan(ctx context.Context, prefix, cursor string, limit int) ([]KV, string, error) {
+	items, next, err := s.store.Scan(ctx, prefix, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range items {
+		if items[i].Value, err = s.open(items[i].Key, items[i].Value); err != nil {
+			return nil, "", err
+		}
+	}
+	return items, next, nil
+}
+
+func (s *EncryptedStore) Close() {
+	s.store.Close()
+}
+
+// Status reports the key versions and the last rotation
+func (s *EncryptedStore) Status() EncryptionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Enabled = true
+	status.ActiveVersion = s.ring.Active()
+	status.Versions = s.ring.Versions()
+	return status
+}
+
+// Rotate re-encrypts every response and prompt that is not sealed with the
+// active key, including records written before encryption was enabled, and
+// returns how many it rewrote. Records keep their remaining TTL. Records
+// that cannot be re-encrypted, e.g. because their key left the ring, are
+// skipped and reported in the status.
+func (s *EncryptedStore) Rotate(ctx context.Context) (int, error) {
+	ttls, ok := s.store.(ttlReader)
+	if !ok {
+		return 0, fmt.Errorf("storage does not report TTLs, so records cannot be re-encrypted")
+	}
+
+	s.mu.Lock()
+	if s.status.Rotating {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("rotation is already running")
+	}
+	s.status.Rotating = true
+	s.mu.Unlock()
+
+	var rewritten, failed int64
+	var lastErr error
+	err := func() error {
+		cursor := ""
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			items, next, err := s.store.Scan(ctx, "", cursor, rotateBatch)
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				if !userDataKey(item.Key) {
+					continue
+				}
+				if version, ok := sealedVersion(item.Value); ok && version == s.ring.Active() {
+					continue
+				}
+				done, err := s.reencrypt(ctx, ttls, item.Key)
+				if err != nil {
+					failed++
+					lastErr = fmt.Errorf("re-encrypt %s: %w", item.Key, err)
+					continue
+				}
+				if done {
+					rewritten++
+				}
+			}
+
+			if next == "" {
+				return nil
+			}
+			cursor = next
+		}
+	}()
+	if err != nil {
+		lastErr = err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.status.Rotating = false
+	s.status.LastRotation = &now
+	s.status.Reencrypted = rewritten
+	s.status.Failed = failed
+	s.status.LastError = ""
+	if lastErr != nil {
+		s.status.LastError = lastErr.Error()
+	}
+	s.mu.Unlock()
+
+	return int(rewritten), err
+}
+
+// reencrypt rewrites the record under key with the active key, reporting
+// false if it was deleted or rewritten meanwhile
+func (s *EncryptedStore) reencrypt(ctx context.Context, ttls ttlReader, key string) (bool, error) {
+	s.rotateMu.Lock()
+	defer s.rotateMu.Unlock()
+
+	value, err := s.store.Get(ctx, key)
+	if err != nil || value == nil {
+		return false, err
+	}
+	ttl, err := ttls.TTL(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	rotated, err := s.ring.rotate(key, value)
+	if err != nil || rotated == nil {
+		return false, err
+	}
+	if ttl > 0 {
+		return true, s.store.SetWithTTL(ctx, key, rotated, ttl)
+	}
+	return true, s.store.Set(ctx, key, rotated)
+}
+
+// RunRotation re-encrypts records under older keys at once and then every
+// interval until ctx is cancelled. An interval of 0 runs a single pass.
+func (s *EncryptedStore) RunRotation(ctx context.Context, interval time.Duration) {
+	for {
+		n, err := s.Rotate(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Encryption key rotation failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Re-encrypted %d records with key %d", n, s.ring.Active())
+		}
+		if status := s.Status(); status.Failed > 0 {
+			log.Printf("Encryption key rotation skipped %d records: %s", status.Failed, status.LastError)
+		}
+
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestParseKeys(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+
+	keys, err := ParseKeys("# comment\n1:" + k1 + "\n\n 2:" + k2 + " ,\n")
+	if err != nil {
+		t.Fatalf("ParseKeys failed: %v", err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[2], testKey(2)) {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+
+	for _, bad := range []string{k1, "x:" + k1, "1:not base64!", "1:" + k1 + ",1:" + k2} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Errorf("Expected %q to fail", bad)
+		}
+	}
+}
+
+func TestNewKeyRing(t *testing.T) {
+	ring, err := NewKeyRing(map[uint32][]byte{1: testKey(1), 3: testKey(3)}, 0)
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	if ring.Active() != 3 {
+		t.Errorf("Expected the highest version to be active, got %d", ring.Active())
+	}
+
+	tests := []struct {
+		name   string
+		keys   map[uint32][]byte
+		active uint32
+	}{
+		{name: "no keys", keys: nil},
+		{name: "short key", keys: map[uint32][]byte{1: {1, 2, 3}}},
+		{name: "version 0", keys: map[uint32][]byte{0: testKey(1)}},
+		{name: "unknown active", keys: map[uint32][]byte{1: testKey(1)}, active: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyRing(tt.keys, tt.active); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			base := open(t)
+			ring, _ := NewKeyRing(map[uint32][]byte{1: testKey(1)}, 0)
+			store := NewEncryptedStore(base, ring)
+			ctx := context.Background()
+
+			entry := &Entry{
+				Key:        "hash",
+				Value:      []byte("secret response"),
+				Prompt:     []byte("secret prompt"),
+				Embeddings: map[string][]byte{"emb:openai:hash": {1, 2, 3, 4}},
+				Metadata:   EntryMetadata{Model: "gpt-4o", TTL: time.Hour},
+			}
+			if err := store.PutEntry(ctx, entry); err != nil {
+				t.Fatalf("PutEntry failed: %v", err)
+			}
+
+			// User data is sealed in the backend; vectors and metadata are not
+			for _, key := range []string{"hash", "prompt:hash"} {
+				raw, _ := base.Get(ctx, key)
+				if bytes.Contains(raw, []byte("secret")) {
+					t.Errorf("Expected %s to be encrypted, got %q", key, raw)
+				}
+				if version, ok := sealedVersion(raw); !ok || version != 1 {
+					t.Errorf("Expected %s to be sealed with key 1, got (%d, %v)", key, version, ok)
+				}
+			}
+			if raw, _ := base.Get(ctx, "emb:openai:hash"); !bytes.Equal(raw, []byte{1, 2, 3, 4}) {
+				t.Errorf("Expected the vector in plaintext, got %v", raw)
+			}
+			if err := store.RecordHit(ctx, "hash"); err != nil {
+				t.Errorf("RecordHit failed: %v", err)
+			}
+
+			got, err := store.GetEntry(ctx, "hash")
+			if err != nil {
+				t.Fatalf("GetEntry failed: %v", err)
+			}
+			if string(got.Value) != "secret response" || string(got.Prompt) != "secret prompt" || got.Metadata.Hits != 1 {
+				t.Errorf("Unexpected entry: %+v", got)
+			}
+			if prompt, err := store.GetPrompt(ctx, "hash"); err != nil || prompt != "secret prompt" {
+				t.Errorf("GetPrompt = (%q, %v), want secret prompt", prompt, err)
+			}
+			items, _, err := store.Scan(ctx, "", "", 10)
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			for _, item := range items {
+				if item.Key == "hash" && string(item.Value) != "secret response" {
+					t.Errorf("Expected Scan to decrypt values, got %q", item.Value)
+				}
+			}
+
+			// A sealed value moved to another key does not decrypt
+			raw, _ := base.Get(ctx, "hash")
+			base.Set(ctx, "other", raw)
+			if _, err := store.Get(ctx, "other"); err == nil {
+				t.Error("Expected a value moved to another key to fail")
+			}
+		})
+	}
+}
+
+func TestEncryptedStore_Rotate(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			base := open(t)
+			ctx := context.Background()
+
+			// One record written before encryption, one under each key
+			base.SetWithTTL(ctx, "legacy", []byte("plaintext"), time.Hour)
+			old, _ := NewKeyRing(map[uint32][]byte{1: testKey(1)}, 0)
+			NewEncryptedStore(base, old).SetWithTTL(ctx, "old", []byte("under key 1"), time.Hour)
+			base.Set(ctx, "emb:openai:old", []byte{1})
+
+			ring, _ := NewKeyRing(map[uint32][]byte{1: testKey(1), 2: testKey(2)}, 0)
+			store := NewEncryptedStore(base, ring)
+			store.Set(ctx, "new", []byte("under key 2"))
+
+			n, err := store.Rotate(ctx)
+			if err != nil {
+				t.Fatalf("Rotate failed: %v", err)
+			}
+			if n != 2 {
+				t.Errorf("Expected 2 records re-encrypted, got %d", n)
+			}
+
+			for key, want := range map[string]string{"legacy": "plaintext", "old": "under key 1", "new": "under key 2"} {
+				raw, _ := base.Get(ctx, key)
+				if version, ok := sealedVersion(raw); !ok || version != 2 {
+					t.Errorf("Expected %s to be sealed with key 2, got (%d, %v)", key, version, ok)
+				}
+				if got, err := store.Get(ctx, key); err != nil || string(got) != want {
+					t.Errorf("Get(%s) = (%q, %v), want %q", key, got, err, want)
+				}
+			}
+			if ttl, err := base.(ttlReader).TTL(ctx, "old"); err != nil || ttl <= 0 || ttl > time.Hour {
+				t.Errorf("Expected the remaining TTL to be kept, got (%s, %v)", ttl, err)
+			}
+
+			status := store.Status()
+			if !status.Enabled || status.ActiveVersion != 2 || len(status.Versions) != 2 || status.Reencrypted != 2 || status.LastRotation == nil {
+				t.Errorf("Unexpected status: %+v", status)
+			}
+
+			// A second pass has nothing to do
+			if n, _ := store.Rotate(ctx); n != 0 {
+				t.Errorf("Expected nothing to re-encrypt, got %d", n)
+			}
+		})
+	}
+}
+
+func TestEncryptedStore_UnknownKey(t *testing.T) {
+	base, _ := NewMemoryStore(MemoryOptions{})
+	ctx := context.Background()
+	old, _ := NewKeyRing(map[uint32][]byte{1: testKey(1)}, 0)
+	NewEncryptedStore(base, old).Set(ctx, "hash", []byte("response"))
+
+	ring, _ := NewKeyRing(map[uint32][]byte{2: testKey(2)}, 0)
+	store := NewEncryptedStore(base, ring)
+	if _, err := store.Get(ctx, "hash"); err == nil || !strings.Contains(err.Error(), "key 1") {
+		t.Errorf("Expected an error naming key 1, got %v", err)
+	}
+
+	if _, err := store.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if status := store.Status(); status.Failed != 1 || status.LastError == "" {
+		t.Errorf("Expected the record to be reported as failed, got %+v", status)
+	}
+}
+
+func TestOpen_Encryption(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(keyFile, []byte("2:"+base64.StdEncoding.EncodeToString(testKey(2))+"\n"), 0o600)
+
+	t.Setenv("STORAGE_BACKEND", "memory")
+	t.Setenv("ENCRYPTION_KEYS", "1:"+base64.StdEncoding.EncodeToString(testKey(1)))
+	t.Setenv("ENCRYPTION_KEY_FILE", keyFile)
+	t.Setenv("ENCRYPTION_ACTIVE_KEY", "1")
+	t.Setenv("ENCRYPTION_ROTATION_INTERVAL", "10m")
+
+	config := LoadConfig()
+	if config.Encryption.RotationInterval != 10*time.Minute {
+		t.Errorf("Expected a rotation interval of 10m, got %s", config.Encryption.RotationInterval)
+	}
+	store, err := Open(config)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+
+	encrypted, ok := store.(*EncryptedStore)
+	if !ok {
+		t.Fatalf("Expected an encrypted store, got %T", store)
+	}
+	if status := encrypted.Status(); status.ActiveVersion != 1 || len(status.Versions) != 2 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if _, ok := Base(store).(*MemoryStore); !ok {
+		t.Errorf("Expected Base to return the memory store, got %T", Base(store))
+	}
+
+	config.Encryption.Keys = "1:short"
+	if _, err := Open(config); err == nil {
+		t.Error("Expected invalid keys to fail")
+	}
+}
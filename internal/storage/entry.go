@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// EmbeddingPrefix prefixes the vectors of an entry, stored under
+	// EmbeddingPrefix + namespace + ":" + key
+	EmbeddingPrefix = "emb:"
+
+	// PromptPrefix prefixes the original prompt of an entry
+	PromptPrefix = "prompt:"
+
+	// MetaPrefix prefixes the metadata of an entry
+	MetaPrefix = "meta:"
+
+	// TagPrefix prefixes the tag index, which holds a record under TagKey
+	// for every tag of every entry
+	TagPrefix = "tag:"
+)
+
+// TagKey returns the key of the tag index record of the entry under key.
+// Tags cannot contain ':', so the records of a tag share the prefix
+// TagPrefix + tag + ":".
+func TagKey(tag, key string) string {
+	return TagPrefix + tag + ":" + key
+}
+
+// userDataKey reports whether the record under key holds user data: a
+// response or a prompt. Vectors, metadata and index records do not.
+func userDataKey(key string) bool {
+	return !strings.Contains(key, ":") || strings.HasPrefix(key, PromptPrefix)
+}
+
+// entryKey returns the key of the cache entry a record belongs to. Records
+// outside entries, such as system: and stats: keys, report false.
+func entryKey(key string) (string, bool) {
+	if !strings.Contains(key, ":") {
+		return key, true
+	}
+	for _, prefix := range []string{PromptPrefix, MetaPrefix, EmbeddingPrefix, TagPrefix} {
+		if strings.HasPrefix(key, prefix) {
+			return key[strings.LastIndex(key, ":")+1:], true
+		}
+	}
+	return "", false
+}
+
+// EntryMetadata records where a cached entry came from and how it is used
+type EntryMetadata struct {
+	Model             string        `json:"model,omitempty"`              // Model requested upstream
+	Upstream          string        `json:"upstream,omitempty"`           // URL the response was fetched from
+	EmbeddingProvider string        `json:"embedding_provider,omitempty"` // Namespace of the stored vector
+	EmbeddingModel    string        `json:"embedding_model,omitempty"`
+	ParamsFingerprint string        `json:"params_fingerprint,omitempty"` // Hash of the request parameters other than the messages
+	EmbeddingKeys     []string      `json:"embedding_keys,omitempty"`
+	Tags              []string      `json:"tags,omitempty"` // Indexed under TagKey
+	CreatedAt         time.Time     `json:"created_at"`
+	TTL               time.Duration `json:"ttl"`
+	Hits              int64         `json:"hits"`
+}
+
+// Entry is every record of one cache entry. Entries written before metadata
+// was stored have a zero Metadata and no Embeddings.
+type Entry struct {
+	Key        string
+	Value      []byte            // Stored under Key
+	Prompt     []byte            // Stored under PromptPrefix + Key
+	Embeddings map[string][]byte // Vectors by embedding key
+	Metadata   EntryMetadata     // Stored under MetaPrefix + Key
+}
+
+// records returns the keys and values entry is written as. The embedding
+// keys are recorded in the metadata so GetEntry can find them.
+func (e *Entry) records() (map[string][]byte, error) {
+	if e.Key == "" {
+		return nil, fmt.Errorf("entry has no key")
+	}
+
+	meta := e.Metadata
+	meta.EmbeddingKeys = make([]string, 0, len(e.Embeddings))
+	for key := range e.Embeddings {
+		meta.EmbeddingKeys = append(meta.EmbeddingKeys, key)
+	}
+	sort.Strings(meta.EmbeddingKeys)
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string][]byte{
+		e.Key:                e.Value,
+		PromptPrefix + e.Key: e.Prompt,
+		MetaPrefix + e.Key:   metaData,
+	}
+	for key, vec := range e.Embeddings {
+		records[key] = vec
+	}
+	for _, tag := range meta.Tags {
+		records[TagKey(tag, e.Key)] = []byte(e.Key)
+	}
+	return records, nil
+}
+
+// staleTagKeys returns the tag index records of the entry under key that its
+// previous metadata lists but tags no longer does, so rewriting an entry with
+// other tags drops it from the old ones. Unreadable metadata has no tags to
+// clean up.
+func staleTagKeys(key string, previous []byte, tags []string) []string {
+	meta, err := parseMetadata(previous)
+	if err != nil {
+		return nil
+	}
+	var stale []string
+	for _, tag := range meta.Tags {
+		if !slices.Contains(tags, tag) {
+			stale = append(stale, TagKey(tag, key))
+		}
+	}
+	return stale
+}
+
+// ttl returns how long the records of e live: what is left of Metadata.TTL
+// since Metadata.CreatedAt, so rewriting or importing an entry never extends
+// its life. Zero means the records never expire.
+func (e *Entry) ttl(now time.Time) time.Duration {
+	ttl := e.Metadata.TTL
+	if ttl <= 0 {
+		return 0
+	}
+	if !e.Metadata.CreatedAt.IsZero() {
+		ttl = e.Metadata.CreatedAt.Add(ttl).Sub(now)
+	}
+	// An entry that is already past its TTL is written to expire at once
+	return max(ttl, time.Millisecond)
+}
+
+// AddEmbedding stores vec under embKey as a vector of the entry under key,
+// after the entry was written: it is listed in the entry's metadata and
+// expires with the entry. It returns ErrNotFound for entries without
+// metadata, e.g. once they expired.
+func AddEmbedding(ctx context.Context, s Storage, key, embKey string, vec []byte) error {
+	var meta EntryMetadata
+	err := s.Update(ctx, MetaPrefix+key, func(data []byte) ([]byte, error) {
+		if data == nil {
+			return nil, ErrNotFound
+		}
+		var err error
+		if meta, err = parseMetadata(data); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(meta.EmbeddingKeys, embKey) {
+			meta.EmbeddingKeys = append(meta.EmbeddingKeys, embKey)
+			sort.Strings(meta.EmbeddingKeys)
+		}
+		return json.Marshal(meta)
+	})
+	if err != nil {
+		return err
+	}
+
+	entry := Entry{Metadata: meta}
+	if ttl := entry.ttl(time.Now()); ttl > 0 {
+		return s.SetWithTTL(ctx, embKey, vec, ttl)
+	}
+	return s.Set(ctx, embKey, vec)
+}
+
+// readEntry reads the records of the entry under key with get, which
+// returns nil for a missing record
+func readEntry(key string, get func(key string) ([]byte, error)) (*Entry, error) {
+	value, err := get(key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrNotFound
+	}
+
+	entry := &Entry{Key: key, Value: value}
+	if entry.Prompt, err = get(PromptPrefix + key); err != nil {
+		return nil, err
+	}
+	metaData, err := get(MetaPrefix + key)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Metadata, err = parseMetadata(metaData); err != nil {
+		return nil, err
+	}
+
+	// Vectors that expired or were deleted on their own are left out
+	entry.Embeddings = make(map[string][]byte, len(entry.Metadata.EmbeddingKeys))
+	for _, embKey := range entry.Metadata.EmbeddingKeys {
+		vec, err := get(embKey)
+		if err != nil {
+			return nil, err
+		}
+		if vec != nil {
+			entry.Embeddings[embKey] = vec
+		}
+	}
+	return entry, nil
+}
+
+// parseMetadata decodes a metadata record; a missing record is a zero value
+func parseMetadata(data []byte) (EntryMetadata, error) {
+	var meta EntryMetadata
+	if data == nil {
+		return meta, nil
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("invalid entry metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// addHit returns the metadata record with its hit count incremented
+func addHit(data []byte) ([]byte, error) {
+	meta, err := parseMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	meta.Hits++
+	return json.Marshal(meta)
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStorage_Entry(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			ctx := context.Background()
+
+			entry := &Entry{
+				Key:    "hash",
+				Value:  []byte("response"),
+				Prompt: []byte("prompt"),
+				Embeddings: map[string][]byte{
+					"emb:openai:hash":  {1, 2, 3},
+					"emb:mistral:hash": {4, 5},
+				},
+				Metadata: EntryMetadata{
+					Model:             "gpt-4o",
+					Upstream:          "https://api.openai.com/v1/chat/completions",
+					EmbeddingProvider: "openai",
+					EmbeddingModel:    "text-embedding-3-small",
+					ParamsFingerprint: "abc",
+					Tags:              []string{"docs-v2", "pricing"},
+					CreatedAt:         time.Now().UTC().Truncate(time.Second),
+					TTL:               time.Hour,
+				},
+			}
+			if err := store.PutEntry(ctx, entry); err != nil {
+				t.Fatalf("PutEntry failed: %v", err)
+			}
+
+			// Every record is readable on its own
+			if prompt, err := store.GetPrompt(ctx, "hash"); err != nil || prompt != "prompt" {
+				t.Errorf("GetPrompt = (%q, %v), want prompt", prompt, err)
+			}
+			embeddings, _ := store.GetAllEmbeddings(ctx)
+			if len(embeddings) != 2 {
+				t.Errorf("Expected 2 embeddings, got %d", len(embeddings))
+			}
+			if tagged, _, _ := store.Scan(ctx, TagPrefix+"pricing:", "", 10); len(tagged) != 1 || string(tagged[0].Value) != "hash" {
+				t.Errorf("Expected the entry in the tag index, got %v", tagged)
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := store.RecordHit(ctx, "hash"); err != nil {
+					t.Fatalf("RecordHit failed: %v", err)
+				}
+			}
+
+			got, err := store.GetEntry(ctx, "hash")
+			if err != nil {
+				t.Fatalf("GetEntry failed: %v", err)
+			}
+			if string(got.Value) != "response" || string(got.Prompt) != "prompt" {
+				t.Errorf("Unexpected records: %+v", got)
+			}
+			if len(got.Embeddings) != 2 || len(got.Embeddings["emb:openai:hash"]) != 3 {
+				t.Errorf("Unexpected embeddings: %v", got.Embeddings)
+			}
+			meta := got.Metadata
+			if meta.Model != "gpt-4o" || meta.EmbeddingModel != "text-embedding-3-small" || meta.ParamsFingerprint != "abc" {
+				t.Errorf("Unexpected metadata: %+v", meta)
+			}
+			if len(meta.Tags) != 2 || meta.Hits != 2 || meta.TTL != time.Hour || !meta.CreatedAt.Equal(entry.Metadata.CreatedAt) {
+				t.Errorf("Unexpected metadata: %+v", meta)
+			}
+
+			if _, err := store.GetEntry(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound, got %v", err)
+			}
+			if err := store.RecordHit(ctx, "missing"); err != nil {
+				t.Errorf("Expected a hit on a missing entry to be ignored, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStorage_EntryRetagged(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			ctx := context.Background()
+
+			entry := &Entry{Key: "hash", Value: []byte("v1"), Metadata: EntryMetadata{Tags: []string{"docs-v1", "pricing"}, TTL: time.Hour}}
+			if err := store.PutEntry(ctx, entry); err != nil {
+				t.Fatalf("PutEntry failed: %v", err)
+			}
+			entry = &Entry{Key: "hash", Value: []byte("v2"), Metadata: EntryMetadata{Tags: []string{"docs-v2", "pricing"}, TTL: time.Hour}}
+			if err := store.PutEntry(ctx, entry); err != nil {
+				t.Fatalf("PutEntry failed: %v", err)
+			}
+
+			// Only the tags of the rewritten entry are indexed
+			tagged, _, err := store.Scan(ctx, TagPrefix, "", 10)
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			keys := make(map[string]bool)
+			for _, item := range tagged {
+				keys[item.Key] = true
+			}
+			if len(keys) != 2 || !keys[TagKey("docs-v2", "hash")] || !keys[TagKey("pricing", "hash")] {
+				t.Errorf("Expected the docs-v2 and pricing records, got %v", keys)
+			}
+		})
+	}
+}
+
+func TestRedisStore_RecordHitKeepsTTL(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	store.PutEntry(ctx, &Entry{Key: "hash", Value: []byte("response"), Metadata: EntryMetadata{TTL: time.Hour}})
+	mr.FastForward(10 * time.Minute)
+
+	if err := store.RecordHit(ctx, "hash"); err != nil {
+		t.Fatalf("RecordHit failed: %v", err)
+	}
+	if ttl := mr.TTL("test:meta:hash"); ttl != 50*time.Minute {
+		t.Errorf("Expected the remaining TTL of 50m to be kept, got %s", ttl)
+	}
+}
@@ -0,0 +1,466 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryOptions configures a MemoryStore. Zero limits mean unlimited.
+type MemoryOptions struct {
+	MaxEntries   int    // Number of records, counting each prompt, vector and metadata record
+	MaxBytes     int64  // Total size of keys and values
+	SnapshotPath string // Written on Close and loaded on open when set
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time    // Zero if the entry never expires
+	group     *memoryGroup // Nil for records outside cache entries, which are never evicted
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// memoryGroup holds the records of one cache entry: its response, prompt,
+// metadata, vectors and tag index records. They are used and evicted
+// together, so a lookup that serves the response keeps its vectors too.
+type memoryGroup struct {
+	key     string
+	records map[string]*memoryEntry
+	elem    *list.Element
+}
+
+// MemoryStore keeps everything in process memory. Once a size limit is
+// reached the least recently used cache entries are evicted with all their
+// records. Records outside entries, such as the schema marker, persisted
+// thresholds and savings counters, count towards the limits but are kept.
+type MemoryStore struct {
+	mu      sync.Mutex
+	opts    MemoryOptions
+	entries map[string]*memoryEntry
+	groups  map[string]*memoryGroup
+	lru     *list.List // Groups; front is the most recently used entry
+	bytes   int64
+	now     func() time.Time
+}
+
+// NewMemoryStore creates a memory store, restoring the snapshot at
+// opts.SnapshotPath if one exists
+func NewMemoryStore(opts MemoryOptions) (*MemoryStore, error) {
+	s := &MemoryStore{
+		opts:    opts,
+		entries: make(map[string]*memoryEntry),
+		groups:  make(map[string]*memoryGroup),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+
+	if opts.SnapshotPath != "" {
+		if err := s.restore(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte) error {
+	return s.SetWithTTL(ctx, key, value, 0)
+}
+
+func (s *MemoryStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = s.now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set(key, append([]byte{}, value...), expiresAt)
+}
+
+// set stores a record and evicts until the limits hold again
+func (s *MemoryStore) set(key string, value []byte, expiresAt time.Time) error {
+	if size := int64(len(key) + len(value)); s.opts.MaxBytes > 0 && size > s.opts.MaxBytes {
+		return fmt.Errorf("entry %s of %d bytes exceeds the memory limit of %d bytes", key, size, s.opts.MaxBytes)
+	}
+	entry := s.put(key, value, expiresAt)
+	s.evict(entry.group)
+	return nil
+}
+
+// put stores a record without enforcing the limits and marks its cache entry
+// as the most recently used
+func (s *MemoryStore) put(key string, value []byte, expiresAt time.Time) *memoryEntry {
+	if old, ok := s.entries[key]; ok {
+		s.remove(old)
+	}
+	entry := &memoryEntry{key: key, value: value, expiresAt: expiresAt}
+	if owner, ok := entryKey(key); ok {
+		group := s.groups[owner]
+		if group == nil {
+			group = &memoryGroup{key: owner, records: make(map[string]*memoryEntry)}
+			group.elem = s.lru.PushFront(group)
+			s.groups[owner] = group
+		}
+		group.records[key] = entry
+		entry.group = group
+		s.lru.MoveToFront(group.elem)
+	}
+	s.entries[key] = entry
+	s.bytes += entry.size()
+	return entry
+}
+
+// evict removes the least recently used cache entries until the limits hold
+// again. The entry keep, just written, is never evicted, so the limits can
+// be exceeded while records outside entries take up the space.
+func (s *MemoryStore) evict(keep *memoryGroup) {
+	for s.overLimit() {
+		back := s.lru.Back()
+		if back == nil || back.Value.(*memoryGroup) == keep {
+			return
+		}
+		for _, entry := range back.Value.(*memoryGroup).records {
+			s.remove(entry)
+		}
+	}
+}
+
+func (s *MemoryStore) overLimit() bool {
+	return (s.opts.MaxEntries > 0 && len(s.entries) > s.opts.MaxEntries) ||
+		(s.opts.MaxBytes > 0 && s.bytes > s.opts.MaxBytes)
+}
+
+func (s *MemoryStore) remove(entry *memoryEntry) {
+	delete(s.entries, entry.key)
+	s.bytes -= entry.size()
+	if group := entry.group; group != nil {
+		delete(group.records, entry.key)
+		if len(group.records) == 0 {
+			s.lru.Remove(group.elem)
+			delete(s.groups, group.key)
+		}
+	}
+}
+
+// touch marks the cache entry of a record as the most recently used
+func (s *MemoryStore) touch(entry *memoryEntry) {
+	if entry.group != nil {
+		s.lru.MoveToFront(entry.group.elem)
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if entry.expired(s.now()) {
+		s.remove(entry)
+		return nil, nil
+	}
+	s.touch(entry)
+	return append([]byte{}, entry.value...), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		s.remove(entry)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteBatch(ctx context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if entry, ok := s.entries[key]; ok {
+			s.remove(entry)
+		}
+	}
+	return nil
+}
+
+// GetAllEmbeddings returns every embedding. Scanning does not count as a use,
+// or every lookup would refresh every entry; serving a response does, and
+// keeps the vectors of the entry along with it.
+func (s *MemoryStore) GetAllEmbeddings(ctx context.Context) (map[string][]byte, error) {
+	stored, err := s.GetAllEmbeddingsWithExpiry(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return withoutExpiry(stored), nil
+}
+
+func (s *MemoryStore) GetAllEmbeddingsWithExpiry(ctx context.Context) (map[string]ExpiringValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	results := make(map[string]ExpiringValue)
+	for key, entry := range s.entries {
+		if !strings.HasPrefix(key, EmbeddingPrefix) {
+			continue
+		}
+		if entry.expired(now) {
+			s.remove(entry)
+			continue
+		}
+		results[key] = ExpiringValue{Value: append([]byte{}, entry.value...), ExpiresAt: entry.expiresAt}
+	}
+	return results, nil
+}
+
+// Scan sorts the matching keys for every page, which is fine for the sizes
+// a memory store holds. Like GetAllEmbeddings it does not count as a use.
+func (s *MemoryStore) Scan(ctx context.Context, prefix, cursor string, limit int) ([]KV, string, error) {
+	limit = scanLimit(limit)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var keys []string
+	for key, entry := range s.entries {
+		if strings.HasPrefix(key, prefix) && key > cursor && !entry.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	items := make([]KV, len(keys))
+	for i, key := range keys {
+		items[i] = KV{Key: key, Value: append([]byte{}, s.entries[key].value...)}
+	}
+	return items, next, nil
+}
+
+func (s *MemoryStore) GetPrompt(ctx context.Context, key string) (string, error) {
+	val, err := s.Get(ctx, PromptPrefix+key)
+	if err != nil {
+		return "", err
+	}
+	if val == nil {
+		return "", ErrNotFound
+	}
+	return string(val), nil
+}
+
+// TTL returns how long the record under key has left to live, or zero if it
+// never expires
+func (s *MemoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	now := s.now()
+	if !ok || entry.expired(now) {
+		return 0, ErrNotFound
+	}
+	if entry.expiresAt.IsZero() {
+		return 0, nil
+	}
+	return max(entry.expiresAt.Sub(now), time.Millisecond), nil
+}
+
+// PutEntry writes every record of entry under the lock. The entry is only
+// ever evicted as a whole.
+func (s *MemoryStore) PutEntry(ctx context.Context, entry *Entry) error {
+	records, err := entry.records()
+	if err != nil {
+		return err
+	}
+
+	var expiresAt time.Time
+	now := s.now()
+	if ttl := entry.ttl(now); ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check the limits first so a rejected entry leaves nothing behind
+	var size int64
+	for key, value := range records {
+		size += int64(len(key) + len(value))
+	}
+	if s.opts.MaxBytes > 0 && size > s.opts.MaxBytes {
+		return fmt.Errorf("entry %s of %d bytes exceeds the memory limit of %d bytes", entry.Key, size, s.opts.MaxBytes)
+	}
+
+	if previous, ok := s.entries[MetaPrefix+entry.Key]; ok {
+		for _, key := range staleTagKeys(entry.Key, previous.value, entry.Metadata.Tags) {
+			if old, ok := s.entries[key]; ok {
+				s.remove(old)
+			}
+		}
+	}
+
+	var group *memoryGroup
+	for key, value := range records {
+		group = s.put(key, append([]byte{}, value...), expiresAt).group
+	}
+	s.evict(group)
+	return nil
+}
+
+func (s *MemoryStore) GetEntry(ctx context.Context, key string) (*Entry, error) {
+	return readEntry(key, func(key string) ([]byte, error) {
+		return s.Get(ctx, key)
+	})
+}
+
+func (s *MemoryStore) RecordHit(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[MetaPrefix+key]
+	if !ok || entry.expired(s.now()) {
+		return nil
+	}
+	data, err := addHit(entry.value)
+	if err != nil {
+		return err
+	}
+	return s.set(entry.key, data, entry.expiresAt)
+}
+
+// Update replaces the value under key with the result of fn under the lock,
+// keeping the remaining TTL
+func (s *MemoryStore) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var value []byte
+	var expiresAt time.Time
+	if entry, ok := s.entries[key]; ok && !entry.expired(s.now()) {
+		value = append([]byte{}, entry.value...)
+		expiresAt = entry.expiresAt
+	}
+	value, err := fn(value)
+	if err != nil {
+		return err
+	}
+	return s.set(key, append([]byte{}, value...), expiresAt)
+}
+
+// Len returns the number of entries and their total size in bytes
+func (s *MemoryStore) Len() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), s.bytes
+}
+
+// Close writes the snapshot if one is configured
+func (s *MemoryStore) Close() {
+	if s.opts.SnapshotPath == "" {
+		return
+	}
+	if err := s.Snapshot(); err != nil {
+		log.Printf("Failed to write memory snapshot: %v", err)
+	}
+}
+
+// snapshotEntry is the on-disk form of an entry
+type snapshotEntry struct {
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// Snapshot writes all live records to the snapshot path: those outside cache
+// entries first, then the entries least recently used first so a restore
+// rebuilds the same order. The file is replaced atomically.
+func (s *MemoryStore) Snapshot() error {
+	s.mu.Lock()
+	now := s.now()
+	entries := make([]snapshotEntry, 0, len(s.entries))
+	add := func(entry *memoryEntry) {
+		if !entry.expired(now) {
+			entries = append(entries, snapshotEntry{Key: entry.key, Value: entry.value, ExpiresAt: entry.expiresAt})
+		}
+	}
+	for _, entry := range s.entries {
+		if entry.group == nil {
+			add(entry)
+		}
+	}
+	for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+		for _, entry := range elem.Value.(*memoryGroup).records {
+			add(entry)
+		}
+	}
+	s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.opts.SnapshotPath), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.opts.SnapshotPath)
+}
+
+// restore loads the snapshot, skipping entries that expired meanwhile
+func (s *MemoryStore) restore() error {
+	f, err := os.Open(s.opts.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var entries []snapshotEntry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return fmt.Errorf("failed to read memory snapshot %s: %w", s.opts.SnapshotPath, err)
+	}
+
+	now := s.now()
+	for _, e := range entries {
+		if !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt) {
+			continue
+		}
+		// Limits may have shrunk since the snapshot; set evicts as needed and
+		// entries larger than the limit are dropped
+		_ = s.set(e.Key, e.Value, e.ExpiresAt)
+	}
+	return nil
+}
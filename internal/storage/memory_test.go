@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_SetGetDelete(t *testing.T) {
+	store, err := NewMemoryStore(MemoryOptions{})
+	if err != nil {
+		t.Fatalf("NewMemoryStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	value := []byte("value")
+	store.Set(ctx, "key", value)
+	value[0] = 'X'
+
+	got, err := store.Get(ctx, "key")
+	if err != nil || string(got) != "value" {
+		t.Errorf("Get = (%q, %v), want a copy of \"value\"", got, err)
+	}
+
+	store.Delete(ctx, "key")
+	if got, err := store.Get(ctx, "key"); got != nil || err != nil {
+		t.Errorf("Expected nil, nil for a missing key, got (%q, %v)", got, err)
+	}
+	if _, err := store.GetPrompt(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryStore_TTL(t *testing.T) {
+	store, _ := NewMemoryStore(MemoryOptions{})
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.SetWithTTL(ctx, "emb:openai:a", []byte{1}, time.Minute)
+	store.Set(ctx, "emb:openai:b", []byte{2})
+
+	now = now.Add(2 * time.Minute)
+	if got, _ := store.Get(ctx, "emb:openai:a"); got != nil {
+		t.Error("Expected the entry to expire")
+	}
+	embeddings, _ := store.GetAllEmbeddings(ctx)
+	if len(embeddings) != 1 {
+		t.Errorf("Expected only the live embedding, got %v", embeddings)
+	}
+}
+
+func TestMemoryStore_Limits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("entries", func(t *testing.T) {
+		store, _ := NewMemoryStore(MemoryOptions{MaxEntries: 2})
+		store.Set(ctx, "a", []byte("1"))
+		store.Set(ctx, "b", []byte("2"))
+		store.Get(ctx, "a") // b is now least recently used
+		store.Set(ctx, "c", []byte("3"))
+
+		if got, _ := store.Get(ctx, "b"); got != nil {
+			t.Error("Expected the least recently used entry to be evicted")
+		}
+		if got, _ := store.Get(ctx, "a"); got == nil {
+			t.Error("Expected the recently used entry to stay")
+		}
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		store, _ := NewMemoryStore(MemoryOptions{MaxBytes: 10})
+		store.Set(ctx, "a", []byte("1234")) // 5 bytes
+		store.Set(ctx, "b", []byte("1234"))
+		store.Set(ctx, "c", []byte("1234"))
+
+		if n, size := store.Len(); n != 2 || size != 10 {
+			t.Errorf("Expected 2 entries of 10 bytes, got %d of %d", n, size)
+		}
+		if err := store.Set(ctx, "d", []byte("too large to ever fit")); err == nil {
+			t.Error("Expected an entry larger than the limit to be rejected")
+		}
+	})
+}
+
+func TestMemoryStore_EvictsWholeEntries(t *testing.T) {
+	store, _ := NewMemoryStore(MemoryOptions{MaxEntries: 20})
+	ctx := context.Background()
+
+	store.Set(ctx, "system:schema_version", []byte(`{"version":3}`))
+	store.Set(ctx, "system:thresholds", []byte(`{}`))
+	store.Set(ctx, "stats:savings:2026-01-01", []byte(`{}`))
+
+	put := func(key string) {
+		t.Helper()
+		err := store.PutEntry(ctx, &Entry{
+			Key:        key,
+			Value:      []byte("response"),
+			Prompt:     []byte("prompt"),
+			Embeddings: map[string][]byte{"emb:openai:" + key: {1}},
+			Metadata:   EntryMetadata{Tags: []string{"docs"}},
+		})
+		if err != nil {
+			t.Fatalf("PutEntry failed: %v", err)
+		}
+	}
+
+	// Five records per entry: three entries fit next to the system records
+	for i := 0; i < 3; i++ {
+		put(fmt.Sprintf("key%d", i))
+	}
+	// Serving key0 keeps its vector and every other record; key1 goes whole
+	store.Get(ctx, "key0")
+	put("key3")
+	if embeddings, _ := store.GetAllEmbeddings(ctx); embeddings["emb:openai:key0"] == nil || embeddings["emb:openai:key1"] != nil {
+		t.Errorf("Expected the vector of key0 to be kept and that of key1 evicted, got %v", embeddings)
+	}
+	if got, _ := store.Get(ctx, "tag:docs:key1"); got != nil {
+		t.Error("Expected the tag record of key1 to be evicted with it")
+	}
+
+	for i := 4; i < 10; i++ {
+		put(fmt.Sprintf("key%d", i))
+	}
+
+	for _, key := range []string{"system:schema_version", "system:thresholds", "stats:savings:2026-01-01"} {
+		if got, _ := store.Get(ctx, key); got == nil {
+			t.Errorf("Expected %s to survive eviction", key)
+		}
+	}
+	if n, _ := store.Len(); n > 20 {
+		t.Errorf("Expected the limit to hold, got %d records", n)
+	}
+	// Every record left belongs to a complete entry
+	for key := range store.groups {
+		if group := store.groups[key]; len(group.records) != 5 {
+			t.Errorf("Expected entry %s to be kept whole, got %d records", key, len(group.records))
+		}
+	}
+	if len(store.groups) != 3 {
+		t.Errorf("Expected 3 entries to fit, got %d", len(store.groups))
+	}
+}
+
+func TestMemoryStore_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.gob")
+	ctx := context.Background()
+
+	store, _ := NewMemoryStore(MemoryOptions{SnapshotPath: path})
+	store.Set(ctx, "prompt:abc", []byte("what is go"))
+	store.SetWithTTL(ctx, "abc", []byte("response"), time.Hour)
+	store.SetWithTTL(ctx, "gone", []byte("response"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	store.Close()
+
+	restored, err := NewMemoryStore(MemoryOptions{SnapshotPath: path})
+	if err != nil {
+		t.Fatalf("NewMemoryStore failed: %v", err)
+	}
+	if prompt, err := restored.GetPrompt(ctx, "abc"); err != nil || prompt != "what is go" {
+		t.Errorf("Expected the prompt to be restored, got (%q, %v)", prompt, err)
+	}
+	if got, _ := restored.Get(ctx, "abc"); string(got) != "response" {
+		t.Errorf("Expected the response to be restored, got %q", got)
+	}
+	if n, _ := restored.Len(); n != 2 {
+		t.Errorf("Expected expired entries to be dropped, got %d entries", n)
+	}
+}
+
+func TestMemoryStore_Concurrent(t *testing.T) {
+	store, _ := NewMemoryStore(MemoryOptions{MaxEntries: 50})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("emb:openai:%d-%d", worker, j)
+				store.Set(ctx, key, []byte{byte(j)})
+				store.Get(ctx, key)
+				store.GetAllEmbeddings(ctx)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n, _ := store.Len(); n != 50 {
+		t.Errorf("Expected the entry limit to hold, got %d entries", n)
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://"+mr.Addr(), "test:")
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+	t.Cleanup(store.Close)
+	return store, mr
+}
+
+func TestRedisStore_SetGetDelete(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if err := store.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !mr.Exists("test:key") {
+		t.Error("Expected the key to be stored under the prefix")
+	}
+
+	got, err := store.Get(ctx, "key")
+	if err != nil || string(got) != "value" {
+		t.Errorf("Get = (%q, %v), want (\"value\", nil)", got, err)
+	}
+
+	if err := store.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	got, err = store.Get(ctx, "key")
+	if err != nil || got != nil {
+		t.Errorf("Expected nil, nil for a missing key, got (%q, %v)", got, err)
+	}
+}
+
+func TestRedisStore_SetWithTTL(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if err := store.SetWithTTL(ctx, "key", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("SetWithTTL failed: %v", err)
+	}
+	if ttl := mr.TTL("test:key"); ttl != time.Minute {
+		t.Errorf("Expected native TTL of 1m, got %s", ttl)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if got, _ := store.Get(ctx, "key"); got != nil {
+		t.Error("Expected the key to expire")
+	}
+}
+
+func TestRedisStore_GetAllEmbeddings(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	ctx := context.Background()
+
+	// More keys than one SCAN batch
+	for i := 0; i < scanBatch+20; i++ {
+		store.Set(ctx, fmt.Sprintf("emb:openai:%d", i), []byte{byte(i)})
+	}
+	store.Set(ctx, "prompt:1", []byte("not an embedding"))
+	mr.Set("other:emb:1", "another cache")
+
+	embeddings, err := store.GetAllEmbeddings(ctx)
+	if err != nil {
+		t.Fatalf("GetAllEmbeddings failed: %v", err)
+	}
+	if len(embeddings) != scanBatch+20 {
+		t.Errorf("Expected %d embeddings, got %d", scanBatch+20, len(embeddings))
+	}
+	if v := embeddings["emb:openai:7"]; len(v) != 1 || v[0] != 7 {
+		t.Errorf("Expected keys without prefix and their values, got %v", v)
+	}
+}
+
+func TestRedisStore_GetPrompt(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	ctx := context.Background()
+
+	store.Set(ctx, "prompt:abc", []byte("what is go"))
+
+	prompt, err := store.GetPrompt(ctx, "abc")
+	if err != nil || prompt != "what is go" {
+		t.Errorf("GetPrompt = (%q, %v), want (\"what is go\", nil)", prompt, err)
+	}
+	if _, err := store.GetPrompt(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRedisStore_SharedAcrossReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	first := NewRedisStoreWithClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "shared:")
+	second := NewRedisStoreWithClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "shared:")
+	defer first.Close()
+	defer second.Close()
+
+	first.Set(ctx, "key", []byte("value"))
+	if got, _ := second.Get(ctx, "key"); string(got) != "value" {
+		t.Errorf("Expected the second replica to see the first's write, got %q", got)
+	}
+}
+
+func TestOpen_UnknownBackend(t *testing.T) {
+	if _, err := Open(&Config{Backend: "cassandra"}); err == nil {
+		t.Error("Expected an unsupported backend to fail")
+	}
+}
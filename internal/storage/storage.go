@@ -2,13 +2,90 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
+// ErrNotFound is returned by GetPrompt and GetEntry when nothing is stored
+// for a key
+var ErrNotFound = errors.New("key not found")
+
+// DefaultScanLimit is the page size of a Scan with a limit of 0 or less
+const DefaultScanLimit = 100
+
+// KV is a key and its value, as returned by Scan
+type KV struct {
+	Key   string
+	Value []byte
+}
+
+// ExpiringValue is a stored value with the time its record expires, zero if
+// it never does
+type ExpiringValue struct {
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// EmbeddingExpirer is implemented by backends that report when each
+// embedding expires, so copies kept in memory expire along with them
+type EmbeddingExpirer interface {
+	GetAllEmbeddingsWithExpiry(ctx context.Context) (map[string]ExpiringValue, error)
+}
+
+// embeddingsWithExpiry returns every embedding in s with its expiry; those of
+// backends that cannot report it never expire
+func embeddingsWithExpiry(ctx context.Context, s Storage) (map[string]ExpiringValue, error) {
+	if expirer, ok := Base(s).(EmbeddingExpirer); ok {
+		return expirer.GetAllEmbeddingsWithExpiry(ctx)
+	}
+	stored, err := s.GetAllEmbeddings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]ExpiringValue, len(stored))
+	for key, value := range stored {
+		results[key] = ExpiringValue{Value: value}
+	}
+	return results, nil
+}
+
+// withoutExpiry drops the expiry of every value
+func withoutExpiry(stored map[string]ExpiringValue) map[string][]byte {
+	results := make(map[string][]byte, len(stored))
+	for key, value := range stored {
+		results[key] = value.Value
+	}
+	return results
+}
+
+// Storage persists the cache. Scan walks the keys with prefix one page at a
+// time: pass "" to start and the returned cursor to continue; an empty cursor
+// means the scan is done. Pages hold up to limit keys, in key order except on
+// Redis, where limit is a hint and a key may be returned twice.
 type Storage interface {
 	Set(ctx context.Context, key string, value []byte) error
-	Get(ctx context.Context, key string) ([]byte, error)
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error // The backend expires the key after ttl; 0 never expires
+	Get(ctx context.Context, key string) ([]byte, error)                               // Returns nil, nil for a missing key
 	Delete(ctx context.Context, key string) error
+	DeleteBatch(ctx context.Context, keys []string) error // Deletes all keys atomically
 	GetAllEmbeddings(ctx context.Context) (map[string][]byte, error)
 	GetPrompt(ctx context.Context, key string) (string, error)
+	PutEntry(ctx context.Context, entry *Entry) error         // Writes all records atomically, expiring after entry.Metadata.TTL
+	GetEntry(ctx context.Context, key string) (*Entry, error) // Reads all records of an entry
+	RecordHit(ctx context.Context, key string) error          // Increments the hit count, keeping the remaining TTL
+	Update(ctx context.Context, key string, fn UpdateFunc) error
+	Scan(ctx context.Context, prefix, cursor string, limit int) ([]KV, string, error)
 	Close()
 }
+
+// UpdateFunc returns the new value of a key from its current value, nil if
+// the key is missing. It may be called again if another writer, possibly
+// another replica, changed the key meanwhile.
+type UpdateFunc func(value []byte) ([]byte, error)
+
+func scanLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultScanLimit
+	}
+	return limit
+}
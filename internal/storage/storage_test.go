@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBackends opens an empty store of every backend
+var testBackends = map[string]func(t *testing.T) Storage{
+	"badger": func(t *testing.T) Storage {
+		store, err := NewBadgerStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewBadgerStore failed: %v", err)
+		}
+		t.Cleanup(store.Close)
+		return store
+	},
+	"redis": func(t *testing.T) Storage {
+		store, _ := newTestRedisStore(t)
+		return store
+	},
+	"memory": func(t *testing.T) Storage {
+		store, _ := NewMemoryStore(MemoryOptions{})
+		return store
+	},
+}
+
+func TestStorage_Scan(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			ctx := context.Background()
+
+			for i := 0; i < 25; i++ {
+				store.Set(ctx, fmt.Sprintf("meta:%02d", i), []byte{byte(i)})
+			}
+			store.Set(ctx, "emb:openai:00", []byte{1})
+			store.Set(ctx, "metadata", []byte{1})
+
+			seen := make(map[string]byte)
+			cursor, pages := "", 0
+			for {
+				items, next, err := store.Scan(ctx, "meta:", cursor, 10)
+				if err != nil {
+					t.Fatalf("Scan failed: %v", err)
+				}
+				for _, item := range items {
+					seen[item.Key] = item.Value[0]
+				}
+				pages++
+				if next == "" {
+					break
+				}
+				if pages > 25 {
+					t.Fatal("Scan did not finish")
+				}
+				cursor = next
+			}
+
+			if len(seen) != 25 {
+				t.Errorf("Expected 25 keys, got %d: %v", len(seen), seen)
+			}
+			if seen["meta:07"] != 7 {
+				t.Errorf("Expected values with keys, got %v", seen["meta:07"])
+			}
+			if _, ok := seen["metadata"]; ok {
+				t.Error("Expected keys outside the prefix to be skipped")
+			}
+		})
+	}
+}
+
+func TestStorage_ScanPages(t *testing.T) {
+	// Redis pages are sized by SCAN, so only ordered backends are checked
+	for _, name := range []string{"badger", "memory"} {
+		t.Run(name, func(t *testing.T) {
+			store := testBackends[name](t)
+			ctx := context.Background()
+			for i := 0; i < 5; i++ {
+				store.Set(ctx, fmt.Sprintf("k%d", i), []byte{1})
+			}
+
+			items, next, err := store.Scan(ctx, "k", "", 2)
+			if err != nil || len(items) != 2 || items[0].Key != "k0" || next != "k1" {
+				t.Fatalf("Unexpected first page: %v, %q, %v", items, next, err)
+			}
+			items, next, _ = store.Scan(ctx, "k", "k3", 2)
+			if len(items) != 1 || items[0].Key != "k4" || next != "" {
+				t.Errorf("Unexpected last page: %v, %q", items, next)
+			}
+		})
+	}
+}
+
+func TestStorage_Update(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			ctx := context.Background()
+
+			// Concurrent updates are all applied
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						err := store.Update(ctx, "counter", func(value []byte) ([]byte, error) {
+							n, _ := strconv.Atoi(string(value))
+							return []byte(strconv.Itoa(n + 1)), nil
+						})
+						if err != nil {
+							t.Errorf("Update failed: %v", err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if value, _ := store.Get(ctx, "counter"); string(value) != "80" {
+				t.Errorf("Expected 80 updates, got %s", value)
+			}
+
+			// A failed update changes nothing
+			failed := errors.New("failed")
+			if err := store.Update(ctx, "counter", func([]byte) ([]byte, error) { return nil, failed }); !errors.Is(err, failed) {
+				t.Errorf("Expected the error of fn, got %v", err)
+			}
+			if value, _ := store.Get(ctx, "counter"); string(value) != "80" {
+				t.Errorf("Expected the value to be kept, got %s", value)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Badger(t *testing.T) {
+	t.Setenv("STORAGE_PATH", "/data/cache")
+	t.Setenv("BADGER_SYNC_WRITES", "true")
+	t.Setenv("BADGER_COMPRESSION", "ZSTD")
+	t.Setenv("BADGER_BLOCK_CACHE_SIZE", "1048576")
+	t.Setenv("BADGER_GC_INTERVAL", "1m")
+	t.Setenv("BADGER_GC_DISCARD_RATIO", "2")
+
+	opts := LoadConfig().Badger
+	if opts.Path != "/data/cache" || !opts.SyncWrites || opts.Compression != "zstd" {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+	if opts.BlockCacheSize != 1<<20 || opts.GCInterval != time.Minute {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+	if opts.GCDiscardRatio != 0.5 {
+		t.Errorf("Expected an out of range discard ratio to be ignored, got %f", opts.GCDiscardRatio)
+	}
+}
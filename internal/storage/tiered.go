@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/ristretto/v2"
+)
+
+// L1Options configures the in-process cache in front of the backend
+type L1Options struct {
+	MaxBytes      int64         // Size of the cached responses and prompts; 0 disables the L1
+	TTL           time.Duration // Longest a copy is served without reading the backend; 0 keeps copies until they are written or deleted
+	VectorRefresh time.Duration // Longest the vector index goes without a reload, if shorter than TTL; 0 only applies TTL
+}
+
+// L1Stats reports where reads were served from. L1 counts reads served from
+// memory, L2 reads that went to the backend.
+type L1Stats struct {
+	MaxBytes    int64  `json:"max_bytes"`
+	L1Hits      uint64 `json:"l1_hits"`
+	L1Misses    uint64 `json:"l1_misses"`
+	L2Hits      uint64 `json:"l2_hits"`
+	L2Misses    uint64 `json:"l2_misses"`
+	Evictions   uint64 `json:"evictions"` // Copies dropped to stay within MaxBytes
+	Rejected    uint64 `json:"rejected"`  // Copies the admission policy kept out
+	Vectors     int    `json:"vectors"`
+	VectorLoads uint64 `json:"vector_loads"` // Reads of every vector from the backend
+}
+
+// l1Vector is a vector of the index, kept both as stored and decoded
+type l1Vector struct {
+	raw       []byte
+	vec       []float32
+	expiresAt time.Time
+}
+
+// TieredStore keeps the responses and prompts read from another store in an
+// in-process cache with admission control, and every vector decoded in
+// memory, so hot entries are served without a backend read. Writes go to
+// the backend and then to memory; deletes drop the copies. Writes made by
+// other processes, e.g. replicas sharing Redis, are only seen once the L1
+// TTL has passed, and their vectors once the index is reloaded.
+type TieredStore struct {
+	store     Storage
+	ttl       time.Duration
+	vectorAge time.Duration // Age at which the vector index is reloaded; 0 never
+	maxBytes  int64
+	l1        *ristretto.Cache[string, []byte]
+
+	// Reads only fill the L1 if no write happened meanwhile, so a slow read
+	// cannot overwrite a newer value with an older one
+	fillMu sync.RWMutex
+	writes atomic.Uint64
+
+	loadMu      sync.Mutex // Serializes loads of the vector index
+	vecMu       sync.RWMutex
+	vectors     map[string]l1Vector // nil until loaded
+	vectorsAt   time.Time
+	loading     bool
+	journal     []func(map[string]l1Vector) // Writes made during a load
+	vectorLoads atomic.Uint64
+	l1Hits      atomic.Uint64
+	l1Misses    atomic.Uint64
+	l2Hits      atomic.Uint64
+	l2Misses    atomic.Uint64
+}
+
+// NewTieredStore wraps store with an in-process cache of options.MaxBytes
+func NewTieredStore(store Storage, options L1Options) (*TieredStore, error) {
+	// Ristretto wants about ten counters per item; responses average a few KB
+	l1, err := ristretto.NewCache(&ristretto.Config[string, []byte]{
+		NumCounters: max(options.MaxBytes/200, 1000),
+		MaxCost:     options.MaxBytes,
+		BufferItems: 64,
+		Metrics:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	vectorAge := options.TTL
+	if options.VectorRefresh > 0 && (vectorAge <= 0 || options.VectorRefresh < vectorAge) {
+		vectorAge = options.VectorRefresh
+	}
+	return &TieredStore{store: store, ttl: options.TTL, vectorAge: vectorAge, maxBytes: options.MaxBytes, l1: l1}, nil
+}
+
+// Unwrap returns the backend behind the L1
+func (s *TieredStore) Unwrap() Storage {
+	return s.store
+}
+
+// l1TTL returns how long a copy of a record expiring after ttl may be kept
+func (s *TieredStore) l1TTL(ttl time.Duration) time.Duration {
+	if s.ttl > 0 && (ttl <= 0 || s.ttl < ttl) {
+		return s.ttl
+	}
+	return ttl
+}
+
+// put writes a record through to the L1. Deleting first keeps an older copy
+// still waiting in ristretto's buffers from being applied after this one.
+func (s *TieredStore) put(key string, value []byte, ttl time.Duration) {
+	s.l1.Del(key)
+	if value != nil {
+		s.l1.SetWithTTL(key, append([]byte{}, value...), int64(len(key)+len(value)), s.l1TTL(ttl))
+	}
+}
+
+// write applies a write that reached the backend, or may have, to the L1 and
+// the vector index
+func (s *TieredStore) write(records map[string][]byte, ttl time.Duration) {
+	s.fillMu.Lock()
+	s.writes.Add(1)
+	for key, value := range records {
+		// Metadata changes on every hit and is always read from the backend
+		if userDataKey(key) {
+			s.put(key, value, ttl)
+		}
+	}
+	s.fillMu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	s.updateVectors(func(vectors map[string]l1Vector) {
+		for key, value := range records {
+			if !strings.HasPrefix(key, EmbeddingPrefix) {
+				continue
+			}
+			if value == nil {
+				delete(vectors, key)
+			} else {
+				vectors[key] = newL1Vector(value, expiresAt)
+			}
+		}
+	})
+}
+
+// invalidate drops the copies of keys after they were deleted, or may have been
+func (s *TieredStore) invalidate(keys ...string) {
+	records := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		records[key] = nil
+	}
+	s.write(records, 0)
+}
+
+func newL1Vector(raw []byte, expiresAt time.Time) l1Vector {
+	raw = append([]byte{}, raw...)
+	vec := make([]float32, len(raw)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+	}
+	return l1Vector{raw: raw, vec: vec, expiresAt: expiresAt}
+}
+
+// updateVectors applies fn to the vector index once it is loaded; writes
+// made while it loads are replayed on the loaded index
+func (s *TieredStore) updateVectors(fn func(map[string]l1Vector)) {
+	s.vecMu.Lock()
+	defer s.vecMu.Unlock()
+	if s.vectors != nil {
+		fn(s.vectors)
+	}
+	if s.loading {
+		s.journal = append(s.journal, fn)
+	}
+}
+
+// vectorsFresh reports whether the vector index is loaded and young enough
+// to serve. The caller holds vecMu.
+func (s *TieredStore) vectorsFresh() bool {
+	return s.vectors != nil && (s.vectorAge <= 0 || time.Since(s.vectorsAt) < s.vectorAge)
+}
+
+// loadVectors loads the vector index from the backend the first time and
+// again once it is older than the L1 TTL or VectorRefresh
+func (s *TieredStore) loadVectors(ctx context.Context) error {
+	s.vecMu.RLock()
+	fresh := s.vectorsFresh()
+	s.vecMu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	s.vecMu.Lock()
+	if s.vectorsFresh() {
+		s.vecMu.Unlock()
+		return nil
+	}
+	s.loading = true
+	s.journal = nil
+	s.vecMu.Unlock()
+
+	loadedAt := time.Now()
+	stored, err := embeddingsWithExpiry(ctx, s.store)
+
+	s.vecMu.Lock()
+	defer s.vecMu.Unlock()
+	s.loading = false
+	if err != nil {
+		s.journal = nil
+		return err
+	}
+	vectors := make(map[string]l1Vector, len(stored))
+	// Loaded vectors expire with their records, e.g. by Badger's native TTL
+	for key, value := range stored {
+		vectors[key] = newL1Vector(value.Value, value.ExpiresAt)
+	}
+	for _, fn := range s.journal {
+		fn(vectors)
+	}
+	s.journal = nil
+	s.vectors = vectors
+	s.vectorsAt = loadedAt
+	s.vectorLoads.Add(1)
+	return nil
+}
+
+// GetAllVectors returns every stored vector decoded, so similarity search
+// does not decode them on every lookup. The vectors must not be modified.
+func (s *TieredStore) GetAllVectors(ctx context.Context) (map[string][]float32, error) {
+	if err := s.loadVectors(ctx); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.vecMu.RLock()
+	defer s.vecMu.RUnlock()
+	vectors := make(map[string][]float32, len(s.vectors))
+	for key, v := range s.vectors {
+		if v.expiresAt.IsZero() || now.Before(v.expiresAt) {
+			vectors[key] = v.vec
+		}
+	}
+	return vectors, nil
+}
+
+func (s *TieredStore) GetAllEmbeddings(ctx context.Context) (map[string][]byte, error) {
+	if err := s.loadVectors(ctx); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.vecMu.RLock()
+	defer s.vecMu.RUnlock()
+	embeddings := make(map[string][]byte, len(s.vectors))
+	for key, v := range s.vectors {
+		if v.expiresAt.IsZero() || now.Before(v.expiresAt) {
+			embeddings[key] = append([]byte{}, v.raw...)
+		}
+	}
+	return embeddings, nil
+}
+
+func (s *TieredStore) Set(ctx context.Context, key string, value []byte) error {
+	return s.SetWithTTL(ctx, key, value, 0)
+}
+
+func (s *TieredStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var err error
+	if ttl > 0 {
+		err = s.store.SetWithTTL(ctx, key, value, ttl)
+	} else {
+		err = s.store.Set(ctx, key, value)
+	}
+	if err != nil {
+		// The write may have reached the backend anyway
+		s.invalidate(key)
+		return err
+	}
+	s.write(map[string][]byte{key: value}, ttl)
+	return nil
+}
+
+func (s *TieredStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if !userDataKey(key) {
+		return s.store.Get(ctx, key)
+	}
+	if value, ok := s.l1.Get(key); ok {
+		s.l1Hits.Add(1)
+		return value, nil
+	}
+	s.l1Misses.Add(1)
+
+	writes := s.writes.Load()
+	value, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		s.l2Misses.Add(1)
+		return nil, nil
+	}
+	s.l2Hits.Add(1)
+
+	s.fillMu.RLock()
+	if s.writes.Load() == writes {
+		s.l1.SetWithTTL(key, value, int64(len(key)+len(value)), s.ttl)
+	}
+	s.fillMu.RUnlock()
+	return value, nil
+}
+
+func (s *TieredStore) GetPrompt(ctx context.Context, key string) (string, error) {
+	value, err := s.Get(ctx, PromptPrefix+key)
+	if err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "", ErrNotFound
+	}
+	return string(value), nil
+}
+
+func (s *TieredStore) Delete(ctx context.Context, key string) error {
+	err := s.store.Delete(ctx, key)
+	s.invalidate(key)
+	return err
+}
+
+func (s *TieredStore) DeleteBatch(ctx context.Context, keys []string) error {
+	err := s.store.DeleteBatch(ctx, keys)
+	s.invalidate(keys...)
+	return err
+}
+
+func (s *TieredStore) PutEntry(ctx context.Context, entry *Entry) error {
+	records := map[string][]byte{entry.Key: entry.Value, PromptPrefix + entry.Key: entry.Prompt}
+	for key, vec := range entry.Embeddings {
+		records[key] = vec
+	}
+
+	if err := s.store.PutEntry(ctx, entry); err != nil {
+		keys := make([]string, 0, len(records))
+		for key := range records {
+			keys = append(keys, key)
+		}
+		s.invalidate(keys...)
+		return err
+	}
+	s.write(records, entry.ttl(time.Now()))
+	return nil
+}
+
+// GetEntry reads the backend: it is used by maintenance and the admin API,
+// which need the current metadata, rather than to serve hits
+func (s *TieredStore) GetEntry(ctx context.Context, key string) (*Entry, error) {
+	return s.store.GetEntry(ctx, key)
+}
+
+func (s *TieredStore) RecordHit(ctx context.Context, key string) error {
+	return s.store.RecordHit(ctx, key)
+}
+
+// Update passes through to the backend and drops the copy of key, whose
+// remaining TTL the L1 does not know
+func (s *TieredStore) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	err := s.store.Update(ctx, key, fn)
+	s.invalidate(key)
+	return err
+}
+
+func (s *TieredStore) Scan(ctx context.Context, prefix, cursor string, limit int) ([]KV, string, error) {
+	return s.store.Scan(ctx, prefix, cursor, limit)
+}
+
+func (s *TieredStore) Close() {
+	s.l1.Close()
+	s.store.Close()
+}
+
+// Purge drops every copy, e.g. after the backend was restored from a backup
+func (s *TieredStore) Purge() {
+	s.fillMu.Lock()
+	s.writes.Add(1)
+	s.l1.Clear()
+	s.fillMu.Unlock()
+
+	// A load in progress would bring back the records read before
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	s.vecMu.Lock()
+	s.vectors = nil
+	s.vecMu.Unlock()
+}
+
+// Stats reports the reads served by each tier since startup
+func (s *TieredStore) Stats() L1Stats {
+	s.vecMu.RLock()
+	vectors := len(s.vectors)
+	s.vecMu.RUnlock()
+
+	metrics := s.l1.Metrics
+	return L1Stats{
+		MaxBytes:    s.maxBytes,
+		L1Hits:      s.l1Hits.Load(),
+		L1Misses:    s.l1Misses.Load(),
+		L2Hits:      s.l2Hits.Load(),
+		L2Misses:    s.l2Misses.Load(),
+		Evictions:   metrics.KeysEvicted(),
+		Rejected:    metrics.SetsRejected(),
+		Vectors:     vectors,
+		VectorLoads: s.vectorLoads.Load(),
+	}
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestTieredStore(t *testing.T, base Storage, ttl time.Duration) *TieredStore {
+	t.Helper()
+	store, err := NewTieredStore(base, L1Options{MaxBytes: 1 << 20, TTL: ttl})
+	if err != nil {
+		t.Fatalf("NewTieredStore failed: %v", err)
+	}
+	t.Cleanup(store.l1.Close)
+	return store
+}
+
+func TestTieredStore(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			base := open(t)
+			store := newTestTieredStore(t, base, 0)
+			ctx := context.Background()
+
+			vec := []byte{0, 0, 128, 63} // 1.0
+			err := store.PutEntry(ctx, &Entry{
+				Key:        "hash",
+				Value:      []byte("response"),
+				Prompt:     []byte("prompt"),
+				Embeddings: map[string][]byte{"emb:openai:hash": vec},
+				Metadata:   EntryMetadata{TTL: time.Hour},
+			})
+			if err != nil {
+				t.Fatalf("PutEntry failed: %v", err)
+			}
+			store.l1.Wait()
+
+			// Written through: the first reads are served from memory
+			if value, _ := store.Get(ctx, "hash"); string(value) != "response" {
+				t.Errorf("Expected the response, got %q", value)
+			}
+			if prompt, _ := store.GetPrompt(ctx, "hash"); prompt != "prompt" {
+				t.Errorf("Expected the prompt, got %q", prompt)
+			}
+			if stats := store.Stats(); stats.L1Hits != 2 || stats.L2Hits != 0 {
+				t.Errorf("Expected 2 L1 hits, got %+v", stats)
+			}
+
+			// Other records are read from the backend and kept
+			base.Set(ctx, "other", []byte("other response"))
+			for i := 0; i < 2; i++ {
+				if value, _ := store.Get(ctx, "other"); string(value) != "other response" {
+					t.Errorf("Expected the other response, got %q", value)
+				}
+				store.l1.Wait()
+			}
+			store.Get(ctx, "missing")
+			if stats := store.Stats(); stats.L1Hits != 3 || stats.L1Misses != 2 || stats.L2Hits != 1 || stats.L2Misses != 1 {
+				t.Errorf("Unexpected stats: %+v", stats)
+			}
+
+			vectors, err := store.GetAllVectors(ctx)
+			if err != nil {
+				t.Fatalf("GetAllVectors failed: %v", err)
+			}
+			if v := vectors["emb:openai:hash"]; len(v) != 1 || v[0] != 1 {
+				t.Errorf("Expected the decoded vector, got %v", vectors)
+			}
+
+			// Vectors written after the index loaded are added to it
+			store.Set(ctx, "emb:openai:other", vec)
+			if embeddings, _ := store.GetAllEmbeddings(ctx); len(embeddings) != 2 {
+				t.Errorf("Expected 2 vectors, got %d", len(embeddings))
+			}
+			if stats := store.Stats(); stats.VectorLoads != 1 || stats.Vectors != 2 {
+				t.Errorf("Expected the index to be loaded once, got %+v", stats)
+			}
+
+			// Deletes drop the copies
+			if err := store.DeleteBatch(ctx, []string{"hash", "prompt:hash", "meta:hash", "emb:openai:hash"}); err != nil {
+				t.Fatalf("DeleteBatch failed: %v", err)
+			}
+			store.Delete(ctx, "other")
+			for _, key := range []string{"hash", "other"} {
+				if value, _ := store.Get(ctx, key); value != nil {
+					t.Errorf("Expected %s to be deleted, got %q", key, value)
+				}
+			}
+			if _, err := store.GetPrompt(ctx, "hash"); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound, got %v", err)
+			}
+			if vectors, _ := store.GetAllVectors(ctx); len(vectors) != 1 {
+				t.Errorf("Expected the deleted vector to be dropped, got %v", vectors)
+			}
+		})
+	}
+}
+
+func TestTieredStore_TTL(t *testing.T) {
+	base, _ := NewMemoryStore(MemoryOptions{})
+	store := newTestTieredStore(t, base, 50*time.Millisecond)
+	ctx := context.Background()
+
+	store.Set(ctx, "hash", []byte("old"))
+	store.Set(ctx, "emb:openai:hash", []byte{0, 0, 128, 63})
+	store.GetAllVectors(ctx)
+	store.l1.Wait()
+
+	// Another replica writes to the backend
+	base.Set(ctx, "hash", []byte("new"))
+	base.Set(ctx, "emb:openai:other", []byte{0, 0, 128, 63})
+	if value, _ := store.Get(ctx, "hash"); string(value) != "old" {
+		t.Errorf("Expected the copy to be served within the TTL, got %q", value)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if value, _ := store.Get(ctx, "hash"); string(value) != "new" {
+		t.Errorf("Expected the backend to be read after the TTL, got %q", value)
+	}
+	if vectors, _ := store.GetAllVectors(ctx); len(vectors) != 2 {
+		t.Errorf("Expected the index to be reloaded after the TTL, got %d vectors", len(vectors))
+	}
+}
+
+func TestTieredStore_VectorRefresh(t *testing.T) {
+	base, _ := NewMemoryStore(MemoryOptions{})
+	store, err := NewTieredStore(base, L1Options{MaxBytes: 1 << 20, VectorRefresh: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewTieredStore failed: %v", err)
+	}
+	t.Cleanup(store.l1.Close)
+	ctx := context.Background()
+
+	store.Set(ctx, "emb:openai:hash", []byte{0, 0, 128, 63})
+	store.GetAllVectors(ctx)
+
+	// Another replica adds a vector; copies never expire without a TTL, but
+	// the index is still reloaded
+	base.Set(ctx, "emb:openai:other", []byte{0, 0, 128, 63})
+	if vectors, _ := store.GetAllVectors(ctx); len(vectors) != 1 {
+		t.Errorf("Expected the loaded index within the refresh interval, got %d vectors", len(vectors))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if vectors, _ := store.GetAllVectors(ctx); len(vectors) != 2 {
+		t.Errorf("Expected the index to be reloaded, got %d vectors", len(vectors))
+	}
+}
+
+func TestTieredStore_LoadedVectorsExpire(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			base := open(t)
+			ctx := context.Background()
+
+			// Written by another process, so only known from a load
+			base.SetWithTTL(ctx, "emb:openai:short", []byte{0, 0, 128, 63}, time.Hour)
+			base.Set(ctx, "emb:openai:forever", []byte{0, 0, 128, 63})
+
+			store := newTestTieredStore(t, base, 0)
+			if vectors, _ := store.GetAllVectors(ctx); len(vectors) != 2 {
+				t.Fatalf("Expected both vectors to be loaded, got %d", len(vectors))
+			}
+			store.vecMu.RLock()
+			short, forever := store.vectors["emb:openai:short"], store.vectors["emb:openai:forever"]
+			store.vecMu.RUnlock()
+			if until := time.Until(short.expiresAt); until <= 0 || until > time.Hour+time.Second {
+				t.Errorf("Expected the loaded vector to expire with its record, got %v", short.expiresAt)
+			}
+			if !forever.expiresAt.IsZero() {
+				t.Errorf("Expected a vector without a TTL to never expire, got %v", forever.expiresAt)
+			}
+		})
+	}
+}
+
+func TestTieredStore_Purge(t *testing.T) {
+	base, _ := NewMemoryStore(MemoryOptions{})
+	store := newTestTieredStore(t, base, 0)
+	ctx := context.Background()
+
+	store.Set(ctx, "hash", []byte("old"))
+	store.GetAllVectors(ctx)
+	store.l1.Wait()
+
+	// A restore writes to the backend directly
+	base.Set(ctx, "hash", []byte("restored"))
+	base.Set(ctx, "emb:openai:hash", []byte{0, 0, 128, 63})
+	store.Purge()
+
+	if value, _ := store.Get(ctx, "hash"); string(value) != "restored" {
+		t.Errorf("Expected the restored value, got %q", value)
+	}
+	if vectors, _ := store.GetAllVectors(ctx); len(vectors) != 1 {
+		t.Errorf("Expected the restored vector, got %v", vectors)
+	}
+}
+
+func TestOpen_L1(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "badger")
+	t.Setenv("BADGER_IN_MEMORY", "true")
+	t.Setenv("CACHE_L1_MAX_BYTES", "1048576")
+	t.Setenv("CACHE_L1_TTL", "30s")
+
+	config := LoadConfig()
+	if config.L1 != (L1Options{MaxBytes: 1 << 20, TTL: 30 * time.Second, VectorRefresh: time.Minute}) {
+		t.Errorf("Unexpected L1 options: %+v", config.L1)
+	}
+	store, err := Open(config)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+	if tiered, ok := store.(*TieredStore); !ok {
+		t.Errorf("Expected a tiered store, got %T", store)
+	} else if tiered.vectorAge != 30*time.Second {
+		// No other process writes to a badger store
+		t.Errorf("Expected the index to be reloaded after the TTL only, got %s", tiered.vectorAge)
+	}
+	if _, ok := Layer[*BadgerStore](store); !ok {
+		t.Error("Expected Layer to find the badger store")
+	}
+
+	// The memory backend is in process already
+	config.Backend = "memory"
+	memory, _ := Open(config)
+	defer memory.Close()
+	if _, ok := memory.(*MemoryStore); !ok {
+		t.Errorf("Expected the memory store without an L1, got %T", memory)
+	}
+}